@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"scrapeNPM/internal/fakeregistry"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8999", "address to listen on")
+	fixturesDir := flag.String("fixtures", "internal/fakeregistry/testdata/basic", "fixture directory to serve")
+	delay := flag.Duration("delay", 0, "delay added to every response")
+	failPath := flag.String("fail-path", "", "path prefix to inject failures on")
+	failStatus := flag.Int("fail-status", http.StatusInternalServerError, "status code returned for injected failures")
+	failTimes := flag.Int("fail-times", 0, "number of failures to inject (0 means every request)")
+	malformed := flag.Bool("malformed", false, "return malformed JSON on -fail-path instead of an error status")
+	flag.Parse()
+
+	fixtures, err := fakeregistry.LoadFixtures(*fixturesDir)
+	if err != nil {
+		log.Fatalf("Failed to load fixtures: %v", err)
+	}
+
+	server := fakeregistry.New(fixtures)
+	if *delay > 0 {
+		server.InjectDelay("/", *delay, 0)
+	}
+	if *failPath != "" {
+		if *malformed {
+			server.InjectMalformedJSON(*failPath, *failTimes)
+		} else {
+			server.InjectStatus(*failPath, *failStatus, *failTimes)
+		}
+	}
+
+	baseURL := "http://" + *addr
+	log.Printf("Serving %d packages from %s on %s", len(fixtures.Packuments), *fixturesDir, baseURL)
+	log.Printf("Point the scraper at it with:")
+	log.Printf("  NPM_REGISTRY_URL=%s NPM_REPLICATE_URL=%s/registry NPM_DOWNLOADS_URL=%s", baseURL, baseURL, baseURL)
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           server,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := httpServer.ListenAndServe(); err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
+}
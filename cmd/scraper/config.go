@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"scrapeNPM/internal/config"
+)
+
+// runConfigCommand prints the configuration the scraper runs with, given the
+// same file, environment and flags, with secrets redacted.
+func runConfigCommand(cfg config.Config, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("usage: scraper [flags] config")
+	}
+	return cfg.Print(os.Stdout)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// runEventsCommand lists recorded maintainer and publisher changes, newest
+// first.
+func runEventsCommand(cfg config.Config, args []string) error {
+	var q processor.EventQuery
+	var since time.Duration
+	fs := flag.NewFlagSet("events", flag.ContinueOnError)
+	fs.StringVar(&q.Type, "type", "", "only events of this type: maintainer_added, maintainer_removed or new_publisher")
+	fs.StringVar(&q.Package, "package", "", "only events of this package")
+	fs.DurationVar(&since, "since", 0, "only events recorded within this long")
+	fs.IntVar(&q.Limit, "limit", 50, "at most this many events, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: scraper events [-type t] [-package name] [-since d] [-limit n]")
+	}
+	switch q.Type {
+	case "", models.EventMaintainerAdded, models.EventMaintainerRemoved, models.EventNewPublisher:
+	default:
+		return fmt.Errorf("unknown event type %q", q.Type)
+	}
+	if since > 0 {
+		q.Since = time.Now().Add(-since)
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	events, err := processor.NewRepository(database.Pool).ListEvents(context.Background(), q)
+	if err != nil {
+		return err
+	}
+
+	if len(events) == 0 {
+		fmt.Println("No events")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "RECORDED\tPACKAGE\tEVENT\tACCOUNT\tVERSION\tINSTALL SCRIPTS")
+	for _, e := range events {
+		version, scripts := "-", "-"
+		if e.Type == models.EventNewPublisher {
+			version, scripts = e.Version, "no"
+			if e.HasInstallScripts {
+				scripts = "yes"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.CreatedAt.Format(time.RFC3339), e.PackageName, e.Type, e.Account, version, scripts)
+	}
+	return tw.Flush()
+}
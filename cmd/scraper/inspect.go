@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/inspect"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// runInspectCommand prints a triage report for one package, built from the
+// registry or, with -stored, from the database.
+func runInspectCommand(cfg config.Config, args []string) error {
+	var asJSON, stored bool
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	fs.BoolVar(&asJSON, "json", false, "print the report as JSON")
+	fs.BoolVar(&stored, "stored", false, "report what is stored instead of fetching the package")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: scraper inspect [-json] [-stored] <package>[@version]")
+	}
+	name, version := inspect.ParseSpec(fs.Arg(0))
+
+	ctx := context.Background()
+	builder := inspect.NewBuilder()
+
+	var report *inspect.Report
+	if stored {
+		database, err := db.Connect(cfg.DB)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		result, err := processor.NewRepository(database.Pool).StoredPackage(ctx, name)
+		if err != nil {
+			return err
+		}
+		if result == nil {
+			return fmt.Errorf("%s is not stored", name)
+		}
+		if version != "" && version != result.Package.Version {
+			return fmt.Errorf("%s is stored at version %s, not %s", name, result.Package.Version, version)
+		}
+		report = builder.FromStored(*result)
+	} else {
+		client, err := discovery.NewClientWithConfig(cfg.Registry)
+		if err != nil {
+			return err
+		}
+
+		raw, err := client.GetPackage(ctx, name)
+		if err != nil {
+			return err
+		}
+		if report, err = builder.FromPackument(name, version, raw); err != nil {
+			return err
+		}
+
+		downloads, err := client.GetDownloadCount(ctx, name)
+		if err != nil {
+			slog.Warn("Failed to fetch download count", logging.Err(err))
+		}
+		report.Package.Downloads = downloads
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	return printReport(os.Stdout, report)
+}
+
+func printReport(w io.Writer, r *inspect.Report) error {
+	pkg := r.Package
+	fmt.Fprintf(w, "%s@%s\n", pkg.Name, pkg.Version)
+	if pkg.Description != "" {
+		fmt.Fprintln(w, pkg.Description)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	field := func(label, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s\t%s\n", label, value)
+		}
+	}
+	if r.Latest != pkg.Version {
+		field("Latest", r.Latest)
+	}
+	field("License", pkg.License)
+	field("Author", pkg.Author)
+	field("Homepage", pkg.Homepage)
+	field("Repository", pkg.Repository)
+	field("Created", formatDate(pkg.CreatedAt))
+	published := formatDate(r.Published)
+	if r.Publisher != nil {
+		published = strings.TrimSpace(published + " by " + r.Publisher.String())
+	}
+	field("Published", published)
+	field("Downloads", fmt.Sprintf("%d last month", pkg.Downloads))
+	if r.Versions > 0 {
+		field("Versions", fmt.Sprint(r.Versions))
+	}
+	if d := r.Dependencies; d != nil {
+		field("Dependencies", fmt.Sprintf("%d (%d dev, %d optional, %d peer)", d.Runtime, d.Dev, d.Optional, d.Peer))
+	}
+	if r.Source == inspect.SourceDatabase {
+		field("Stored", formatDate(pkg.LastUpdated))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nMaintainers")
+	if len(r.Maintainers) == 0 {
+		fmt.Fprintln(w, "  none listed")
+	}
+	for _, m := range r.Maintainers {
+		fmt.Fprintf(w, "  %s\n", m)
+	}
+
+	fmt.Fprintln(w, "\nLifecycle scripts")
+	if len(r.Scripts) == 0 {
+		fmt.Fprintln(w, "  none")
+	}
+	for _, s := range r.Scripts {
+		fmt.Fprintf(w, "  %s: %s\n", s.ScriptType, s.Content)
+		printFindings(w, s.Findings, "    ")
+	}
+
+	if counts := r.Findings(); len(counts) > 0 {
+		var parts []string
+		for i := len(analysis.Severities) - 1; i >= 0; i-- {
+			if n := counts[analysis.Severities[i]]; n > 0 {
+				parts = append(parts, fmt.Sprintf("%d %s", n, analysis.Severities[i]))
+			}
+		}
+		fmt.Fprintf(w, "\nFindings: %s\n", strings.Join(parts, ", "))
+	}
+
+	if r.Source == inspect.SourceRegistry {
+		fmt.Fprintln(w, "\nScript history")
+		if len(r.History) == 0 {
+			fmt.Fprintln(w, "  no version has lifecycle scripts")
+		}
+		for _, c := range r.History {
+			line := fmt.Sprintf("  %s %s", c.Version, formatDate(c.Published))
+			if c.Publisher != nil {
+				line += " by " + c.Publisher.Name
+			}
+			if c.NewPublisher {
+				line += " (new publisher)"
+			}
+			fmt.Fprintf(w, "%s: %s %s: %s\n", line, c.Change, c.Script, truncate(c.Content, 80))
+			printFindings(w, c.Findings, "    ")
+		}
+	} else {
+		fmt.Fprintln(w, "\nScript history is only shown for reports built from the registry.")
+	}
+	return nil
+}
+
+// printFindings lists findings most severe first, marking high-severity ones
+// so that they stand out.
+func printFindings(w io.Writer, findings []models.ScriptFinding, indent string) {
+	for i := len(analysis.Severities) - 1; i >= 0; i-- {
+		severity := analysis.Severities[i]
+		marker := "  "
+		if severity == analysis.SeverityHigh {
+			marker = "!!"
+		}
+		for _, f := range findings {
+			if f.Severity == severity {
+				fmt.Fprintf(w, "%s%s %s %s: %s\n", indent, marker, strings.ToUpper(f.Severity), f.Rule, f.Match)
+			}
+		}
+	}
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format("2006-01-02")
+}
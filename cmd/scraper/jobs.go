@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+)
+
+const jobsUsage = `usage: scraper jobs <command> [flags]
+
+commands:
+  failed                 summarise failed jobs grouped by type and error class
+  list     [filters]     list failed jobs
+  attempts <job-id>      show the attempt history of a job
+  requeue  [filters]     reset failed jobs to pending with fresh attempts
+  purge    [filters]     delete failed jobs (requires a filter or -all)
+  pause    -type|-id     stop claiming a job type on every instance, or
+                         hold back individual pending jobs (-reason <text>)
+  resume   -type|-id     lift a job type pause or release paused jobs
+  cancel   -type|-id     cancel unfinished jobs; running jobs are abandoned
+                         at their worker's next lease heartbeat
+  paused                 list paused job types
+  stats    [-window d]   queue depth, throughput and latency per job type
+
+filters:
+  -class <error class>   only jobs whose last failure has this class
+  -type <job type>       only jobs of this type
+  -id <uuid>[,<uuid>]    only these jobs
+  -limit <n>             at most n jobs, oldest first
+`
+
+func runJobsCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, jobsUsage)
+		return fmt.Errorf("missing jobs command")
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := discovery.NewJobQueueRepository(database.Pool, nil)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "failed":
+		return printFailureGroups(ctx, repo)
+	case "list":
+		filter, _, err := parseJobFilter("list", args[1:])
+		if err != nil {
+			return err
+		}
+		return printFailedJobs(ctx, repo, filter)
+	case "attempts":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: scraper jobs attempts <job-id>")
+		}
+		jobID, err := uuid.Parse(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid job id %q: %w", args[1], err)
+		}
+		return printJobAttempts(ctx, repo, jobID)
+	case "requeue":
+		filter, _, err := parseJobFilter("requeue", args[1:])
+		if err != nil {
+			return err
+		}
+		n, err := repo.RequeueFailedJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Requeued %d jobs\n", n)
+		return nil
+	case "purge":
+		filter, all, err := parseJobFilter("purge", args[1:])
+		if err != nil {
+			return err
+		}
+		if !all && len(filter.IDs) == 0 && filter.JobType == "" && filter.ErrorClass == "" {
+			return fmt.Errorf("refusing to purge every failed job without -all")
+		}
+		n, err := repo.PurgeFailedJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Purged %d jobs\n", n)
+		return nil
+	case "pause":
+		return pauseJobs(ctx, repo, args[1:])
+	case "resume":
+		filter, _, err := parseJobFilter("resume", args[1:])
+		if err != nil {
+			return err
+		}
+		return resumeJobs(ctx, repo, filter)
+	case "cancel":
+		filter, _, err := parseJobFilter("cancel", args[1:])
+		if err != nil {
+			return err
+		}
+		n, err := repo.CancelJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cancelled %d jobs\n", n)
+		return nil
+	case "paused":
+		return printPausedJobTypes(ctx, repo)
+	case "stats":
+		return printQueueStats(ctx, repo, cfg.Stats.Window, args[1:])
+	default:
+		fmt.Fprint(os.Stderr, jobsUsage)
+		return fmt.Errorf("unknown jobs command %q", args[0])
+	}
+}
+
+func parseJobFilter(name string, args []string) (discovery.JobFilter, bool, error) {
+	var filter discovery.JobFilter
+	var ids string
+	var all bool
+
+	fs := flag.NewFlagSet("jobs "+name, flag.ContinueOnError)
+	fs.StringVar(&filter.ErrorClass, "class", "", "error class")
+	fs.StringVar(&filter.JobType, "type", "", "job type")
+	fs.StringVar(&ids, "id", "", "comma-separated job ids")
+	fs.IntVar(&filter.Limit, "limit", 0, "maximum number of jobs")
+	fs.BoolVar(&all, "all", false, "match every failed job")
+	if err := fs.Parse(args); err != nil {
+		return filter, false, err
+	}
+
+	if ids != "" {
+		for _, raw := range strings.Split(ids, ",") {
+			id, err := uuid.Parse(strings.TrimSpace(raw))
+			if err != nil {
+				return filter, false, fmt.Errorf("invalid job id %q: %w", raw, err)
+			}
+			filter.IDs = append(filter.IDs, id)
+		}
+	}
+
+	return filter, all, nil
+}
+
+func pauseJobs(ctx context.Context, repo *discovery.JobQueueRepository, args []string) error {
+	var jobType, ids, reason string
+
+	fs := flag.NewFlagSet("jobs pause", flag.ContinueOnError)
+	fs.StringVar(&jobType, "type", "", "job type to pause on every instance")
+	fs.StringVar(&ids, "id", "", "comma-separated pending job ids to hold back")
+	fs.StringVar(&reason, "reason", "", "why the job type is paused")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch {
+	case jobType != "" && ids == "":
+		if err := repo.PauseJobType(ctx, jobType, reason); err != nil {
+			return err
+		}
+		fmt.Printf("Paused job type %s\n", jobType)
+		return nil
+	case ids != "" && jobType == "":
+		filter, _, err := parseJobFilter("pause", []string{"-id", ids})
+		if err != nil {
+			return err
+		}
+		n, err := repo.PauseJobs(ctx, filter.IDs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Paused %d jobs\n", n)
+		return nil
+	default:
+		return fmt.Errorf("usage: scraper jobs pause -type <job type> [-reason <text>] | -id <uuid>[,<uuid>]")
+	}
+}
+
+func resumeJobs(ctx context.Context, repo *discovery.JobQueueRepository, filter discovery.JobFilter) error {
+	switch {
+	case filter.JobType != "" && len(filter.IDs) == 0:
+		resumed, err := repo.ResumeJobType(ctx, filter.JobType)
+		if err != nil {
+			return err
+		}
+		if !resumed {
+			return fmt.Errorf("job type %s is not paused", filter.JobType)
+		}
+		fmt.Printf("Resumed job type %s\n", filter.JobType)
+		return nil
+	case len(filter.IDs) > 0 && filter.JobType == "":
+		n, err := repo.ResumeJobs(ctx, filter.IDs)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Resumed %d jobs\n", n)
+		return nil
+	default:
+		return fmt.Errorf("usage: scraper jobs resume -type <job type> | -id <uuid>[,<uuid>]")
+	}
+}
+
+func printPausedJobTypes(ctx context.Context, repo *discovery.JobQueueRepository) error {
+	paused, err := repo.ListPausedJobTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(paused) == 0 {
+		fmt.Println("No paused job types")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tPAUSED AT\tREASON")
+	for _, p := range paused {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", p.JobType, p.PausedAt.Format(time.RFC3339), p.Reason)
+	}
+	return tw.Flush()
+}
+
+// runStatsCommand is jobs stats.
+func runStatsCommand(cfg config.Config, args []string) error {
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := discovery.NewJobQueueRepository(database.Pool, nil)
+	return printQueueStats(context.Background(), repo, cfg.Stats.Window, args)
+}
+
+func printQueueStats(ctx context.Context, repo *discovery.JobQueueRepository, window time.Duration, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	fs.DurationVar(&window, "window", window, "period rates and durations cover")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	queueStats, err := repo.GetQueueStats(ctx, window)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tPENDING\tPROCESSING\tFAILED\tOLDEST PENDING\tENQ/MIN\tDONE/MIN\tFAIL/MIN\tP50\tP95\tRETRY RATE")
+	for _, t := range queueStats.Types {
+		oldest := time.Duration(t.OldestPendingSeconds * float64(time.Second)).Round(time.Second)
+		p50 := time.Duration(t.P50Seconds * float64(time.Second)).Round(time.Millisecond)
+		p95 := time.Duration(t.P95Seconds * float64(time.Second)).Round(time.Millisecond)
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%.1f\t%.1f\t%.1f\t%s\t%s\t%.1f%%\n",
+			t.JobType, t.Pending, t.Processing, t.Failed, oldest,
+			t.EnqueuedPerMinute, t.CompletedPerMinute, t.FailedPerMinute, p50, p95, t.RetryRate*100)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(queueStats.FailureClasses) > 0 {
+		fmt.Printf("\nFailures in the last %s:\n", window)
+		tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tCLASS\tCOUNT")
+		for _, c := range queueStats.FailureClasses {
+			fmt.Fprintf(tw, "%s\t%s\t%d\n", c.JobType, c.ErrorClass, c.Count)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	d := queueStats.Discovery
+	fmt.Printf("\nDiscovery at sequence %s, %d changes processed, last checkpoint %s ago\n",
+		d.LastSequence, d.TotalProcessed, time.Duration(d.CheckpointSeconds*float64(time.Second)).Round(time.Second))
+	return nil
+}
+
+func printFailureGroups(ctx context.Context, repo *discovery.JobQueueRepository) error {
+	groups, err := repo.ListFailureGroups(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No failed jobs")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TYPE\tCLASS\tCOUNT\tOLDEST JOB\tLAST FAILURE\tSAMPLE ERROR")
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			g.JobType, g.ErrorClass, g.Count, g.OldestJob.Format(time.RFC3339),
+			g.LastFailure.Format(time.RFC3339), truncate(g.SampleError, 80))
+	}
+	return tw.Flush()
+}
+
+func printFailedJobs(ctx context.Context, repo *discovery.JobQueueRepository, filter discovery.JobFilter) error {
+	jobs, err := repo.ListFailedJobs(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTYPE\tCLASS\tATTEMPTS\tCREATED\tPAYLOAD\tERROR")
+	for _, job := range jobs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%s\t%v\t%s\n",
+			job.ID, job.Type, job.ErrorClass, job.Attempts, job.MaxAttempts,
+			job.CreatedAt.Format(time.RFC3339), job.Payload["package_name"], truncate(job.ErrorMessage, 80))
+	}
+	return tw.Flush()
+}
+
+func printJobAttempts(ctx context.Context, repo *discovery.JobQueueRepository, jobID uuid.UUID) error {
+	attempts, err := repo.GetJobAttempts(ctx, jobID)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ATTEMPT\tFINISHED\tWORKER\tOUTCOME\tCLASS\tHTTP\tDURATION\tERROR")
+	for _, a := range attempts {
+		status := "-"
+		if a.HTTPStatus != 0 {
+			status = fmt.Sprintf("%d", a.HTTPStatus)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			a.Attempt, a.FinishedAt.Format(time.RFC3339), a.WorkerID, a.Outcome, a.ErrorClass,
+			status, a.Duration, truncate(a.ErrorMessage, 80))
+	}
+	return tw.Flush()
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
@@ -1,120 +1,108 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"log/slog"
 	"os"
-	"os/signal"
-	"path/filepath"
-	"sync"
-	"syscall"
-	"time"
+	"text/tabwriter"
 
 	"scrapeNPM/internal/config"
-	"scrapeNPM/internal/db"
-	"scrapeNPM/internal/discovery"
-	"scrapeNPM/internal/processor"
+	"scrapeNPM/internal/logging"
 )
 
-func main() {
-	log.Println("Starting NPM Registry Scraper")
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	shutdownCh := make(chan struct{})
-
-	var wg sync.WaitGroup
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(cfg config.Config, args []string) error
+}
 
-	onlyOnce := &sync.Once{}
-	setupSignalHandler(cancel, shutdownCh, onlyOnce)
+var commands = []command{
+	{"run", "[-no-discovery] [-no-workers]", "follow the changes feed and process jobs (the default)", runRunCommand},
+	{"migrate", "[up|down|status]", "apply, revert or list migrations", runMigrateCommand},
+	{"fetch", "[-dry-run] <package>", "fetch, analyze and store one package now", runFetchCommand},
+	{"inspect", "[-json] [-stored] <package>[@version]", "report metadata, maintainers, scripts, findings and script history", runInspectCommand},
+	{"enqueue", "[-priority n] <package>...", "queue packages to be fetched, - reads names from stdin", runEnqueueCommand},
+	{"backfill", "[flags]", "queue every package in the registry", runBackfillCommand},
+	{"stats", "[-window d]", "queue depth, throughput and latency per job type", runStatsCommand},
+	{"jobs", "<command>", "inspect, requeue, purge, pause and cancel jobs", runJobsCommand},
+	{"pools", "<command>", "size worker pools", runPoolsCommand},
+	{"schedules", "<command>", "manage recurring jobs", runSchedulesCommand},
+	{"scripts", "search [flags] [pattern]", "search stored install scripts", runScriptsCommand},
+	{"events", "[flags]", "list maintainer and publisher changes", runEventsCommand},
+	{"export", "[flags]", "write packages, scripts and findings as JSON lines", runExportCommand},
+	{"config", "", "print the effective configuration", runConfigCommand},
+}
 
-	cfg := config.Load()
+func main() {
+	fs := flag.NewFlagSet("scraper", flag.ContinueOnError)
+	fs.Usage = func() { usage(fs) }
 
-	database, err := db.Connect(cfg.DB)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	cfg, err := config.Load(fs, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-	defer database.Close()
-
-	log.Println("Successfully connected to database")
-
-	wd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("Failed to get working directory: %v", err)
+		log.Fatal(err)
 	}
 
-	migrationsDir := filepath.Join(wd, "migrations")
-	err = database.RunMigrations(migrationsDir)
+	logger, err := logging.New(cfg.Log, os.Stderr)
 	if err != nil {
-		log.Fatalf("Failed to run migrations: %v", err)
+		log.Fatalf("Failed to configure logging: %v", err)
 	}
+	slog.SetDefault(logger)
 
-	log.Println("Migrations completed successfully")
-
-	jobQueueRepo := discovery.NewJobQueueRepository(database.Pool)
-	npmClient := discovery.NewClient()
-
-	scraperCfg := discovery.DefaultConfig()
-	packageScraper := discovery.NewScraper(scraperCfg, npmClient, jobQueueRepo)
+	name, args := "run", fs.Args()
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		usage(fs)
+		return
+	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		log.Println("Starting package discovery scraper...")
-		if err := packageScraper.Run(ctx); err != nil {
-			log.Printf("Scraper error: %v", err)
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
 		}
-	}()
-
-	processorRepo := processor.NewRepository(database.Pool)
-	numWorkers := 10
-
-	log.Printf("Starting %d package processor workers...", numWorkers)
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		worker := processor.NewWorker(i, processorRepo, npmClient, shutdownCh)
-		go func(w *processor.Worker) {
-			defer wg.Done()
-			w.Start(ctx)
-		}(worker)
+		if err := cmd.run(cfg, args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fatal("Command failed", name, err)
+		}
+		return
 	}
 
-	<-ctx.Done()
-	log.Println("Shutting down...")
-
-	onlyOnce.Do(func() {
-		close(shutdownCh)
-	})
-
-	waitCh := make(chan struct{})
-	go func() {
-		wg.Wait()
-		close(waitCh)
-	}()
+	usage(fs)
+	fatal("Unknown command", name, nil)
+}
 
-	select {
-	case <-waitCh:
-		log.Println("All workers completed gracefully")
-	case <-time.After(5 * time.Second):
-		log.Println("Shutdown timed out after 5 seconds, some workers may not have completed")
+// fatal logs the failed command and exits. Deferred calls do not run.
+func fatal(msg, name string, err error) {
+	if err != nil {
+		slog.Error(msg, "command", name, logging.Err(err))
+	} else {
+		slog.Error(msg, "command", name)
 	}
-
-	log.Println("Shutdown complete")
+	os.Exit(1)
 }
 
-func setupSignalHandler(cancel context.CancelFunc, shutdownCh chan struct{}, onlyOnce *sync.Once) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-
-	go func() {
-		<-c
-		log.Println("Received shutdown signal, gracefully shutting down...")
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprint(w, "usage: scraper [flags] [command] [args]\n\ncommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.summary)
+	}
+	tw.Flush()
 
-		cancel()
+	fmt.Fprint(w, `
+Settings are taken from the config file, then environment variables, then
+the flags below, each overriding the last. Flags go before the command and
+are named after the setting's path in the config file.
 
-		onlyOnce.Do(func() {
-			close(shutdownCh)
-		})
-	}()
+flags:
+`)
+	fs.PrintDefaults()
 }
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/processor"
+	"scrapeNPM/migrations"
+)
+
+const migrateUsage = `usage: scraper migrate [command]
+
+commands:
+  up                 apply pending migrations (the default) and create the
+                     job archive partitions for the coming months
+  down [-steps n]    revert the last n applied migrations (default 1)
+  status             list migrations, when they were applied and whether
+                     their files have changed since
+  partitions [-ahead n] [-archive-days n]
+                     create job archive partitions through n months ahead
+                     (default 3) and drop those past the archive retention
+                     (default 180 days, 0 keeps them)
+`
+
+// partitionsAhead is how many months of archive partitions migrate up
+// creates beyond the current one.
+const partitionsAhead = 3
+
+func runMigrateCommand(cfg config.Config, args []string) error {
+	sub := "up"
+	if len(args) > 0 {
+		sub, args = args[0], args[1:]
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	migrator := db.NewMigrator(database.Pool, migrations.FS)
+	ctx := context.Background()
+
+	switch sub {
+	case "up":
+		if len(args) > 0 {
+			return fmt.Errorf("usage: scraper migrate up")
+		}
+		n, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", n)
+		return preparePartitions(ctx, processor.NewRepository(database.Pool), partitionsAhead, 0)
+	case "down":
+		var steps int
+		fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		fs.IntVar(&steps, "steps", 1, "number of migrations to revert")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if steps < 1 || fs.NArg() > 0 {
+			return fmt.Errorf("usage: scraper migrate down [-steps n], n at least 1")
+		}
+		reverted, err := migrator.Down(ctx, steps)
+		for _, name := range reverted {
+			fmt.Printf("Reverted %s\n", name)
+		}
+		return err
+	case "status":
+		if len(args) > 0 {
+			return fmt.Errorf("usage: scraper migrate status")
+		}
+		return printMigrationStatus(ctx, migrator)
+	case "partitions":
+		var ahead, archiveDays int
+		fs := flag.NewFlagSet("migrate partitions", flag.ContinueOnError)
+		fs.IntVar(&ahead, "ahead", partitionsAhead, "months of partitions to create beyond the current one")
+		fs.IntVar(&archiveDays, "archive-days", 180, "drop partitions whose jobs all finished more than this many days ago, 0 to keep them")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if ahead < 0 || archiveDays < 0 || fs.NArg() > 0 {
+			return fmt.Errorf("usage: scraper migrate partitions [-ahead n] [-archive-days n]")
+		}
+		return preparePartitions(ctx, processor.NewRepository(database.Pool), ahead, archiveDays)
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return fmt.Errorf("unknown migrate command %q", sub)
+	}
+}
+
+// preparePartitions creates the job archive partitions that pruning will
+// need and, if archiveDays is set, drops those past it, so the scraper never
+// has to run partition DDL itself.
+func preparePartitions(ctx context.Context, repo *processor.Repository, ahead, archiveDays int) error {
+	created, err := repo.PrepareArchivePartitions(ctx, ahead)
+	if err != nil {
+		return err
+	}
+	if len(created) > 0 {
+		fmt.Printf("Archive partitions exist from %s through %s\n", created[0], created[len(created)-1])
+	}
+
+	if archiveDays == 0 {
+		return nil
+	}
+	dropped, err := repo.DropArchivePartitions(ctx, time.Duration(archiveDays)*24*time.Hour)
+	for _, name := range dropped {
+		fmt.Printf("Dropped %s\n", name)
+	}
+	return err
+}
+
+func printMigrationStatus(ctx context.Context, migrator *db.Migrator) error {
+	migrations, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MIGRATION\tSTATUS\tAPPLIED AT\tDOWN")
+	pending, problems := 0, 0
+	for _, m := range migrations {
+		status, appliedAt := "applied", "-"
+		switch {
+		case m.AppliedAt == nil:
+			status = "pending"
+			pending++
+		case m.Missing() && m.Breaking:
+			status = "unknown to this binary, breaking"
+			problems++
+		case m.Missing():
+			status = "unknown to this binary"
+		case m.Modified():
+			status = "modified since applied"
+			problems++
+		}
+		if m.AppliedAt != nil {
+			appliedAt = m.AppliedAt.Format(time.RFC3339)
+		}
+		down := "no"
+		if m.Reversible {
+			down = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", m.Name, status, appliedAt, down)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("\n%d applied, %d pending", len(migrations)-pending, pending)
+	if problems > 0 {
+		fmt.Printf(", %d need attention", problems)
+	}
+	fmt.Println()
+
+	schema, err := migrator.Schema(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Schema version %d, this binary expects %d\n", schema.Version, schema.Expected)
+	if err := schema.Check(); err != nil {
+		fmt.Printf("This binary will not run against it: %v\n", err)
+	} else if len(schema.Unknown) > 0 {
+		fmt.Println("Newer migrations are not marked breaking, so this binary can run against it")
+	}
+	return nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// backfillProgressID is the scrape_progress row holding the last package
+// name queued by backfill.
+const backfillProgressID = "npm_all_docs"
+
+// runFetchCommand runs a fetch job for one package in the foreground and
+// stores the result, as a worker would.
+func runFetchCommand(cfg config.Config, args []string) error {
+	var dryRun bool
+	fs := flag.NewFlagSet("fetch", flag.ContinueOnError)
+	fs.BoolVar(&dryRun, "dry-run", false, "print the result without storing it")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: scraper fetch [-dry-run] <package>")
+	}
+
+	client, err := discovery.NewClientWithConfig(cfg.Registry)
+	if err != nil {
+		return err
+	}
+
+	handlers := fetchHandlers(client)
+
+	job, err := jobs.NewJob(jobs.TypeFetchPackage, &jobs.FetchPackagePayload{PackageName: fs.Arg(0)}, discovery.DefaultPriority)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	result, err := handlers.Run(ctx, &job)
+	if err != nil {
+		return err
+	}
+
+	if !dryRun {
+		database, err := db.Connect(cfg.DB)
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		if err := processor.NewRepository(database.Pool).StoreResults(ctx, result.Packages); err != nil {
+			return err
+		}
+	}
+
+	for _, r := range result.Packages {
+		if err := printPackageResult(r, !dryRun); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printPackageResult(r models.PackageResult, stored bool) error {
+	pkg := r.Package
+	fmt.Printf("%s@%s, %d downloads last month\n", pkg.Name, pkg.Version, pkg.Downloads)
+	if stored {
+		fmt.Printf("Stored as %s\n", pkg.ID)
+	}
+
+	if len(r.Scripts) == 0 {
+		fmt.Println("No install scripts")
+		return nil
+	}
+
+	fmt.Println()
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SCRIPT\tSEVERITY\tRULE\tMATCH")
+	for _, s := range r.Scripts {
+		if len(s.Findings) == 0 {
+			fmt.Fprintf(tw, "%s\t-\t-\t%s\n", s.ScriptType, truncate(s.Content, 80))
+			continue
+		}
+		for _, f := range s.Findings {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.ScriptType, f.Severity, f.Rule, truncate(f.Match, 80))
+		}
+	}
+	return tw.Flush()
+}
+
+// runEnqueueCommand queues fetch jobs for the named packages.
+func runEnqueueCommand(cfg config.Config, args []string) error {
+	var priority int
+	fs := flag.NewFlagSet("enqueue", flag.ContinueOnError)
+	fs.IntVar(&priority, "priority", discovery.DefaultPriority, "job priority, lower runs first")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	names := fs.Args()
+	if len(names) == 1 && names[0] == "-" {
+		var err error
+		if names, err = readLines(os.Stdin); err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("usage: scraper enqueue [-priority n] <package>... | -")
+	}
+
+	client, err := discovery.NewClientWithConfig(cfg.Registry)
+	if err != nil {
+		return err
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := discovery.NewJobQueueRepository(database.Pool, fetchHandlers(client))
+	ctx := context.Background()
+
+	for _, name := range names {
+		if err := enqueueFetch(ctx, repo, name, priority); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Queued %d packages\n", len(names))
+	return nil
+}
+
+// fetchHandlers registers the fetch handler alone, which is all the package
+// commands run or queue.
+func fetchHandlers(client *discovery.Client) *jobs.Registry {
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(client, client))
+	return handlers
+}
+
+func enqueueFetch(ctx context.Context, repo *discovery.JobQueueRepository, name string, priority int) error {
+	job, err := jobs.NewJob(jobs.TypeFetchPackage, &jobs.FetchPackagePayload{PackageName: name}, priority)
+	if err != nil {
+		return fmt.Errorf("invalid package %q: %w", name, err)
+	}
+	if _, err := repo.EnqueueJob(ctx, job); err != nil {
+		return fmt.Errorf("failed to queue %s: %w", name, err)
+	}
+	return nil
+}
+
+func readLines(f *os.File) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read package names: %w", err)
+	}
+	return lines, nil
+}
+
+// runBackfillCommand pages through _all_docs in name order and queues a
+// fetch job for every package. The last name queued is checkpointed, so an
+// interrupted backfill carries on where it stopped.
+func runBackfillCommand(cfg config.Config, args []string) error {
+	var batchSize, limit, priority int
+	var missing, restart bool
+	fs := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	fs.IntVar(&batchSize, "batch", 1000, "names read from the registry at once")
+	fs.IntVar(&limit, "limit", 0, "stop after queueing this many packages, 0 for all")
+	fs.IntVar(&priority, "priority", discovery.BackfillPriority, "job priority, lower runs first")
+	fs.BoolVar(&missing, "missing", false, "only queue packages that are not stored yet")
+	fs.BoolVar(&restart, "restart", false, "start from the first name instead of the checkpoint")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: scraper backfill [flags]")
+	}
+
+	client, err := discovery.NewClientWithConfig(cfg.Registry)
+	if err != nil {
+		return err
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := discovery.NewJobQueueRepository(database.Pool, fetchHandlers(client))
+	ctx := context.Background()
+
+	startKey, queued, err := repo.GetScrapeProgress(ctx, backfillProgressID)
+	if err != nil {
+		return err
+	}
+	if restart || (startKey == "0" && queued == 0) {
+		startKey, queued = "", 0
+	}
+	if startKey != "" {
+		slog.Info("Resuming backfill", "after", startKey, "queued", queued)
+	}
+
+	thisRun := 0
+	for limit == 0 || thisRun < limit {
+		docs, err := client.GetAllDocs(ctx, startKey, batchSize, false)
+		if err != nil {
+			return err
+		}
+
+		names := allDocsNames(docs, startKey)
+		if len(names) == 0 {
+			break
+		}
+		lastKey := names[len(names)-1]
+
+		if limit > 0 && thisRun+len(names) > limit {
+			names = names[:limit-thisRun]
+			lastKey = names[len(names)-1]
+		}
+
+		if missing {
+			known, err := repo.PackageSignals(ctx, names)
+			if err != nil {
+				return err
+			}
+			unknown := names[:0]
+			for _, name := range names {
+				if _, ok := known[name]; !ok {
+					unknown = append(unknown, name)
+				}
+			}
+			names = unknown
+		}
+
+		for _, name := range names {
+			if err := enqueueFetch(ctx, repo, name, priority); err != nil {
+				return err
+			}
+		}
+
+		thisRun += len(names)
+		queued += int64(len(names))
+		startKey = lastKey
+		if err := repo.UpdateScrapeProgress(ctx, backfillProgressID, startKey, queued); err != nil {
+			return err
+		}
+		slog.Info("Backfill batch queued", "queued", len(names), "through", startKey, "total", queued)
+
+		time.Sleep(cfg.Discovery.RequestDelay)
+	}
+
+	fmt.Printf("Queued %d packages, %d since the backfill started\n", thisRun, queued)
+	return nil
+}
+
+// allDocsNames returns the package names in an _all_docs page, leaving out
+// design documents and the start key itself, which CouchDB includes.
+func allDocsNames(docs map[string]interface{}, startKey string) []string {
+	rows, _ := docs["rows"].([]interface{})
+
+	var names []string
+	for _, row := range rows {
+		r, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := r["id"].(string)
+		if id == "" || id == startKey || strings.HasPrefix(id, "_design/") {
+			continue
+		}
+		names = append(names, id)
+	}
+	return names
+}
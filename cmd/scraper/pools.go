@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/processor"
+)
+
+const poolsUsage = `usage: scraper pools <command> [flags]
+
+commands:
+  list                   show the configured worker pools
+  set    <name> [flags]  create or update a pool
+  delete <name>          remove a pool
+
+set flags:
+  -types <type>[,<type>] job types the pool claims; empty takes every
+                         type not assigned to another pool
+  -size <n>              number of workers
+  -batch <n>             jobs claimed per worker batch
+
+Running processes apply changes within 15 seconds.
+`
+
+func runPoolsCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, poolsUsage)
+		return fmt.Errorf("missing pools command")
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := processor.NewRepository(database.Pool)
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		return printWorkerPools(ctx, repo)
+	case "set":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: scraper pools set <name> [-types ...] [-size n] [-batch n]")
+		}
+		update, err := parsePoolUpdate(args[2:])
+		if err != nil {
+			return err
+		}
+		if err := repo.SetWorkerPool(ctx, args[1], update); err != nil {
+			return err
+		}
+		return printWorkerPools(ctx, repo)
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: scraper pools delete <name>")
+		}
+		deleted, err := repo.DeleteWorkerPool(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("no worker pool named %q", args[1])
+		}
+		fmt.Printf("Deleted worker pool %s\n", args[1])
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, poolsUsage)
+		return fmt.Errorf("unknown pools command %q", args[0])
+	}
+}
+
+func parsePoolUpdate(args []string) (processor.PoolUpdate, error) {
+	var update processor.PoolUpdate
+	var types string
+	var size, batch int
+
+	fs := flag.NewFlagSet("pools set", flag.ContinueOnError)
+	fs.StringVar(&types, "types", "", "comma-separated job types")
+	fs.IntVar(&size, "size", 0, "number of workers")
+	fs.IntVar(&batch, "batch", 0, "jobs claimed per batch")
+	if err := fs.Parse(args); err != nil {
+		return update, err
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "types":
+			update.JobTypes = []string{}
+			for _, t := range strings.Split(types, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					update.JobTypes = append(update.JobTypes, t)
+				}
+			}
+		case "size":
+			if size < 0 {
+				err = fmt.Errorf("-size must not be negative")
+			}
+			update.Size = &size
+		case "batch":
+			if batch < 1 {
+				err = fmt.Errorf("-batch must be at least 1")
+			}
+			update.BatchSize = &batch
+		}
+	})
+
+	return update, err
+}
+
+func printWorkerPools(ctx context.Context, repo *processor.Repository) error {
+	pools, err := repo.ListWorkerPools(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tSIZE\tBATCH\tJOB TYPES")
+	for _, p := range pools {
+		types := strings.Join(p.JobTypes, ",")
+		if types == "" {
+			types = "(all others)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", p.Name, p.Size, p.BatchSize, types)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/health"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/metrics"
+	"scrapeNPM/internal/processor"
+	"scrapeNPM/internal/scheduler"
+	"scrapeNPM/internal/stats"
+	"scrapeNPM/internal/tracing"
+	"scrapeNPM/migrations"
+)
+
+// heldConns is how many pool connections are held indefinitely: the job
+// queue listener and the scheduler lock.
+const heldConns = 2
+
+// runRunCommand follows the changes feed and processes jobs until
+// interrupted. Either half can be switched off to run them as separate
+// deployments.
+func runRunCommand(cfg config.Config, args []string) error {
+	var noDiscovery, noWorkers bool
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.BoolVar(&noDiscovery, "no-discovery", false, "do not follow the changes feed")
+	fs.BoolVar(&noWorkers, "no-workers", false, "do not process jobs")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	slog.Info("Starting NPM Registry Scraper", "discovery", !noDiscovery, "workers", !noWorkers)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	shutdownCh := make(chan struct{})
+
+	var wg sync.WaitGroup
+
+	onlyOnce := &sync.Once{}
+	setupSignalHandler(cancel, shutdownCh, onlyOnce)
+
+	if cfg.DB.ApplicationName != "" {
+		cfg.DB.ApplicationName += " " + processor.InstanceID()
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	slog.Info("Successfully connected to database")
+
+	metrics.Registry.MustRegister(metrics.NewPoolCollector(database.Pool))
+
+	migrator := db.NewMigrator(database.Pool, migrations.FS)
+	wait := cfg.Migrations.Wait
+	if cfg.Migrations.Mode == db.MigrateAuto {
+		if _, err := migrator.Up(ctx); err != nil {
+			return fmt.Errorf("failed to run migrations: %w", err)
+		}
+		wait = 0
+	}
+
+	schema, err := migrator.WaitForSchema(ctx, wait)
+	if err != nil {
+		return fmt.Errorf("refusing to start: %w", err)
+	}
+	if len(schema.Unknown) > 0 {
+		slog.Warn("Database schema is newer than this binary but compatible",
+			"version", schema.Version, "expected", schema.Expected, "unknown", schema.Unknown)
+	}
+
+	slog.Info("Database schema is compatible", "version", schema.Version, "mode", cfg.Migrations.Mode)
+
+	npmClient, err := discovery.NewClientWithConfig(cfg.Registry)
+	if err != nil {
+		return err
+	}
+
+	processorRepo := processor.NewRepository(database.Pool)
+	processorRepo.SetLeaseDuration(cfg.Workers.LeaseDuration)
+
+	handlers := newHandlers(npmClient, processorRepo, cfg.Migrations.Mode == db.MigrateAuto)
+	jobQueueRepo := discovery.NewJobQueueRepository(database.Pool, handlers)
+
+	checker := health.NewChecker(cfg.Health.CheckTimeout)
+	checker.AddReadiness("database", database.Pool.Ping)
+	checker.AddReadiness("schema", func(ctx context.Context) error {
+		schema, err := migrator.Schema(ctx)
+		if err != nil {
+			return err
+		}
+		return schema.Check()
+	})
+
+	if !noDiscovery {
+		packageScraper := discovery.NewScraper(cfg.Discovery, npmClient, jobQueueRepo, jobQueueRepo)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slog.Info("Starting package discovery scraper")
+			if err := packageScraper.Run(ctx); err != nil {
+				slog.Error("Scraper error", logging.Err(err))
+			}
+		}()
+
+		checker.AddReadiness("changes_feed", health.ProgressCheck(packageScraper.LastProgress, cfg.Health.ProgressWindow))
+	}
+
+	jobScheduler := scheduler.NewScheduler(
+		cfg.Scheduler,
+		scheduler.NewRepository(database.Pool, jobQueueRepo),
+		db.NewAdvisoryLock(database.Pool, scheduler.LockKey),
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		jobScheduler.Run(ctx)
+	}()
+
+	reaper := processor.NewReaper(processorRepo, cfg.Workers.ReaperInterval)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reaper.Run(ctx)
+	}()
+
+	if !noWorkers {
+		notifier := processor.NewNotifier(database.Pool)
+		go notifier.Run(ctx)
+
+		workerDeps := processor.Dependencies{
+			Jobs:     processorRepo,
+			Packages: processorRepo,
+			Handlers: handlers,
+			Wakeups:  notifier,
+		}
+
+		supervisor := processor.NewSupervisor(workerDeps, processorRepo, cfg.Workers.PoolInterval, shutdownCh)
+		supervisor.SetConnectionLimit(int(database.Pool.Config().MaxConns) - heldConns)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			supervisor.Run(ctx)
+		}()
+
+		checker.AddLiveness("workers", health.StallCheck(supervisor.StalledWorkers, cfg.Health.StallTimeout))
+	}
+
+	reporter := stats.NewReporter(cfg.Stats, jobQueueRepo)
+	go reporter.Run(ctx)
+
+	var httpServer *http.Server
+	if cfg.HTTPAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/stats", reporter)
+		mux.Handle("/metrics", metrics.Handler())
+		mux.Handle("/healthz", checker.Liveness())
+		mux.Handle("/readyz", checker.Readiness())
+		httpServer = &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+
+		go func() {
+			slog.Info("Serving stats, metrics and health checks", "addr", cfg.HTTPAddr)
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("HTTP server error", logging.Err(err))
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	slog.Info("Shutting down")
+
+	if httpServer != nil {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Error shutting down HTTP server", logging.Err(err))
+		}
+		cancelShutdown()
+	}
+
+	onlyOnce.Do(func() {
+		close(shutdownCh)
+	})
+
+	waitCh := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(waitCh)
+	}()
+
+	select {
+	case <-waitCh:
+		slog.Info("All workers completed gracefully")
+	case <-time.After(5 * time.Second):
+		slog.Warn("Shutdown timed out after 5 seconds, some workers may not have completed")
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Error flushing traces", logging.Err(err))
+	}
+	cancelFlush()
+
+	slog.Info("Shutdown complete")
+	return nil
+}
+
+// newHandlers registers a handler for every job type. Partition DDL is only
+// allowed when the scraper also applies its own migrations.
+func newHandlers(client *discovery.Client, repo *processor.Repository, ddl bool) *jobs.Registry {
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(client, client))
+	handlers.MustRegister(processor.NewRefreshDownloadsHandler(repo, client))
+	handlers.MustRegister(processor.NewAnalyzeScriptsHandler(repo))
+	handlers.MustRegister(processor.NewPruneJobsHandler(repo, ddl))
+	return handlers
+}
+
+func setupSignalHandler(cancel context.CancelFunc, shutdownCh chan struct{}, onlyOnce *sync.Once) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-c
+		slog.Info("Received shutdown signal, gracefully shutting down")
+
+		cancel()
+
+		onlyOnce.Do(func() {
+			close(shutdownCh)
+		})
+	}()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/scheduler"
+)
+
+const schedulesUsage = `usage: scraper schedules <command> [flags]
+
+commands:
+  list                     show recurring job schedules
+  set     <name> [flags]   create or update a schedule
+  trigger <name>           run a schedule on the scheduler's next check
+  delete  <name>           remove a schedule
+
+set flags:
+  -cron <spec>             five-field cron spec in UTC, or @daily, @every 6h, ...
+  -type <job type>         job type to enqueue
+  -payload <json>          job payload
+  -priority <n>            job priority, lower runs first
+  -enabled=<bool>          enable or disable the schedule
+`
+
+func runSchedulesCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, schedulesUsage)
+		return fmt.Errorf("missing schedules command")
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	repo := scheduler.NewRepository(database.Pool, discovery.NewJobQueueRepository(database.Pool, nil))
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		return printSchedules(ctx, repo)
+	case "set":
+		if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+			return fmt.Errorf("usage: scraper schedules set <name> [-cron spec] [-type type] [-payload json] [-priority n] [-enabled=bool]")
+		}
+		update, err := parseScheduleUpdate(args[2:])
+		if err != nil {
+			return err
+		}
+		if err := repo.SetSchedule(ctx, args[1], update); err != nil {
+			return err
+		}
+		return printSchedules(ctx, repo)
+	case "trigger":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: scraper schedules trigger <name>")
+		}
+		found, err := repo.TriggerSchedule(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no job schedule named %q", args[1])
+		}
+		fmt.Printf("Triggered schedule %s\n", args[1])
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: scraper schedules delete <name>")
+		}
+		deleted, err := repo.DeleteSchedule(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		if !deleted {
+			return fmt.Errorf("no job schedule named %q", args[1])
+		}
+		fmt.Printf("Deleted schedule %s\n", args[1])
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, schedulesUsage)
+		return fmt.Errorf("unknown schedules command %q", args[0])
+	}
+}
+
+func parseScheduleUpdate(args []string) (scheduler.ScheduleUpdate, error) {
+	var update scheduler.ScheduleUpdate
+	var spec, jobType, payload string
+	var priority int
+	var enabled bool
+
+	fs := flag.NewFlagSet("schedules set", flag.ContinueOnError)
+	fs.StringVar(&spec, "cron", "", "cron spec")
+	fs.StringVar(&jobType, "type", "", "job type")
+	fs.StringVar(&payload, "payload", "", "job payload as JSON")
+	fs.IntVar(&priority, "priority", 0, "job priority")
+	fs.BoolVar(&enabled, "enabled", true, "whether the schedule runs")
+	if err := fs.Parse(args); err != nil {
+		return update, err
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "cron":
+			if _, parseErr := scheduler.ParseSpec(spec); parseErr != nil {
+				err = parseErr
+			}
+			update.Spec = &spec
+		case "type":
+			update.JobType = &jobType
+		case "payload":
+			if jsonErr := json.Unmarshal([]byte(payload), &update.Payload); jsonErr != nil {
+				err = fmt.Errorf("invalid payload: %w", jsonErr)
+			}
+			if update.Payload == nil {
+				update.Payload = map[string]interface{}{}
+			}
+		case "priority":
+			update.Priority = &priority
+		case "enabled":
+			update.Enabled = &enabled
+		}
+	})
+
+	return update, err
+}
+
+func printSchedules(ctx context.Context, repo *scheduler.Repository) error {
+	schedules, err := repo.ListSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tCRON\tTYPE\tPRIORITY\tENABLED\tLAST RUN\tNEXT RUN\tPAYLOAD")
+	for _, s := range schedules {
+		payload, _ := json.Marshal(s.Payload)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%t\t%s\t%s\t%s\n",
+			s.Name, s.Spec, s.JobType, s.Priority, s.Enabled,
+			formatOptionalTime(s.LastRunAt), formatOptionalTime(s.NextRunAt), payload)
+	}
+	return tw.Flush()
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/config"
+	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+const scriptsUsage = `usage: scraper scripts search [flags] [pattern]
+
+Lists stored install scripts whose content matches pattern, a
+case-insensitive POSIX regular expression, most downloaded packages first.
+
+flags:
+  -type <script>       only this script, e.g. postinstall
+  -rule <rule>         only scripts with a finding from this rule
+  -severity <level>    only scripts with a finding of at least this severity
+  -limit <n>           at most n scripts (default 50, 0 for all)
+`
+
+func runScriptsCommand(cfg config.Config, args []string) error {
+	if len(args) == 0 || args[0] != "search" {
+		fmt.Fprint(os.Stderr, scriptsUsage)
+		return fmt.Errorf("missing scripts command")
+	}
+
+	var q processor.ScriptQuery
+	var severity string
+	fs := flag.NewFlagSet("scripts search", flag.ContinueOnError)
+	fs.StringVar(&q.ScriptType, "type", "", "only this script, e.g. postinstall")
+	fs.StringVar(&q.Rule, "rule", "", "only scripts with a finding from this rule")
+	fs.StringVar(&severity, "severity", "", "only scripts with a finding of at least this severity")
+	fs.IntVar(&q.Limit, "limit", 50, "at most this many scripts, 0 for all")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return fmt.Errorf("usage: scraper scripts search [flags] [pattern]")
+	}
+	q.Pattern = fs.Arg(0)
+
+	if severity != "" {
+		var err error
+		if q.Severities, err = analysis.AtLeast(severity); err != nil {
+			return err
+		}
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	matches, err := processor.NewRepository(database.Pool).SearchScripts(context.Background(), q)
+	if err != nil {
+		return err
+	}
+
+	if len(matches) == 0 {
+		fmt.Println("No matching scripts")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PACKAGE\tDOWNLOADS\tSCRIPT\tFINDINGS\tCONTENT")
+	for _, m := range matches {
+		fmt.Fprintf(tw, "%s@%s\t%d\t%s\t%s\t%s\n",
+			m.Package.Name, m.Package.Version, m.Package.Downloads, m.Script.ScriptType,
+			findingSummary(m.Script.Findings), truncate(strings.Join(strings.Fields(m.Script.Content), " "), 60))
+	}
+	return tw.Flush()
+}
+
+// findingSummary lists the rules that matched a script with their
+// severities, e.g. "remote_shell:high".
+func findingSummary(findings []models.ScriptFinding) string {
+	if len(findings) == 0 {
+		return "-"
+	}
+	parts := make([]string, len(findings))
+	for i, f := range findings {
+		parts[i] = f.Rule + ":" + f.Severity
+	}
+	return strings.Join(parts, ",")
+}
+
+// runExportCommand writes stored packages as JSON lines, one package per
+// line with its scripts and findings nested.
+func runExportCommand(cfg config.Config, args []string) error {
+	var q processor.ExportQuery
+	var output, severity string
+	var since time.Duration
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.StringVar(&output, "o", "-", "file to write, - for stdout")
+	fs.BoolVar(&q.WithScripts, "with-scripts", false, "only packages with install scripts")
+	fs.StringVar(&severity, "severity", "", "only packages with a finding of at least this severity")
+	fs.DurationVar(&since, "since", 0, "only packages updated within this long")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("usage: scraper export [-o file] [-with-scripts] [-severity level] [-since d]")
+	}
+
+	if severity != "" {
+		var err error
+		if q.Severities, err = analysis.AtLeast(severity); err != nil {
+			return err
+		}
+	}
+	if since > 0 {
+		q.UpdatedSince = time.Now().Add(-since)
+	}
+
+	database, err := db.Connect(cfg.DB)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	var out io.Writer = os.Stdout
+	if output != "-" {
+		f, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	n, err := processor.NewRepository(database.Pool).ExportPackages(context.Background(), q, func(doc json.RawMessage) error {
+		if _, err := w.Write(doc); err != nil {
+			return err
+		}
+		return w.WriteByte('\n')
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Exported %d packages\n", n)
+	return nil
+}
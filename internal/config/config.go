@@ -1,40 +1,205 @@
+// Package config assembles the settings of every subsystem. Each setting
+// comes from, in increasing order of precedence, its default, the YAML config
+// file, its environment variable and its command-line flag.
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
 	"os"
-	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
 
 	"scrapeNPM/internal/db"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/health"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/processor"
+	"scrapeNPM/internal/scheduler"
+	"scrapeNPM/internal/stats"
+	"scrapeNPM/internal/tracing"
 )
 
+// FileEnv names the config file when no -config flag is given.
+const FileEnv = "CONFIG_FILE"
+
+const redacted = "REDACTED"
+
 type Config struct {
-	DB db.Config
+	DB         db.Config              `yaml:"db"`
+	Migrations db.MigrationConfig     `yaml:"migrations"`
+	Registry   discovery.ClientConfig `yaml:"registry"`
+	Discovery  discovery.Config       `yaml:"discovery"`
+	Workers    WorkersConfig          `yaml:"workers"`
+	Scheduler  scheduler.Config       `yaml:"scheduler"`
+	Stats      stats.Config           `yaml:"stats"`
+
+	// HTTPAddr is where the stats, metrics and health endpoints listen.
+	// Empty disables them.
+	HTTPAddr string         `yaml:"http_addr"`
+	Health   health.Config  `yaml:"health"`
+	Log      logging.Config `yaml:"log"`
+	Tracing  tracing.Config `yaml:"tracing"`
 }
 
-func Load() Config {
+type WorkersConfig struct {
+	// PoolInterval is how often worker_pools is re-read and workers are
+	// started or stopped to match it.
+	PoolInterval time.Duration `yaml:"pool_interval"`
+
+	// ReaperInterval is how often jobs with expired leases are returned to
+	// the queue.
+	ReaperInterval time.Duration `yaml:"reaper_interval"`
+
+	// LeaseDuration is how long a claimed job stays owned by its worker
+	// without a heartbeat. Workers heartbeat every third of it.
+	LeaseDuration time.Duration `yaml:"lease_duration"`
+}
+
+func Default() Config {
 	return Config{
 		DB: db.Config{
-			Host:     getEnv("DB_HOST", "localhost"),
-			Port:     getEnvAsInt("DB_PORT", 5432),
-			User:     getEnv("DB_USER", "ctuser"),
-			Password: getEnv("DB_PASSWORD", "password"),
-			Database: getEnv("DB_NAME", "scrapeNPM"),
-			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+			Host:            "localhost",
+			Port:            5432,
+			User:            "ctuser",
+			Database:        "scrapeNPM",
+			SSLMode:         "disable",
+			MaxConns:        25,
+			ApplicationName: "scrapeNPM",
+		},
+		Migrations: db.DefaultMigrationConfig(),
+		Registry:   discovery.DefaultClientConfig(),
+		Discovery:  discovery.DefaultConfig(),
+		Workers: WorkersConfig{
+			PoolInterval:   15 * time.Second,
+			ReaperInterval: 30 * time.Second,
+			LeaseDuration:  processor.DefaultLeaseDuration,
 		},
+		Scheduler: scheduler.DefaultConfig(),
+		Stats:     stats.DefaultConfig(),
+		HTTPAddr:  ":8080",
+		Health:    health.DefaultConfig(),
+		Log:       logging.DefaultConfig(),
+		Tracing:   tracing.DefaultConfig(),
+	}
+}
+
+// Load returns the validated configuration from the config file, the
+// environment and args. The settings are added to fs, which may hold flags of
+// its own, and fs is left holding the arguments after the flags. A nil fs
+// accepts no other flags or arguments. Load returns flag.ErrHelp when args
+// ask for usage.
+func Load(fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := Default()
+
+	path := os.Getenv(FileEnv)
+	if p, ok := fileFlag(args); ok {
+		path = p
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+
+	strict := fs == nil
+	if strict {
+		fs = flag.NewFlagSet("scraper", flag.ContinueOnError)
+	}
+	fs.String("config", path, "YAML config file (env "+FileEnv+")")
+
+	settings := cfg.settings()
+	for _, s := range settings {
+		s.register(fs)
+	}
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := fs.Set(s.name, value); err != nil {
+				return Config{}, fmt.Errorf("invalid %s %q: %w", s.env, value, err)
+			}
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+	if strict && fs.NArg() > 0 {
+		return Config{}, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// fileFlag finds -config in args before the flags are parsed, since the file
+// has to be read first for flags to override it.
+func fileFlag(args []string) (string, bool) {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if value, ok := strings.CutPrefix(name, "config="); ok {
+			return value, true
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+// Redacted returns a copy of c with secrets masked. Connection URLs keep
+// everything but their password.
+func (c Config) Redacted() Config {
+	for _, s := range c.settings() {
+		if p, ok := s.ptr.(*string); ok && s.secret && *p != "" {
+			*p = redact(*p)
+		}
 	}
+	return c
 }
 
-func getEnv(key, fallback string) string {
-	if value, exists := os.LookupEnv(key); exists {
-		return value
+func redact(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") || u.Query().Has("password") {
+		return redacted
 	}
-	return fallback
+	return u.Redacted()
 }
 
-func getEnvAsInt(key string, fallback int) int {
-	valStr := getEnv(key, "")
-	if val, err := strconv.Atoi(valStr); err == nil {
-		return val
+// Print writes c as YAML with secrets masked. The output is a valid config
+// file.
+func (c Config) Print(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c.Redacted()); err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
 	}
-	return fallback
+	return enc.Close()
 }
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+// setting binds a field of Config to its flag and environment variable.
+type setting struct {
+	// name is the flag name and the field's dotted path in the config file.
+	name   string
+	env    string
+	usage  string
+	ptr    interface{} // *string, *int or *time.Duration
+	secret bool
+}
+
+// settings lists every field that can be set from the environment or the
+// command line. The pointers refer to c.
+func (c *Config) settings() []setting {
+	return []setting{
+		{name: "db.url", env: "DATABASE_URL", usage: "connection URL or DSN, replacing the other connection settings", ptr: &c.DB.URL, secret: true},
+		{name: "db.host", env: "DB_HOST", usage: "database host", ptr: &c.DB.Host},
+		{name: "db.port", env: "DB_PORT", usage: "database port", ptr: &c.DB.Port},
+		{name: "db.user", env: "DB_USER", usage: "database user", ptr: &c.DB.User},
+		{name: "db.password", env: "DB_PASSWORD", usage: "database password", ptr: &c.DB.Password, secret: true},
+		{name: "db.name", env: "DB_NAME", usage: "database name", ptr: &c.DB.Database},
+		{name: "db.sslmode", env: "DB_SSLMODE", usage: "libpq sslmode", ptr: &c.DB.SSLMode},
+		{name: "db.password_file", env: "DB_PASSWORD_FILE", usage: "file holding the database password", ptr: &c.DB.PasswordFile},
+		{name: "db.max_conns", env: "DB_MAX_CONNS", usage: "maximum pool connections", ptr: &c.DB.MaxConns},
+		{name: "db.min_conns", env: "DB_MIN_CONNS", usage: "connections kept open when idle", ptr: &c.DB.MinConns},
+		{name: "db.max_conn_lifetime", env: "DB_MAX_CONN_LIFETIME", usage: "age at which connections are replaced, 0 for the pgx default", ptr: &c.DB.MaxConnLifetime},
+		{name: "db.max_conn_idle_time", env: "DB_MAX_CONN_IDLE_TIME", usage: "idle time after which connections are closed, 0 for the pgx default", ptr: &c.DB.MaxConnIdleTime},
+		{name: "db.health_check_period", env: "DB_HEALTH_CHECK_PERIOD", usage: "how often idle connections are checked, 0 for the pgx default", ptr: &c.DB.HealthCheckPeriod},
+		{name: "db.statement_timeout", env: "DB_STATEMENT_TIMEOUT", usage: "abort statements running longer, 0 to disable", ptr: &c.DB.StatementTimeout},
+		{name: "db.application_name", env: "DB_APPLICATION_NAME", usage: "application_name prefix, followed by the instance ID", ptr: &c.DB.ApplicationName},
+
+		{name: "migrations.mode", env: "MIGRATIONS_MODE", usage: "auto to migrate on startup, external when a separate job runs scraper migrate", ptr: &c.Migrations.Mode},
+		{name: "migrations.wait", env: "MIGRATIONS_WAIT", usage: "how long startup waits for pending migrations in external mode", ptr: &c.Migrations.Wait},
+
+		{name: "registry.url", env: "NPM_REGISTRY_URL", usage: "npm registry for packuments", ptr: &c.Registry.RegistryURL},
+		{name: "registry.replicate_url", env: "NPM_REPLICATE_URL", usage: "CouchDB replication endpoint serving _changes", ptr: &c.Registry.ReplicateURL},
+		{name: "registry.downloads_url", env: "NPM_DOWNLOADS_URL", usage: "npm downloads API", ptr: &c.Registry.DownloadsURL},
+		{name: "registry.user_agent", env: "NPM_USER_AGENT", usage: "User-Agent sent upstream", ptr: &c.Registry.UserAgent},
+		{name: "registry.timeout", env: "NPM_TIMEOUT", usage: "timeout of each upstream request", ptr: &c.Registry.Timeout},
+		{name: "registry.record_dir", env: "NPM_RECORD_DIR", usage: "record upstream exchanges into this directory", ptr: &c.Registry.RecordDir},
+		{name: "registry.replay_dir", env: "NPM_REPLAY_DIR", usage: "replay upstream exchanges from this directory", ptr: &c.Registry.ReplayDir},
+
+		{name: "discovery.batch_size", env: "DISCOVERY_BATCH_SIZE", usage: "changes read from the feed at once", ptr: &c.Discovery.BatchSize},
+		{name: "discovery.request_delay", env: "DISCOVERY_REQUEST_DELAY", usage: "pause between changes batches", ptr: &c.Discovery.RequestDelay},
+		{name: "discovery.idle_delay", env: "DISCOVERY_IDLE_DELAY", usage: "pause after a batch with no changes", ptr: &c.Discovery.IdleDelay},
+
+		{name: "workers.pool_interval", env: "WORKERS_POOL_INTERVAL", usage: "how often worker pool sizes are re-read", ptr: &c.Workers.PoolInterval},
+		{name: "workers.reaper_interval", env: "WORKERS_REAPER_INTERVAL", usage: "how often expired job leases are reclaimed", ptr: &c.Workers.ReaperInterval},
+		{name: "workers.lease_duration", env: "WORKERS_LEASE_DURATION", usage: "lease on claimed jobs", ptr: &c.Workers.LeaseDuration},
+
+		{name: "scheduler.interval", env: "SCHEDULER_INTERVAL", usage: "how often due schedules are checked", ptr: &c.Scheduler.Interval},
+
+		{name: "stats.window", env: "STATS_WINDOW", usage: "period queue rates and durations cover", ptr: &c.Stats.Window},
+		{name: "stats.log_interval", env: "STATS_LOG_INTERVAL", usage: "how often queue stats are logged, 0 to disable", ptr: &c.Stats.LogInterval},
+
+		{name: "http_addr", env: "HTTP_ADDR", usage: "address of the stats, metrics and health endpoints, empty to disable", ptr: &c.HTTPAddr},
+
+		{name: "health.progress_window", env: "HEALTH_PROGRESS_WINDOW", usage: "longest gap between changes batches before /readyz fails", ptr: &c.Health.ProgressWindow},
+		{name: "health.stall_timeout", env: "HEALTH_STALL_TIMEOUT", usage: "longest a worker may stall before /healthz fails", ptr: &c.Health.StallTimeout},
+		{name: "health.check_timeout", env: "HEALTH_CHECK_TIMEOUT", usage: "timeout of each health probe", ptr: &c.Health.CheckTimeout},
+
+		{name: "log.level", env: "LOG_LEVEL", usage: "debug, info, warn or error", ptr: &c.Log.Level},
+		{name: "log.format", env: "LOG_FORMAT", usage: "text or json", ptr: &c.Log.Format},
+
+		{name: "tracing.exporter", env: "OTEL_TRACES_EXPORTER", usage: "none, otlp or stdout", ptr: &c.Tracing.Exporter},
+		{name: "tracing.service_name", env: "OTEL_SERVICE_NAME", usage: "service.name reported on spans", ptr: &c.Tracing.ServiceName},
+	}
+}
+
+func (s setting) register(fs *flag.FlagSet) {
+	usage := s.usage
+	if s.env != "" {
+		usage += " (env " + s.env + ")"
+	}
+
+	switch p := s.ptr.(type) {
+	case *string:
+		if s.secret {
+			fs.Var(secretValue{p}, s.name, usage)
+			return
+		}
+		fs.StringVar(p, s.name, *p, usage)
+	case *int:
+		fs.IntVar(p, s.name, *p, usage)
+	case *time.Duration:
+		fs.DurationVar(p, s.name, *p, usage)
+	default:
+		panic(fmt.Sprintf("config: unsupported type %T for %s", s.ptr, s.name))
+	}
+}
+
+// secretValue is a string flag whose value is never shown in usage output.
+type secretValue struct {
+	p *string
+}
+
+func (v secretValue) String() string { return "" }
+
+func (v secretValue) Set(s string) error {
+	*v.p = s
+	return nil
+}
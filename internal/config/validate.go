@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"scrapeNPM/internal/db"
+)
+
+// minLeaseDuration keeps the worker heartbeat, a third of the lease, well
+// clear of a database round trip.
+const minLeaseDuration = 10 * time.Second
+
+var sslModes = []string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"}
+
+// Validate reports every invalid setting at once, each named by its config
+// file path and environment variable.
+func (c *Config) Validate() error {
+	envs := make(map[string]string)
+	for _, s := range c.settings() {
+		envs[s.name] = s.env
+	}
+
+	var problems []string
+	fail := func(name, format string, args ...interface{}) {
+		if env := envs[name]; env != "" {
+			name += " (" + env + ")"
+		}
+		problems = append(problems, name+": "+fmt.Sprintf(format, args...))
+	}
+	positive := func(name string, d time.Duration) {
+		if d <= 0 {
+			fail(name, "must be positive, got %s", d)
+		}
+	}
+	nonNegative := func(name string, d time.Duration) {
+		if d < 0 {
+			fail(name, "must not be negative, got %s", d)
+		}
+	}
+	required := func(name, value string) {
+		if value == "" {
+			fail(name, "must be set")
+		}
+	}
+	httpURL := func(name, value string) {
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail(name, "must be an http or https URL, got %q", value)
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		for _, a := range allowed {
+			if strings.EqualFold(value, a) {
+				return
+			}
+		}
+		fail(name, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+	}
+
+	if c.DB.URL != "" {
+		if strings.Contains(c.DB.URL, "://") {
+			u, err := url.Parse(c.DB.URL)
+			if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+				fail("db.url", "must be a postgres:// URL or key=value pairs")
+			}
+		}
+	} else {
+		required("db.host", c.DB.Host)
+		if c.DB.Port < 1 || c.DB.Port > 65535 {
+			fail("db.port", "must be between 1 and 65535, got %d", c.DB.Port)
+		}
+		required("db.user", c.DB.User)
+		required("db.name", c.DB.Database)
+		oneOf("db.sslmode", c.DB.SSLMode, sslModes...)
+	}
+	if c.DB.PasswordFile != "" {
+		if _, err := os.Stat(c.DB.PasswordFile); err != nil {
+			fail("db.password_file", "%v", err)
+		}
+	}
+	if c.DB.MaxConns < 1 {
+		fail("db.max_conns", "must be at least 1, got %d", c.DB.MaxConns)
+	}
+	if c.DB.MinConns < 0 || c.DB.MinConns > c.DB.MaxConns {
+		fail("db.min_conns", "must be between 0 and db.max_conns, got %d", c.DB.MinConns)
+	}
+	nonNegative("db.max_conn_lifetime", c.DB.MaxConnLifetime)
+	nonNegative("db.max_conn_idle_time", c.DB.MaxConnIdleTime)
+	nonNegative("db.health_check_period", c.DB.HealthCheckPeriod)
+	nonNegative("db.statement_timeout", c.DB.StatementTimeout)
+
+	oneOf("migrations.mode", c.Migrations.Mode, db.MigrateAuto, db.MigrateExternal)
+	nonNegative("migrations.wait", c.Migrations.Wait)
+
+	httpURL("registry.url", c.Registry.RegistryURL)
+	httpURL("registry.replicate_url", c.Registry.ReplicateURL)
+	httpURL("registry.downloads_url", c.Registry.DownloadsURL)
+	required("registry.user_agent", c.Registry.UserAgent)
+	positive("registry.timeout", c.Registry.Timeout)
+	if c.Registry.RecordDir != "" && c.Registry.ReplayDir != "" {
+		fail("registry.replay_dir", "cannot be combined with registry.record_dir")
+	}
+
+	if c.Discovery.BatchSize < 1 {
+		fail("discovery.batch_size", "must be at least 1, got %d", c.Discovery.BatchSize)
+	}
+	nonNegative("discovery.request_delay", c.Discovery.RequestDelay)
+	nonNegative("discovery.idle_delay", c.Discovery.IdleDelay)
+
+	positive("workers.pool_interval", c.Workers.PoolInterval)
+	positive("workers.reaper_interval", c.Workers.ReaperInterval)
+	if c.Workers.LeaseDuration < minLeaseDuration {
+		fail("workers.lease_duration", "must be at least %s, got %s", minLeaseDuration, c.Workers.LeaseDuration)
+	}
+
+	positive("scheduler.interval", c.Scheduler.Interval)
+
+	positive("stats.window", c.Stats.Window)
+	nonNegative("stats.log_interval", c.Stats.LogInterval)
+
+	if c.HTTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.HTTPAddr); err != nil {
+			fail("http_addr", "must be host:port or :port, got %q", c.HTTPAddr)
+		}
+	}
+
+	positive("health.progress_window", c.Health.ProgressWindow)
+	positive("health.stall_timeout", c.Health.StallTimeout)
+	positive("health.check_timeout", c.Health.CheckTimeout)
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		fail("log.level", "must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	oneOf("log.format", c.Log.Format, "text", "json")
+
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "stdout", "console")
+	required("tracing.service_name", c.Tracing.ServiceName)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
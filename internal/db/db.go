@@ -3,10 +3,9 @@ package db
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
-	"path/filepath"
-	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
@@ -17,118 +16,123 @@ type DB struct {
 }
 
 type Config struct {
-	Host     string
-	Port     int
-	User     string
-	Password string
-	Database string
-	SSLMode  string
+	// URL is a full connection string, either a postgres:// URL or
+	// keyword/value pairs. When set it replaces Host, Port, User, Password,
+	// Database and SSLMode.
+	URL string `yaml:"url"`
+
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Database string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+
+	// PasswordFile holds the password, overriding any other. With no
+	// password at all it is looked up in PGPASSFILE or ~/.pgpass.
+	PasswordFile string `yaml:"password_file"`
+
+	// MaxConns and MinConns bound the pool. The other pool settings use the
+	// pgx defaults when zero.
+	MaxConns          int           `yaml:"max_conns"`
+	MinConns          int           `yaml:"min_conns"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period"`
+
+	// StatementTimeout aborts any statement running longer. Zero disables it.
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+
+	// ApplicationName is reported in pg_stat_activity unless the URL sets
+	// one.
+	ApplicationName string `yaml:"application_name"`
 }
 
 func Connect(cfg Config) (*DB, error) {
-	connString := fmt.Sprintf(
-		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.Database, cfg.SSLMode,
-	)
-
-	pool, err := pgxpool.Connect(context.Background(), connString)
+	poolConfig, err := pgxpool.ParseConfig(cfg.connString())
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to database: %w", err)
+		return nil, fmt.Errorf("failed to parse database config: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	if err := pool.Ping(ctx); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+	if cfg.PasswordFile != "" {
+		password, err := os.ReadFile(cfg.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database password file: %w", err)
+		}
+		poolConfig.ConnConfig.Password = strings.TrimRight(string(password), "\r\n")
 	}
 
-	return &DB{Pool: pool}, nil
-}
-
-func (db *DB) Close() {
-	if db.Pool != nil {
-		db.Pool.Close()
+	if cfg.MaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.MaxConns)
 	}
-}
-
-func (db *DB) RunMigrations(migrationsDir string) error {
-	log.Printf("Running migrations from %s", migrationsDir)
-
-	_, err := db.Pool.Exec(context.Background(), `
-        CREATE TABLE IF NOT EXISTS migrations (
-            id SERIAL PRIMARY KEY,
-            name VARCHAR(255) NOT NULL UNIQUE,
-            applied_at TIMESTAMP NOT NULL DEFAULT NOW()
-        )
-    `)
-	if err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
+	if cfg.MinConns > 0 {
+		poolConfig.MinConns = int32(cfg.MinConns)
 	}
-
-	appliedMigrations := make(map[string]bool)
-	rows, err := db.Pool.Query(context.Background(), "SELECT name FROM migrations")
-	if err != nil {
-		return fmt.Errorf("failed to query migrations: %w", err)
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var name string
-		if err := rows.Scan(&name); err != nil {
-			return fmt.Errorf("failed to scan migration row: %w", err)
-		}
-		appliedMigrations[name] = true
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
 	}
 
-	if rows.Err() != nil {
-		return fmt.Errorf("error iterating migrations rows: %w", rows.Err())
+	params := poolConfig.ConnConfig.RuntimeParams
+	if cfg.StatementTimeout > 0 {
+		params["statement_timeout"] = strconv.FormatInt(cfg.StatementTimeout.Milliseconds(), 10)
+	}
+	if _, ok := params["application_name"]; !ok && cfg.ApplicationName != "" {
+		params["application_name"] = cfg.ApplicationName
 	}
 
-	migrationFiles, err := filepath.Glob(filepath.Join(migrationsDir, "*.sql"))
+	pool, err := pgxpool.ConnectConfig(context.Background(), poolConfig)
 	if err != nil {
-		return fmt.Errorf("failed to list migration files: %w", err)
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	sort.Strings(migrationFiles)
-
-	for _, file := range migrationFiles {
-		filename := filepath.Base(file)
-
-		if appliedMigrations[filename] {
-			log.Printf("Migration %s already applied, skipping", filename)
-			continue
-		}
-
-		log.Printf("Applying migration: %s", filename)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-		content, err := os.ReadFile(file)
-		if err != nil {
-			return fmt.Errorf("failed to read migration file %s: %w", filename, err)
-		}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
 
-		tx, err := db.Pool.Begin(context.Background())
-		if err != nil {
-			return fmt.Errorf("failed to start transaction: %w", err)
-		}
+	return &DB{Pool: pool}, nil
+}
 
-		if _, err := tx.Exec(context.Background(), string(content)); err != nil {
-			tx.Rollback(context.Background())
-			return fmt.Errorf("failed to execute migration %s: %w", filename, err)
-		}
+// connString returns URL, or keyword/value pairs built from the individual
+// fields. The password is left out when empty so that pgx falls back to the
+// password file.
+func (cfg Config) connString() string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
 
-		if _, err := tx.Exec(context.Background(),
-			"INSERT INTO migrations (name) VALUES ($1)", filename); err != nil {
-			tx.Rollback(context.Background())
-			return fmt.Errorf("failed to record migration %s: %w", filename, err)
-		}
+	pairs := []string{
+		"host=" + quoteValue(cfg.Host),
+		"port=" + strconv.Itoa(cfg.Port),
+		"user=" + quoteValue(cfg.User),
+		"dbname=" + quoteValue(cfg.Database),
+		"sslmode=" + quoteValue(cfg.SSLMode),
+	}
+	if cfg.Password != "" {
+		pairs = append(pairs, "password="+quoteValue(cfg.Password))
+	}
+	return strings.Join(pairs, " ")
+}
 
-		if err := tx.Commit(context.Background()); err != nil {
-			return fmt.Errorf("failed to commit migration transaction: %w", err)
-		}
+// quoteValue quotes a keyword/value connection string value so that spaces,
+// quotes and backslashes survive parsing.
+func quoteValue(v string) string {
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
 
-		log.Printf("Successfully applied migration: %s", filename)
+func (db *DB) Close() {
+	if db.Pool != nil {
+		db.Pool.Close()
 	}
-
-	return nil
 }
@@ -0,0 +1,87 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// AdvisoryLock is a session-level Postgres advisory lock. The lock lives on a
+// dedicated pool connection, so it is released if that connection dies.
+type AdvisoryLock struct {
+	pool *pgxpool.Pool
+	key  int64
+	conn *pgxpool.Conn
+}
+
+func NewAdvisoryLock(pool *pgxpool.Pool, key int64) *AdvisoryLock {
+	return &AdvisoryLock{pool: pool, key: key}
+}
+
+// TryAcquire reports whether this process holds the lock, taking it if it is
+// free. When the lock is already held it checks the connection is still
+// alive; if not, the lock is treated as lost.
+func (l *AdvisoryLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn != nil {
+		if _, err := l.conn.Exec(ctx, "SELECT 1"); err != nil {
+			l.conn.Release()
+			l.conn = nil
+			return false, fmt.Errorf("lost advisory lock connection: %w", err)
+		}
+		return true, nil
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	var acquired bool
+	if err := conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		conn.Release()
+		return false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+
+	if !acquired {
+		conn.Release()
+		return false, nil
+	}
+
+	l.conn = conn
+	return true, nil
+}
+
+// Release gives up the lock if it is held.
+func (l *AdvisoryLock) Release(ctx context.Context) {
+	if l.conn == nil {
+		return
+	}
+
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		// Closing the connection drops every lock it holds.
+		l.conn.Conn().Close(ctx)
+	}
+	l.conn.Release()
+	l.conn = nil
+}
+
+// Acquire waits until this process holds the lock or ctx is done.
+func (l *AdvisoryLock) Acquire(ctx context.Context) error {
+	if l.conn != nil {
+		return nil
+	}
+
+	conn, err := l.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to acquire connection: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", l.key); err != nil {
+		conn.Release()
+		return fmt.Errorf("failed to take advisory lock: %w", err)
+	}
+
+	l.conn = conn
+	return nil
+}
@@ -0,0 +1,387 @@
+package db
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// MigrationLockKey is the Postgres advisory lock key held while migrations
+// are applied or reverted, so that instances starting together take turns.
+const MigrationLockKey int64 = 0x73637270_6d696772
+
+// downSuffix marks the file that reverts the migration of the same name.
+const downSuffix = ".down.sql"
+
+// Migration is a migration file and when it was applied, if it has been.
+// Checksum is that of the file and AppliedChecksum that of the file when it
+// was applied; either is empty when unknown.
+type Migration struct {
+	Name            string
+	Version         int
+	AppliedAt       *time.Time
+	Checksum        string
+	AppliedChecksum string
+	Reversible      bool
+	Breaking        bool
+}
+
+// Modified reports whether the file has changed since it was applied.
+func (m Migration) Modified() bool {
+	return m.Checksum != "" && m.AppliedChecksum != "" && m.Checksum != m.AppliedChecksum
+}
+
+// Missing reports whether the migration was applied but has no file, as when
+// a newer binary has migrated the database.
+func (m Migration) Missing() bool {
+	return m.AppliedAt != nil && m.Checksum == ""
+}
+
+// Migrator applies and reverts the migrations in source: NNN_name.sql files
+// applied in name order, each reverted by NNN_name.down.sql if present.
+// Applied migrations are recorded in the migrations table with the checksum
+// of their file.
+type Migrator struct {
+	pool   *pgxpool.Pool
+	source fs.FS
+}
+
+func NewMigrator(pool *pgxpool.Pool, source fs.FS) *Migrator {
+	return &Migrator{pool: pool, source: source}
+}
+
+type migrationFile struct {
+	name     string
+	version  int
+	breaking bool
+	content  string
+	checksum string
+	down     string
+	hasDown  bool
+}
+
+// files reads every migration in source, in name order.
+func (m *Migrator) files() ([]migrationFile, error) {
+	paths, err := fs.Glob(m.source, "*.sql")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list migration files: %w", err)
+	}
+
+	downs := make(map[string]string)
+	var files []migrationFile
+	for _, path := range paths {
+		content, err := fs.ReadFile(m.source, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file %s: %w", path, err)
+		}
+		if strings.HasSuffix(path, downSuffix) {
+			downs[strings.TrimSuffix(path, downSuffix)+".sql"] = string(content)
+			continue
+		}
+		version, ok := migrationVersion(path)
+		if !ok {
+			return nil, fmt.Errorf("migration file %s is not named NNN_name.sql", path)
+		}
+		sum := sha256.Sum256(content)
+		files = append(files, migrationFile{
+			name:     path,
+			version:  version,
+			breaking: isBreaking(string(content)),
+			content:  string(content),
+			checksum: hex.EncodeToString(sum[:]),
+		})
+	}
+
+	for i := range files {
+		files[i].down, files[i].hasDown = downs[files[i].name]
+		delete(downs, files[i].name)
+	}
+	for name := range downs {
+		return nil, fmt.Errorf("%s has no matching migration", strings.TrimSuffix(name, ".sql")+downSuffix)
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].name < files[j].name })
+	return files, nil
+}
+
+// Status lists every migration, applied or pending, in name order: the files
+// in source, and applied migrations whose file is gone. It does not change
+// the database.
+func (m *Migrator) Status(ctx context.Context) ([]Migration, error) {
+	files, err := m.files()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, f := range files {
+		migration := Migration{
+			Name:       f.name,
+			Version:    f.version,
+			Checksum:   f.checksum,
+			Reversible: f.hasDown,
+			Breaking:   f.breaking,
+		}
+		if a, ok := applied[f.name]; ok {
+			migration.AppliedAt, migration.AppliedChecksum = a.AppliedAt, a.AppliedChecksum
+			delete(applied, f.name)
+		}
+		migrations = append(migrations, migration)
+	}
+	for _, a := range applied {
+		migrations = append(migrations, a)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Name < migrations[j].Name })
+
+	return migrations, nil
+}
+
+// Up applies every pending migration, each in its own transaction, and
+// returns how many were applied. It fails without applying anything if an
+// applied migration's file has changed.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	release, err := m.lock(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	files, applied, err := m.prepare(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, f := range files {
+		if _, ok := applied[f.name]; ok {
+			slog.Debug("Migration already applied, skipping", "migration", f.name)
+			continue
+		}
+
+		slog.Info("Applying migration", "migration", f.name)
+		if err := m.exec(ctx, f.name, f.content,
+			"INSERT INTO migrations (name, checksum, breaking) VALUES ($1, $2, $3)",
+			f.name, f.checksum, f.breaking); err != nil {
+			return n, err
+		}
+		slog.Info("Applied migration", "migration", f.name)
+		n++
+	}
+
+	return n, nil
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns
+// their names. It stops at the first migration without a down file.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]string, error) {
+	release, err := m.lock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	files, _, err := m.prepare(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byName := make(map[string]migrationFile, len(files))
+	for _, f := range files {
+		byName[f.name] = f
+	}
+
+	rows, err := m.pool.Query(ctx, "SELECT name FROM migrations ORDER BY id DESC LIMIT $1", steps)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating migrations rows: %w", rows.Err())
+	}
+
+	var reverted []string
+	for _, name := range names {
+		f, ok := byName[name]
+		if !ok {
+			return reverted, fmt.Errorf("cannot revert %s: this binary does not have it", name)
+		}
+		if !f.hasDown {
+			return reverted, fmt.Errorf("cannot revert %s: it has no down migration", name)
+		}
+
+		slog.Info("Reverting migration", "migration", name)
+		if err := m.exec(ctx, name, f.down, "DELETE FROM migrations WHERE name = $1", name); err != nil {
+			return reverted, err
+		}
+		slog.Info("Reverted migration", "migration", name)
+		reverted = append(reverted, name)
+	}
+
+	return reverted, nil
+}
+
+// lock takes the migration lock, waiting for any other instance that holds
+// it, and returns a function releasing it.
+func (m *Migrator) lock(ctx context.Context) (func(), error) {
+	lock := NewAdvisoryLock(m.pool, MigrationLockKey)
+
+	acquired, err := lock.TryAcquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !acquired {
+		slog.Info("Waiting for another instance to finish migrating")
+		if err := lock.Acquire(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() { lock.Release(context.Background()) }, nil
+}
+
+// prepare creates the migrations table if needed and checks applied
+// migrations against their files. Migrations applied before checksums were
+// recorded take the checksum of their current file.
+func (m *Migrator) prepare(ctx context.Context) ([]migrationFile, map[string]Migration, error) {
+	_, err := m.pool.Exec(ctx, `
+        CREATE TABLE IF NOT EXISTS migrations (
+            id SERIAL PRIMARY KEY,
+            name VARCHAR(255) NOT NULL UNIQUE,
+            applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+        );
+        ALTER TABLE migrations ADD COLUMN IF NOT EXISTS checksum VARCHAR(64);
+        ALTER TABLE migrations ADD COLUMN IF NOT EXISTS breaking BOOLEAN NOT NULL DEFAULT FALSE;
+    `)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	files, err := m.files()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var modified []string
+	for _, f := range files {
+		a, ok := applied[f.name]
+		if !ok {
+			continue
+		}
+		a.Checksum = f.checksum
+		if a.Modified() {
+			modified = append(modified, f.name)
+			continue
+		}
+		if a.AppliedChecksum == "" {
+			if _, err := m.pool.Exec(ctx, "UPDATE migrations SET checksum = $2 WHERE name = $1", f.name, f.checksum); err != nil {
+				return nil, nil, fmt.Errorf("failed to record checksum of %s: %w", f.name, err)
+			}
+		}
+	}
+	if len(modified) > 0 {
+		return nil, nil, fmt.Errorf("applied migrations have been modified: %s", strings.Join(modified, ", "))
+	}
+
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f.name] = true
+	}
+	for name := range applied {
+		if !known[name] {
+			slog.Warn("Applied migration is unknown to this binary", "migration", name)
+		}
+	}
+
+	return files, applied, nil
+}
+
+// exec runs a migration and the statement recording it in one transaction.
+func (m *Migrator) exec(ctx context.Context, name, content, record string, args ...interface{}) error {
+	tx, err := m.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, content); err != nil {
+		return fmt.Errorf("failed to execute migration %s: %w", name, err)
+	}
+
+	if _, err := tx.Exec(ctx, record, args...); err != nil {
+		return fmt.Errorf("failed to record migration %s: %w", name, err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit migration transaction: %w", err)
+	}
+	return nil
+}
+
+// applied returns the recorded migrations by name, or none if the
+// migrations table does not exist yet.
+func (m *Migrator) applied(ctx context.Context) (map[string]Migration, error) {
+	applied := make(map[string]Migration)
+
+	var exists bool
+	if err := m.pool.QueryRow(ctx, "SELECT to_regclass('migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to check migrations table: %w", err)
+	}
+	if !exists {
+		return applied, nil
+	}
+
+	// The checksum and breaking columns are read through row_to_json so that
+	// status works read-only against a table created before they were added.
+	rows, err := m.pool.Query(ctx, `
+        SELECT name, applied_at, COALESCE(row_to_json(m)->>'checksum', ''),
+               COALESCE((row_to_json(m)->>'breaking')::boolean, FALSE)
+        FROM migrations m
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query migrations: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var migration Migration
+		var at time.Time
+		if err := rows.Scan(&migration.Name, &at, &migration.AppliedChecksum, &migration.Breaking); err != nil {
+			return nil, fmt.Errorf("failed to scan migration row: %w", err)
+		}
+		migration.Version, _ = migrationVersion(migration.Name)
+		migration.AppliedAt = &at
+		applied[migration.Name] = migration
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating migrations rows: %w", rows.Err())
+	}
+
+	return applied, nil
+}
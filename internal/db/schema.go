@@ -0,0 +1,147 @@
+package db
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migration modes.
+const (
+	// MigrateAuto applies pending migrations on startup.
+	MigrateAuto = "auto"
+	// MigrateExternal leaves migrations to a separate job running the
+	// migrate command; the scraper itself never runs DDL.
+	MigrateExternal = "external"
+)
+
+// BreakingMarker, on a line of its own in a migration, marks a change that
+// binaries built before the migration cannot run against, such as dropping
+// or renaming something they use. Binaries run against schemas with unknown
+// migrations only when none of them is breaking.
+const BreakingMarker = "-- scraper:breaking"
+
+// schemaPollInterval is how often the schema is checked while waiting for a
+// separate job to migrate it.
+const schemaPollInterval = 5 * time.Second
+
+type MigrationConfig struct {
+	// Mode is MigrateAuto or MigrateExternal.
+	Mode string `yaml:"mode"`
+
+	// Wait is how long startup waits in external mode for pending
+	// migrations to be applied.
+	Wait time.Duration `yaml:"wait"`
+}
+
+func DefaultMigrationConfig() MigrationConfig {
+	return MigrationConfig{
+		Mode: MigrateAuto,
+		Wait: 5 * time.Minute,
+	}
+}
+
+// Schema compares the database schema with the migrations this binary has.
+// Version is the newest migration applied and Expected the newest migration
+// in the binary. Unknown lists applied migrations the binary does not have,
+// and Breaking those of them that are marked breaking.
+type Schema struct {
+	Version  int
+	Expected int
+	Pending  []string
+	Unknown  []string
+	Breaking []string
+}
+
+// Check returns an error unless this binary can run against the schema.
+func (s Schema) Check() error {
+	if len(s.Breaking) > 0 {
+		return fmt.Errorf("schema version %d is newer than this binary's %d and not compatible with it: %s",
+			s.Version, s.Expected, strings.Join(s.Breaking, ", "))
+	}
+	if len(s.Pending) > 0 {
+		return fmt.Errorf("schema version %d is older than this binary's %d: %d migrations pending: %s",
+			s.Version, s.Expected, len(s.Pending), strings.Join(s.Pending, ", "))
+	}
+	return nil
+}
+
+// Schema reports the schema version and what stands between it and this
+// binary. It does not change the database.
+func (m *Migrator) Schema(ctx context.Context) (Schema, error) {
+	migrations, err := m.Status(ctx)
+	if err != nil {
+		return Schema{}, err
+	}
+
+	var s Schema
+	for _, migration := range migrations {
+		if migration.Checksum != "" && migration.Version > s.Expected {
+			s.Expected = migration.Version
+		}
+		if migration.AppliedAt == nil {
+			s.Pending = append(s.Pending, migration.Name)
+			continue
+		}
+		if migration.Version > s.Version {
+			s.Version = migration.Version
+		}
+		if migration.Missing() {
+			s.Unknown = append(s.Unknown, migration.Name)
+			if migration.Breaking {
+				s.Breaking = append(s.Breaking, migration.Name)
+			}
+		}
+	}
+	return s, nil
+}
+
+// WaitForSchema returns once this binary can run against the schema. While
+// migrations are pending it checks again every few seconds, until wait has
+// passed or ctx is done. A schema with unknown breaking migrations fails
+// straight away.
+func (m *Migrator) WaitForSchema(ctx context.Context, wait time.Duration) (Schema, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		s, err := m.Schema(ctx)
+		if err != nil {
+			return s, err
+		}
+
+		err = s.Check()
+		if err == nil || len(s.Breaking) > 0 || time.Now().Add(schemaPollInterval).After(deadline) {
+			return s, err
+		}
+
+		slog.Info("Waiting for migrations to be applied", "version", s.Version, "expected", s.Expected, "pending", len(s.Pending))
+		select {
+		case <-ctx.Done():
+			return s, ctx.Err()
+		case <-time.After(schemaPollInterval):
+		}
+	}
+}
+
+// migrationVersion parses the number a migration file name starts with.
+func migrationVersion(name string) (int, bool) {
+	prefix, _, ok := strings.Cut(name, "_")
+	if !ok {
+		return 0, false
+	}
+	version, err := strconv.Atoi(prefix)
+	return version, err == nil && version > 0
+}
+
+func isBreaking(content string) bool {
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == BreakingMarker {
+			return true
+		}
+	}
+	return false
+}
@@ -6,30 +6,105 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	neturl "net/url"
+	"strings"
 	"time"
+
+	"scrapeNPM/internal/metrics"
+	"scrapeNPM/internal/tracing"
 )
 
+// HTTPError is returned when an upstream responds with an unexpected status.
+type HTTPError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body != "" {
+		return fmt.Sprintf("API returned status code %d: %s", e.StatusCode, e.Body)
+	}
+	return fmt.Sprintf("API returned status code %d", e.StatusCode)
+}
+
+type ClientConfig struct {
+	RegistryURL  string        `yaml:"url"`
+	ReplicateURL string        `yaml:"replicate_url"`
+	DownloadsURL string        `yaml:"downloads_url"`
+	UserAgent    string        `yaml:"user_agent"`
+	Timeout      time.Duration `yaml:"timeout"`
+
+	// RecordDir captures every upstream exchange into fixture files.
+	// ReplayDir serves previously captured exchanges instead of going to the
+	// network. At most one of the two may be set.
+	RecordDir string `yaml:"record_dir"`
+	ReplayDir string `yaml:"replay_dir"`
+}
+
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		RegistryURL:  "https://registry.npmjs.org",
+		ReplicateURL: "https://replicate.npmjs.com/registry",
+		DownloadsURL: "https://api.npmjs.org",
+		UserAgent:    "npm-registry-scraper/1.0",
+		Timeout:      30 * time.Second,
+	}
+}
+
 type Client struct {
-	httpClient *http.Client
-	baseURL    string
-	changesURL string
-	userAgent  string
+	httpClient   *http.Client
+	baseURL      string
+	replicateURL string
+	changesURL   string
+	allDocsURL   string
+	downloadsURL string
+	userAgent    string
 }
 
 func NewClient() *Client {
+	client, _ := NewClientWithConfig(DefaultClientConfig())
+	return client
+}
+
+func NewClientWithConfig(cfg ClientConfig) (*Client, error) {
+	var transport http.RoundTripper = &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 100,
+		IdleConnTimeout:     90 * time.Second,
+	}
+
+	// Recording and replay sit beneath metrics and tracing so that replayed
+	// runs report the same telemetry as live ones.
+	switch {
+	case cfg.RecordDir != "" && cfg.ReplayDir != "":
+		return nil, fmt.Errorf("record and replay directories are mutually exclusive")
+	case cfg.RecordDir != "":
+		recorder, err := NewRecordingTransport(cfg.RecordDir, transport)
+		if err != nil {
+			return nil, err
+		}
+		transport = recorder
+	case cfg.ReplayDir != "":
+		replayer, err := NewReplayTransport(cfg.ReplayDir)
+		if err != nil {
+			return nil, err
+		}
+		transport = replayer
+	}
+	transport = tracing.NewTransport(metrics.NewTransport(transport))
+
 	return &Client{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-			Transport: &http.Transport{
-				MaxIdleConns:        100,
-				MaxIdleConnsPerHost: 100,
-				IdleConnTimeout:     90 * time.Second,
-			},
+			Timeout:   cfg.Timeout,
+			Transport: transport,
 		},
-		baseURL:    "https://registry.npmjs.org",
-		changesURL: "https://replicate.npmjs.com/registry/_changes",
-		userAgent:  "npm-registry-scraper/1.0",
-	}
+		baseURL:      strings.TrimRight(cfg.RegistryURL, "/"),
+		replicateURL: strings.TrimRight(cfg.ReplicateURL, "/"),
+		changesURL:   strings.TrimRight(cfg.ReplicateURL, "/") + "/_changes",
+		allDocsURL:   strings.TrimRight(cfg.ReplicateURL, "/") + "/_all_docs",
+		downloadsURL: strings.TrimRight(cfg.DownloadsURL, "/"),
+		userAgent:    cfg.UserAgent,
+	}, nil
 }
 
 func (c *Client) GetPackage(ctx context.Context, packageName string) (map[string]interface{}, error) {
@@ -49,7 +124,7 @@ func (c *Client) GetPackage(ctx context.Context, packageName string) (map[string
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("API returned status code %d", resp.StatusCode)
+		return nil, &HTTPError{StatusCode: resp.StatusCode}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -90,7 +165,7 @@ func (c *Client) GetChanges(ctx context.Context, since string, limit int) (map[s
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status code %d: %s", resp.StatusCode, string(body))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -106,6 +181,34 @@ func (c *Client) GetChanges(ctx context.Context, since string, limit int) (map[s
 	return result, nil
 }
 
+// GetUpdateSequence returns the latest sequence of the replication feed.
+func (c *Client) GetUpdateSequence(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.replicateURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("npm-replication-opt-in", "true")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", &HTTPError{StatusCode: resp.StatusCode}
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	return sequenceString(result["update_seq"]), nil
+}
+
 func (c *Client) GetAllDocs(ctx context.Context, startKey string, limit int, descending bool) (map[string]interface{}, error) {
 	if limit > 10000 {
 		limit = 10000
@@ -113,10 +216,15 @@ func (c *Client) GetAllDocs(ctx context.Context, startKey string, limit int, des
 		limit = 1000 // Default value
 	}
 
-	url := "https://replicate.npmjs.com/registry/_all_docs?limit=" + fmt.Sprintf("%d", limit)
+	url := c.allDocsURL + "?limit=" + fmt.Sprintf("%d", limit)
 
 	if startKey != "" {
-		url += "&startkey=" + startKey
+		// CouchDB keys are JSON values.
+		key, err := json.Marshal(startKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode start key: %w", err)
+		}
+		url += "&startkey=" + neturl.QueryEscape(string(key))
 	}
 
 	if descending {
@@ -139,7 +247,7 @@ func (c *Client) GetAllDocs(ctx context.Context, startKey string, limit int, des
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("API returned status code %d: %s", resp.StatusCode, string(body))
+		return nil, &HTTPError{StatusCode: resp.StatusCode, Body: string(body)}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
@@ -154,3 +262,37 @@ func (c *Client) GetAllDocs(ctx context.Context, startKey string, limit int, des
 
 	return result, nil
 }
+
+func (c *Client) GetDownloadCount(ctx context.Context, packageName string) (int64, error) {
+	url := fmt.Sprintf("%s/downloads/point/last-month/%s", c.downloadsURL, packageName)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch download stats: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, &HTTPError{StatusCode: resp.StatusCode}
+	}
+
+	var downloadInfo struct {
+		Downloads int64 `json:"downloads"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&downloadInfo); err != nil {
+		return 0, fmt.Errorf("failed to decode download stats: %w", err)
+	}
+
+	return downloadInfo.Downloads, nil
+}
@@ -0,0 +1,38 @@
+package discovery
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/models"
+)
+
+// ChangesSource is the registry replication feed the Scraper follows.
+// GetUpdateSequence reports how far the feed has got, for measuring lag.
+type ChangesSource interface {
+	GetChanges(ctx context.Context, since string, limit int) (map[string]interface{}, error)
+	GetUpdateSequence(ctx context.Context) (string, error)
+}
+
+// JobStore is the producer side of the job queue: somewhere to enqueue
+// discovered packages and to checkpoint the feed position.
+type JobStore interface {
+	EnqueueJob(ctx context.Context, job models.Job) (uuid.UUID, error)
+	GetScrapeProgress(ctx context.Context, id string) (string, int64, error)
+	UpdateScrapeProgress(ctx context.Context, id string, lastSequence string, totalProcessed int64) error
+}
+
+// SignalSource reports what is already known about packages, for
+// prioritising their fetch jobs. Names missing from the PackageSignals
+// result are unknown packages.
+type SignalSource interface {
+	PackageSignals(ctx context.Context, names []string) (map[string]PackageSignal, error)
+	PopularPackages(ctx context.Context, limit int) ([]string, error)
+}
+
+var (
+	_ ChangesSource = (*Client)(nil)
+	_ JobStore      = (*JobQueueRepository)(nil)
+	_ SignalSource  = (*JobQueueRepository)(nil)
+)
@@ -4,30 +4,57 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"scrapeNPM/internal/jobs"
 	"scrapeNPM/internal/models"
 )
 
 type JobQueueRepository struct {
-	db *pgxpool.Pool
+	db       *pgxpool.Pool
+	handlers *jobs.Registry
 }
 
-func NewJobQueueRepository(db *pgxpool.Pool) *JobQueueRepository {
-	return &JobQueueRepository{db: db}
+// NewJobQueueRepository returns a repository over the job_queue table. When
+// handlers is non-nil every enqueued job is validated against the payload
+// schema of its type.
+func NewJobQueueRepository(db *pgxpool.Pool, handlers *jobs.Registry) *JobQueueRepository {
+	return &JobQueueRepository{db: db, handlers: handlers}
 }
 
 func (r *JobQueueRepository) EnqueueJob(ctx context.Context, job models.Job) (uuid.UUID, error) {
+	return r.enqueue(ctx, r.db, job)
+}
+
+// EnqueueJobTx is EnqueueJob within tx, so that the job is only queued if
+// the rest of tx commits.
+func (r *JobQueueRepository) EnqueueJobTx(ctx context.Context, tx pgx.Tx, job models.Job) (uuid.UUID, error) {
+	return r.enqueue(ctx, tx, job)
+}
+
+// queryRower is satisfied by both the pool and a transaction.
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+func (r *JobQueueRepository) enqueue(ctx context.Context, db queryRower, job models.Job) (uuid.UUID, error) {
+	if r.handlers != nil {
+		if err := r.handlers.Prepare(&job); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
 	payloadBytes, err := json.Marshal(job.Payload)
 	if err != nil {
 		return uuid.Nil, fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
 	var jobID uuid.UUID
-	err = r.db.QueryRow(ctx, `
+	err = db.QueryRow(ctx, `
         INSERT INTO job_queue (
             job_type, status, priority, payload, max_attempts
         ) VALUES (
@@ -79,28 +106,554 @@ func (r *JobQueueRepository) UpdateScrapeProgress(ctx context.Context, id string
 	return nil
 }
 
-func (r *JobQueueRepository) GetQueueStats(ctx context.Context) (map[string]int, error) {
-	var pending, processing, completed, failed, total int
+// QueueStats describes the job queue now and over the trailing Window.
+// Rates, durations and the retry rate only cover attempts finished within
+// the window.
+type QueueStats struct {
+	GeneratedAt    time.Time           `json:"generated_at"`
+	WindowSeconds  float64             `json:"window_seconds"`
+	Types          []JobTypeStats      `json:"types"`
+	FailureClasses []FailureClassCount `json:"failure_classes"`
+	Discovery      DiscoveryProgress   `json:"discovery"`
+}
 
-	err := r.db.QueryRow(ctx, `
+type JobTypeStats struct {
+	JobType              string  `json:"job_type"`
+	Pending              int64   `json:"pending"`
+	Paused               int64   `json:"paused"`
+	Processing           int64   `json:"processing"`
+	Completed            int64   `json:"completed"`
+	Failed               int64   `json:"failed"`
+	Cancelled            int64   `json:"cancelled"`
+	OldestPendingSeconds float64 `json:"oldest_pending_seconds"`
+	EnqueuedPerMinute    float64 `json:"enqueued_per_minute"`
+	CompletedPerMinute   float64 `json:"completed_per_minute"`
+	FailedPerMinute      float64 `json:"failed_per_minute"`
+	P50Seconds           float64 `json:"p50_seconds"`
+	P95Seconds           float64 `json:"p95_seconds"`
+	RetryRate            float64 `json:"retry_rate"`
+}
+
+type FailureClassCount struct {
+	JobType    string `json:"job_type"`
+	ErrorClass string `json:"error_class"`
+	Count      int64  `json:"count"`
+}
+
+type DiscoveryProgress struct {
+	LastSequence      string    `json:"last_sequence"`
+	TotalProcessed    int64     `json:"total_processed"`
+	LastCheckpoint    time.Time `json:"last_checkpoint"`
+	CheckpointSeconds float64   `json:"checkpoint_age_seconds"`
+}
+
+// GetQueueStats reports per-type queue depth, throughput, latency and
+// failures over the trailing window.
+func (r *JobQueueRepository) GetQueueStats(ctx context.Context, window time.Duration) (*QueueStats, error) {
+	stats := &QueueStats{
+		GeneratedAt:   time.Now(),
+		WindowSeconds: window.Seconds(),
+	}
+	minutes := window.Minutes()
+
+	rows, err := r.db.Query(ctx, `
+        WITH depth AS (
+            SELECT
+                job_type,
+                COUNT(*) FILTER (WHERE status = 'pending') AS pending,
+                COUNT(*) FILTER (WHERE status = 'paused') AS paused,
+                COUNT(*) FILTER (WHERE status = 'processing') AS processing,
+                COUNT(*) FILTER (WHERE status = 'completed') AS completed,
+                COUNT(*) FILTER (WHERE status = 'failed') AS failed,
+                COUNT(*) FILTER (WHERE status = 'cancelled') AS cancelled,
+                COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at) FILTER (WHERE status = 'pending')), 0) AS oldest_pending,
+                COUNT(*) FILTER (WHERE created_at >= NOW() - $1::interval) AS enqueued
+            FROM job_queue
+            GROUP BY job_type
+        ), recent AS (
+            SELECT
+                q.job_type,
+                COUNT(*) FILTER (WHERE a.outcome = 'completed') AS completed,
+                COUNT(*) FILTER (WHERE a.outcome <> 'completed') AS failed,
+                COUNT(*) FILTER (WHERE a.attempt > 1) AS retries,
+                COUNT(*) AS attempts,
+                COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY a.duration_ms)
+                    FILTER (WHERE a.outcome = 'completed'), 0) AS p50_ms,
+                COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY a.duration_ms)
+                    FILTER (WHERE a.outcome = 'completed'), 0) AS p95_ms
+            FROM job_attempts a
+            JOIN job_queue q ON q.id = a.job_id
+            WHERE a.finished_at >= NOW() - $1::interval
+            GROUP BY q.job_type
+        )
         SELECT
-            COUNT(*) FILTER (WHERE status = 'pending') AS pending,
-            COUNT(*) FILTER (WHERE status = 'processing') AS processing,
-            COUNT(*) FILTER (WHERE status = 'completed') AS completed,
-            COUNT(*) FILTER (WHERE status = 'failed') AS failed,
-            COUNT(*) AS total
+            d.job_type, d.pending, d.paused, d.processing, d.completed, d.failed, d.cancelled,
+            d.oldest_pending, d.enqueued,
+            COALESCE(r.completed, 0), COALESCE(r.failed, 0), COALESCE(r.retries, 0),
+            COALESCE(r.attempts, 0), COALESCE(r.p50_ms, 0), COALESCE(r.p95_ms, 0)
+        FROM depth d
+        LEFT JOIN recent r ON r.job_type = d.job_type
+        ORDER BY d.job_type
+    `, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get queue stats: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t JobTypeStats
+		var enqueued, completed, failed, retries, attempts int64
+		var p50, p95 float64
+		if err := rows.Scan(
+			&t.JobType, &t.Pending, &t.Paused, &t.Processing, &t.Completed, &t.Failed, &t.Cancelled,
+			&t.OldestPendingSeconds, &enqueued,
+			&completed, &failed, &retries, &attempts, &p50, &p95,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan queue stats: %w", err)
+		}
+
+		if minutes > 0 {
+			t.EnqueuedPerMinute = float64(enqueued) / minutes
+			t.CompletedPerMinute = float64(completed) / minutes
+			t.FailedPerMinute = float64(failed) / minutes
+		}
+		if attempts > 0 {
+			t.RetryRate = float64(retries) / float64(attempts)
+		}
+		t.P50Seconds = p50 / 1000
+		t.P95Seconds = p95 / 1000
+
+		stats.Types = append(stats.Types, t)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating queue stats: %w", rows.Err())
+	}
+
+	classes, err := r.db.Query(ctx, `
+        SELECT q.job_type, COALESCE(a.error_class, 'unknown'), COUNT(*)
+        FROM job_attempts a
+        JOIN job_queue q ON q.id = a.job_id
+        WHERE a.finished_at >= NOW() - $1::interval AND a.outcome <> 'completed'
+        GROUP BY 1, 2
+        ORDER BY 3 DESC
+    `, window)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get failure classes: %w", err)
+	}
+	defer classes.Close()
+
+	for classes.Next() {
+		var c FailureClassCount
+		if err := classes.Scan(&c.JobType, &c.ErrorClass, &c.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan failure class: %w", err)
+		}
+		stats.FailureClasses = append(stats.FailureClasses, c)
+	}
+
+	if classes.Err() != nil {
+		return nil, fmt.Errorf("error iterating failure classes: %w", classes.Err())
+	}
+
+	err = r.db.QueryRow(ctx, `
+        SELECT COALESCE(last_sequence, ''), COALESCE(total_processed, 0),
+               COALESCE(last_updated, NOW()), COALESCE(EXTRACT(EPOCH FROM NOW() - last_updated), 0)
+        FROM scrape_progress
+        WHERE id = 'npm_changes'
+    `).Scan(&stats.Discovery.LastSequence, &stats.Discovery.TotalProcessed,
+		&stats.Discovery.LastCheckpoint, &stats.Discovery.CheckpointSeconds)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, fmt.Errorf("failed to get scrape progress: %w", err)
+	}
+
+	return stats, nil
+}
+
+// JobFilter selects failed jobs for dead-letter operations. Empty fields
+// match everything; Limit of zero means no limit.
+type JobFilter struct {
+	IDs        []uuid.UUID
+	JobType    string
+	ErrorClass string
+	Limit      int
+}
+
+func (f JobFilter) args() []interface{} {
+	return []interface{}{uuidStrings(f.IDs), f.JobType, f.ErrorClass, f.Limit}
+}
+
+// failedJobsWhere matches failed jobs against the four JobFilter arguments.
+const failedJobsWhere = `
+            status = 'failed'
+            AND (cardinality($1::uuid[]) = 0 OR id = ANY($1::uuid[]))
+            AND ($2 = '' OR job_type = $2)
+            AND ($3 = '' OR COALESCE(error_class, 'unknown') = $3)`
+
+type FailureGroup struct {
+	JobType     string
+	ErrorClass  string
+	Count       int
+	OldestJob   time.Time
+	LastFailure time.Time
+	SampleError string
+}
+
+func (r *JobQueueRepository) ListFailureGroups(ctx context.Context) ([]FailureGroup, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT
+            job_type,
+            COALESCE(error_class, 'unknown'),
+            COUNT(*),
+            MIN(created_at),
+            MAX(COALESCE(started_at, created_at)),
+            COALESCE((ARRAY_AGG(error_message ORDER BY started_at DESC NULLS LAST))[1], '')
         FROM job_queue
-    `).Scan(&pending, &processing, &completed, &failed, &total)
+        WHERE status = 'failed'
+        GROUP BY job_type, COALESCE(error_class, 'unknown')
+        ORDER BY COUNT(*) DESC
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list failure groups: %w", err)
+	}
+	defer rows.Close()
 
+	var groups []FailureGroup
+	for rows.Next() {
+		var g FailureGroup
+		if err := rows.Scan(&g.JobType, &g.ErrorClass, &g.Count, &g.OldestJob, &g.LastFailure, &g.SampleError); err != nil {
+			return nil, fmt.Errorf("failed to scan failure group: %w", err)
+		}
+		groups = append(groups, g)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating failure groups: %w", rows.Err())
+	}
+
+	return groups, nil
+}
+
+func (r *JobQueueRepository) ListFailedJobs(ctx context.Context, filter JobFilter) ([]models.Job, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT id, job_type, status, priority, payload, created_at, started_at,
+               attempts, max_attempts, COALESCE(error_message, ''), COALESCE(error_class, 'unknown'),
+               COALESCE(worker_id, '')
+        FROM job_queue
+        WHERE`+failedJobsWhere+`
+        ORDER BY created_at
+        LIMIT NULLIF($4, 0)
+    `, filter.args()...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get queue stats: %w", err)
+		return nil, fmt.Errorf("failed to list failed jobs: %w", err)
 	}
+	defer rows.Close()
 
-	return map[string]int{
-		"pending":    pending,
-		"processing": processing,
-		"completed":  completed,
-		"failed":     failed,
-		"total":      total,
-	}, nil
+	var jobs []models.Job
+	for rows.Next() {
+		var job models.Job
+		var payloadJSON []byte
+		if err := rows.Scan(
+			&job.ID, &job.Type, &job.Status, &job.Priority, &payloadJSON, &job.CreatedAt, &job.StartedAt,
+			&job.Attempts, &job.MaxAttempts, &job.ErrorMessage, &job.ErrorClass, &job.WorkerID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan failed job: %w", err)
+		}
+
+		if err := json.Unmarshal(payloadJSON, &job.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job payload: %w", err)
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating failed jobs: %w", rows.Err())
+	}
+
+	return jobs, nil
+}
+
+func (r *JobQueueRepository) GetJobAttempts(ctx context.Context, jobID uuid.UUID) ([]models.JobAttempt, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT id, job_id, attempt, COALESCE(worker_id, ''), started_at, finished_at,
+               COALESCE(duration_ms, 0), outcome, COALESCE(error_class, ''),
+               COALESCE(http_status, 0), COALESCE(error_message, '')
+        FROM job_attempts
+        WHERE job_id = $1
+        ORDER BY attempt, id
+    `, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []models.JobAttempt
+	for rows.Next() {
+		var a models.JobAttempt
+		var durationMs int64
+		if err := rows.Scan(
+			&a.ID, &a.JobID, &a.Attempt, &a.WorkerID, &a.StartedAt, &a.FinishedAt,
+			&durationMs, &a.Outcome, &a.ErrorClass, &a.HTTPStatus, &a.ErrorMessage,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan job attempt: %w", err)
+		}
+		a.Duration = time.Duration(durationMs) * time.Millisecond
+		attempts = append(attempts, a)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating job attempts: %w", rows.Err())
+	}
+
+	return attempts, nil
+}
+
+// RequeueFailedJobs resets matching failed jobs to pending with a fresh set
+// of attempts. Their attempt history is kept.
+func (r *JobQueueRepository) RequeueFailedJobs(ctx context.Context, filter JobFilter) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_queue
+        SET
+            status = 'pending',
+            attempts = 0,
+            error_message = NULL,
+            error_class = NULL,
+            worker_id = NULL,
+            started_at = NULL,
+            completed_at = NULL,
+            next_attempt_after = NOW()
+        WHERE id IN (
+            SELECT id FROM job_queue
+            WHERE`+failedJobsWhere+`
+            ORDER BY created_at
+            LIMIT NULLIF($4, 0)
+        )
+    `, filter.args()...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue jobs: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// PurgeFailedJobs deletes matching failed jobs along with their history.
+func (r *JobQueueRepository) PurgeFailedJobs(ctx context.Context, filter JobFilter) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        DELETE FROM job_queue
+        WHERE id IN (
+            SELECT id FROM job_queue
+            WHERE`+failedJobsWhere+`
+            ORDER BY created_at
+            LIMIT NULLIF($4, 0)
+        )
+    `, filter.args()...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge jobs: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// PackageSignals returns what is stored about each of the named packages
+// that exists.
+func (r *JobQueueRepository) PackageSignals(ctx context.Context, names []string) (map[string]PackageSignal, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT
+            p.name,
+            COALESCE(p.downloads, 0),
+            COALESCE((
+                SELECT pv.has_install_scripts
+                FROM package_versions pv
+                WHERE pv.package_id = p.id AND pv.version = p.version
+            ), FALSE),
+            EXISTS (
+                SELECT 1
+                FROM package_scripts ps
+                JOIN script_findings sf ON sf.script_id = ps.id
+                WHERE ps.package_id = p.id AND sf.severity = 'high'
+            )
+        FROM packages p
+        WHERE p.name = ANY($1::text[])
+    `, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query package signals: %w", err)
+	}
+	defer rows.Close()
+
+	signals := make(map[string]PackageSignal, len(names))
+	for rows.Next() {
+		var name string
+		signal := PackageSignal{Known: true}
+		if err := rows.Scan(&name, &signal.Downloads, &signal.HasScripts, &signal.HighFindings); err != nil {
+			return nil, fmt.Errorf("failed to scan package signals: %w", err)
+		}
+		signals[name] = signal
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating package signals: %w", rows.Err())
+	}
+
+	return signals, nil
+}
+
+// PopularPackages returns the names of the limit most downloaded packages.
+func (r *JobQueueRepository) PopularPackages(ctx context.Context, limit int) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT name
+        FROM packages
+        WHERE downloads IS NOT NULL
+        ORDER BY downloads DESC
+        LIMIT $1
+    `, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query popular packages: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan package name: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating popular packages: %w", rows.Err())
+	}
+
+	return names, nil
+}
+
+type PausedJobType struct {
+	JobType  string
+	Reason   string
+	PausedAt time.Time
+}
+
+// PauseJobType stops workers on every instance from claiming jobs of
+// jobType. Jobs already running are left to finish.
+func (r *JobQueueRepository) PauseJobType(ctx context.Context, jobType, reason string) error {
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO paused_job_types (job_type, reason, paused_at)
+        VALUES ($1, NULLIF($2, ''), NOW())
+        ON CONFLICT (job_type) DO UPDATE SET reason = EXCLUDED.reason
+    `, jobType, reason)
+	if err != nil {
+		return fmt.Errorf("failed to pause job type: %w", err)
+	}
+	return nil
+}
+
+// ResumeJobType lifts a pause and wakes idle workers.
+func (r *JobQueueRepository) ResumeJobType(ctx context.Context, jobType string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM paused_job_types WHERE job_type = $1`, jobType)
+	if err != nil {
+		return false, fmt.Errorf("failed to resume job type: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if _, err := r.db.Exec(ctx, `SELECT pg_notify('job_queue', $1)`, jobType); err != nil {
+		return true, fmt.Errorf("failed to notify workers: %w", err)
+	}
+	return true, nil
+}
+
+func (r *JobQueueRepository) ListPausedJobTypes(ctx context.Context) ([]PausedJobType, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT job_type, COALESCE(reason, ''), paused_at
+        FROM paused_job_types
+        ORDER BY job_type
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query paused job types: %w", err)
+	}
+	defer rows.Close()
+
+	var paused []PausedJobType
+	for rows.Next() {
+		var p PausedJobType
+		if err := rows.Scan(&p.JobType, &p.Reason, &p.PausedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan paused job type: %w", err)
+		}
+		paused = append(paused, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating paused job types: %w", rows.Err())
+	}
+
+	return paused, nil
+}
+
+// PauseJobs holds pending jobs back from being claimed until they are
+// resumed.
+func (r *JobQueueRepository) PauseJobs(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_queue
+        SET status = 'paused'
+        WHERE id = ANY($1::uuid[]) AND status = 'pending'
+    `, uuidStrings(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to pause jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ResumeJobs returns paused jobs to the queue.
+func (r *JobQueueRepository) ResumeJobs(ctx context.Context, ids []uuid.UUID) (int64, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_queue
+        SET status = 'pending', next_attempt_after = NOW()
+        WHERE id = ANY($1::uuid[]) AND status = 'paused'
+    `, uuidStrings(ids))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resume jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CancelJobs cancels unfinished jobs matching the IDs and job type of
+// filter; at least one must be set. Running jobs are abandoned by their
+// worker at its next lease heartbeat.
+func (r *JobQueueRepository) CancelJobs(ctx context.Context, filter JobFilter) (int64, error) {
+	if len(filter.IDs) == 0 && filter.JobType == "" {
+		return 0, fmt.Errorf("cancelling jobs requires job IDs or a job type")
+	}
+
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_queue
+        SET
+            status = 'cancelled',
+            completed_at = NOW(),
+            next_attempt_after = NULL,
+            lease_expires_at = NULL,
+            error_message = 'cancelled by operator'
+        WHERE id IN (
+            SELECT id FROM job_queue
+            WHERE
+                status IN ('pending', 'paused', 'processing')
+                AND (cardinality($1::uuid[]) = 0 OR id = ANY($1::uuid[]))
+                AND ($2 = '' OR job_type = $2)
+            ORDER BY created_at
+            LIMIT NULLIF($3, 0)
+        )
+    `, uuidStrings(filter.IDs), filter.JobType, filter.Limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel jobs: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func uuidStrings(ids []uuid.UUID) []string {
+	out := make([]string, len(ids))
+	for i, id := range ids {
+		out[i] = id.String()
+	}
+	return out
 }
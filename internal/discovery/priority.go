@@ -0,0 +1,198 @@
+package discovery
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapeNPM/internal/logging"
+)
+
+const (
+	// DefaultPriority is used for packages nothing is known about. Lower
+	// priorities are claimed first.
+	DefaultPriority = 5
+
+	// BackfillPriority puts packages queued by a bulk backfill behind
+	// everything seen on the changes feed.
+	BackfillPriority = maxPriority
+
+	minPriority = 1
+	maxPriority = 7
+
+	// popularNamesLimit is how many of the most downloaded packages new
+	// names are compared against for lookalikes.
+	popularNamesLimit = 1000
+	popularNamesTTL   = time.Hour
+)
+
+// PackageSignal is what is already stored about a package when it shows up
+// in the changes feed. HasScripts is whether the latest stored version has
+// install scripts.
+type PackageSignal struct {
+	Known        bool
+	Downloads    int64
+	HasScripts   bool
+	HighFindings bool
+}
+
+// Prioritizer computes fetch job priorities so that high-impact and
+// high-risk packages are processed first when the queue is backed up.
+type Prioritizer struct {
+	source SignalSource
+
+	mu          sync.Mutex
+	popular     []string
+	normalized  map[string]string
+	refreshedAt time.Time
+}
+
+func NewPrioritizer(source SignalSource) *Prioritizer {
+	return &Prioritizer{source: source}
+}
+
+// Prioritize returns a priority for each name. If signals can't be loaded
+// every package gets DefaultPriority.
+func (p *Prioritizer) Prioritize(ctx context.Context, names []string) map[string]int {
+	priorities := make(map[string]int, len(names))
+	for _, name := range names {
+		priorities[name] = DefaultPriority
+	}
+
+	signals, err := p.source.PackageSignals(ctx, names)
+	if err != nil {
+		slog.Warn("Failed to load package signals, using default priority", logging.Err(err))
+		return priorities
+	}
+
+	p.refreshPopular(ctx)
+
+	for _, name := range names {
+		priorities[name] = p.score(name, signals[name])
+	}
+	return priorities
+}
+
+func (p *Prioritizer) score(name string, signal PackageSignal) int {
+	priority := DefaultPriority
+
+	switch {
+	case !signal.Known:
+		// Brand-new packages are where most malicious publishes appear.
+		priority--
+	case signal.Downloads >= 1000000:
+		priority -= 2
+	case signal.Downloads >= 10000:
+		priority--
+	case signal.Downloads < 100:
+		priority++
+	}
+
+	if signal.HasScripts {
+		priority--
+	}
+	if signal.HighFindings {
+		priority--
+	}
+	if p.resemblesPopular(name) {
+		priority -= 2
+	}
+
+	if priority < minPriority {
+		return minPriority
+	}
+	if priority > maxPriority {
+		return maxPriority
+	}
+	return priority
+}
+
+func (p *Prioritizer) refreshPopular(ctx context.Context) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if time.Since(p.refreshedAt) < popularNamesTTL {
+		return
+	}
+
+	names, err := p.source.PopularPackages(ctx, popularNamesLimit)
+	if err != nil {
+		slog.Warn("Failed to load popular packages", logging.Err(err))
+		return
+	}
+
+	p.popular = names
+	p.normalized = make(map[string]string, len(names))
+	for _, name := range names {
+		p.normalized[normalizeName(name)] = name
+	}
+	p.refreshedAt = time.Now()
+}
+
+// resemblesPopular reports whether name looks like, but is not, one of the
+// most downloaded packages: the same after dropping scope, case and
+// separators, or one edit away.
+func (p *Prioritizer) resemblesPopular(name string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if original, ok := p.normalized[normalizeName(name)]; ok && original != name {
+		return true
+	}
+
+	if len(name) < 4 {
+		return false
+	}
+
+	for _, popular := range p.popular {
+		if popular == name || len(popular) < 4 {
+			continue
+		}
+		if d := len(popular) - len(name); d > 1 || d < -1 {
+			continue
+		}
+		if editDistance(name, popular) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func normalizeName(name string) string {
+	if strings.HasPrefix(name, "@") {
+		if i := strings.Index(name, "/"); i >= 0 {
+			name = name[i+1:]
+		}
+	}
+	name = strings.ToLower(name)
+	return strings.NewReplacer("-", "", "_", "", ".", "").Replace(name)
+}
+
+// editDistance is the Damerau-Levenshtein distance (optimal string
+// alignment) between a and b, so a swap of adjacent characters counts as one.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
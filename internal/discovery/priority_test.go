@@ -0,0 +1,108 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// fakeSignals serves fixed signals and popular names.
+type fakeSignals struct {
+	signals map[string]PackageSignal
+	popular []string
+	err     error
+}
+
+func (f *fakeSignals) PackageSignals(ctx context.Context, names []string) (map[string]PackageSignal, error) {
+	return f.signals, f.err
+}
+
+func (f *fakeSignals) PopularPackages(ctx context.Context, limit int) ([]string, error) {
+	return f.popular, nil
+}
+
+func TestPrioritize(t *testing.T) {
+	source := &fakeSignals{
+		signals: map[string]PackageSignal{
+			"lodash":      {Known: true, Downloads: 50000000},
+			"express":     {Known: true, Downloads: 30000},
+			"tiny-util":   {Known: true, Downloads: 50},
+			"tiny-hook":   {Known: true, Downloads: 50, HasScripts: true},
+			"mid-hook":    {Known: true, Downloads: 500, HasScripts: true, HighFindings: true},
+			"lodahs":      {Known: true, Downloads: 5000000, HasScripts: true, HighFindings: true},
+			"quiet-thing": {Known: true, Downloads: 500},
+		},
+		popular: []string{"lodash", "react-dom", "express", "@babel/core", "vue"},
+	}
+
+	tests := []struct {
+		name string
+		want int
+	}{
+		{"brand-new-thing", 4},  // unknown
+		{"lodash", 3},           // heavily downloaded, and popular itself
+		{"express", 4},          // well downloaded
+		{"quiet-thing", 5},      // known, moderate downloads
+		{"tiny-util", 6},        // rarely downloaded, no scripts
+		{"tiny-hook", 5},        // rarely downloaded, but with scripts
+		{"mid-hook", 3},         // scripts with high-severity findings
+		{"lodahs", minPriority}, // clamped: every signal at once
+		{"@evil/lodash", 2},     // scoped variant
+		{"Express", 2},          // case variant
+		{"react_dom", 2},        // separator variant
+		{"reactdom", 2},         // separators dropped
+		{"lodsh", 2},            // one deletion
+		{"expresss", 2},         // one insertion
+		{"lodahs-x", 4},         // two edits away
+		{"vua", 4},              // too short to compare by edits
+	}
+
+	names := make([]string, len(tests))
+	for i, tt := range tests {
+		names[i] = tt.name
+	}
+
+	priorities := NewPrioritizer(source).Prioritize(context.Background(), names)
+	for _, tt := range tests {
+		if got := priorities[tt.name]; got != tt.want {
+			t.Errorf("priority of %s = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrioritizeWithoutSignals(t *testing.T) {
+	source := &fakeSignals{err: errors.New("database unavailable"), popular: []string{"lodash"}}
+
+	priorities := NewPrioritizer(source).Prioritize(context.Background(), []string{"lodahs", "left-pad"})
+	for name, got := range priorities {
+		if got != DefaultPriority {
+			t.Errorf("priority of %s = %d without signals, want %d", name, got, DefaultPriority)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"lodash", "lodash", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"lodash", "lodas", 1},   // deletion
+		{"lodash", "lodassh", 1}, // insertion
+		{"lodash", "lodosh", 1},  // substitution
+		{"lodash", "lodahs", 1},  // adjacent transposition
+		{"ab", "ba", 1},
+		{"express", "exrpess", 1},
+		{"kitten", "sitting", 3},
+		{"ca", "abc", 3}, // optimal string alignment never edits a transposed pair again
+	}
+
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package discovery
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Interaction is a single recorded request/response pair as stored on disk.
+// Text bodies are kept inline as a string holding the exact bytes received,
+// so fixtures can be read and edited by hand; anything else is base64
+// encoded. Fixtures recorded before bodies were kept verbatim hold JSON
+// bodies inline as JSON, and are still replayed.
+type Interaction struct {
+	Method     string          `json:"method"`
+	URL        string          `json:"url"`
+	Index      int             `json:"index"`
+	StatusCode int             `json:"status_code"`
+	Header     http.Header     `json:"header,omitempty"`
+	Body       json.RawMessage `json:"body,omitempty"`
+	BodyBase64 string          `json:"body_base64,omitempty"`
+}
+
+func interactionKey(method, url string) string {
+	return method + " " + url
+}
+
+func interactionFilename(key string, index int) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s-%04d.json", hex.EncodeToString(sum[:8]), index)
+}
+
+// RecordingTransport forwards requests to an underlying transport and writes
+// every exchange into a fixture directory that ReplayTransport can serve.
+type RecordingTransport struct {
+	next   http.RoundTripper
+	dir    string
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewRecordingTransport(dir string, next http.RoundTripper) (*RecordingTransport, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+
+	existing, err := loadInteractions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int)
+	for key, interactions := range existing {
+		counts[key] = len(interactions)
+	}
+
+	return &RecordingTransport{next: next, dir: dir, counts: counts}, nil
+}
+
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body for recording: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	key := interactionKey(req.Method, req.URL.String())
+
+	t.mu.Lock()
+	index := t.counts[key]
+	t.counts[key]++
+	t.mu.Unlock()
+
+	interaction := Interaction{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Index:      index,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header.Clone(),
+	}
+	if utf8.Valid(body) && len(body) > 0 {
+		text, err := json.Marshal(string(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode response body: %w", err)
+		}
+		interaction.Body = text
+	} else if len(body) > 0 {
+		interaction.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	content, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal interaction: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(t.dir, interactionFilename(key, index)), content, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write interaction: %w", err)
+	}
+
+	return resp, nil
+}
+
+// ReplayTransport serves responses previously captured by RecordingTransport
+// without touching the network. Repeated requests for the same URL are
+// answered in recording order; once exhausted the last response is reused,
+// which keeps polling loops such as the changes follower stable.
+type ReplayTransport struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+	served       map[string]int
+}
+
+func NewReplayTransport(dir string) (*ReplayTransport, error) {
+	interactions, err := loadInteractions(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(interactions) == 0 {
+		return nil, fmt.Errorf("no recorded interactions found in %s", dir)
+	}
+
+	return &ReplayTransport{
+		interactions: interactions,
+		served:       make(map[string]int),
+	}, nil
+}
+
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := interactionKey(req.Method, req.URL.String())
+
+	t.mu.Lock()
+	recorded, ok := t.interactions[key]
+	if !ok {
+		t.mu.Unlock()
+		return nil, fmt.Errorf("no recorded response for %s", key)
+	}
+
+	index := t.served[key]
+	if index >= len(recorded) {
+		index = len(recorded) - 1
+	}
+	t.served[key]++
+	interaction := recorded[index]
+	t.mu.Unlock()
+
+	body, err := interaction.body()
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode recorded body for %s: %w", key, err)
+	}
+
+	// Fixtures may have been edited by hand, so the length is taken from the
+	// body rather than the recorded header.
+	header := interaction.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	header.Set("Content-Length", fmt.Sprint(len(body)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", interaction.StatusCode, http.StatusText(interaction.StatusCode)),
+		StatusCode:    interaction.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// body returns the response body exactly as it was recorded.
+func (i Interaction) body() ([]byte, error) {
+	if i.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(i.BodyBase64)
+	}
+	if len(i.Body) > 0 && i.Body[0] == '"' {
+		var text string
+		if err := json.Unmarshal(i.Body, &text); err != nil {
+			return nil, err
+		}
+		return []byte(text), nil
+	}
+	return i.Body, nil
+}
+
+func loadInteractions(dir string) (map[string][]Interaction, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list recorded interactions: %w", err)
+	}
+
+	interactions := make(map[string][]Interaction)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read interaction %s: %w", filepath.Base(file), err)
+		}
+
+		var interaction Interaction
+		if err := json.Unmarshal(content, &interaction); err != nil {
+			return nil, fmt.Errorf("failed to parse interaction %s: %w", filepath.Base(file), err)
+		}
+
+		if interaction.Method == "" || !strings.Contains(interaction.URL, "://") {
+			return nil, fmt.Errorf("interaction %s is missing method or url", filepath.Base(file))
+		}
+
+		key := interactionKey(interaction.Method, interaction.URL)
+		interactions[key] = append(interactions[key], interaction)
+	}
+
+	for key := range interactions {
+		sort.Slice(interactions[key], func(i, j int) bool {
+			return interactions[key][i].Index < interactions[key][j].Index
+		})
+	}
+
+	return interactions, nil
+}
@@ -0,0 +1,210 @@
+package discovery_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/fakeregistry"
+	"scrapeNPM/internal/metrics"
+)
+
+// rawBodies are served byte for byte, to check that recording keeps the
+// exact bytes rather than re-encoding them.
+var rawBodies = map[string][]byte{
+	"/spaced":  []byte("{\n    \"name\":   \"spaced\",\n\"list\": [1,2,   3]}\n"),
+	"/text":    []byte("not json at all"),
+	"/tarball": {0x1f, 0x8b, 0x08, 0x00, 0xff, 0xfe, 0x00, 0x01},
+}
+
+func TestRecordReplayRoundTrip(t *testing.T) {
+	fixtures, err := fakeregistry.LoadFixtures("../fakeregistry/testdata/basic")
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	registry := fakeregistry.New(fixtures)
+	mux := http.NewServeMux()
+	for path, body := range rawBodies {
+		body := body
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.Write(body)
+		})
+	}
+	mux.Handle("/", registry)
+	ts := httptest.NewServer(mux)
+
+	dir := t.TempDir()
+	cfg := fakeregistry.ClientConfig(ts.URL)
+	cfg.RecordDir = dir
+	recorder, err := discovery.NewClientWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewClientWithConfig recording: %v", err)
+	}
+
+	ctx := context.Background()
+	live := exercise(t, ctx, recorder)
+	liveRaw := fetchRaw(t, dir, ts.URL, nil)
+	ts.Close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) == 0 {
+		t.Fatal("nothing was recorded")
+	}
+
+	cfg.RecordDir, cfg.ReplayDir = "", dir
+	replayer, err := discovery.NewClientWithConfig(cfg)
+	if err != nil {
+		t.Fatalf("NewClientWithConfig replaying: %v", err)
+	}
+
+	host := mustHost(t, ts.URL)
+	before := requestCount(t, host, "200") + requestCount(t, host, "404")
+	replayed := exercise(t, ctx, replayer)
+	after := requestCount(t, host, "200") + requestCount(t, host, "404")
+	if after-before != float64(len(replayed)) {
+		t.Errorf("replay counted %v upstream requests, want %d", after-before, len(replayed))
+	}
+
+	for name, want := range live {
+		if replayed[name] != want {
+			t.Errorf("%s: replayed %q, recorded %q", name, replayed[name], want)
+		}
+	}
+
+	replayTransport, err := discovery.NewReplayTransport(dir)
+	if err != nil {
+		t.Fatalf("NewReplayTransport: %v", err)
+	}
+	replayedRaw := fetchRaw(t, dir, ts.URL, replayTransport)
+	for path, want := range rawBodies {
+		if !bytes.Equal(liveRaw[path].body, want) {
+			t.Fatalf("%s: live body differs from what was served", path)
+		}
+		got := replayedRaw[path]
+		if !bytes.Equal(got.body, want) {
+			t.Errorf("%s: replayed body %q, want %q", path, got.body, want)
+		}
+		if got.contentLength != strconv.Itoa(len(want)) {
+			t.Errorf("%s: replayed Content-Length %s, body is %d bytes", path, got.contentLength, len(want))
+		}
+	}
+}
+
+func TestReplayEmptyDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := discovery.NewReplayTransport(dir); err == nil {
+		t.Error("NewReplayTransport accepted an empty directory")
+	}
+}
+
+// exercise calls every client method and returns a summary of each result.
+func exercise(t *testing.T, ctx context.Context, c *discovery.Client) map[string]string {
+	t.Helper()
+	out := make(map[string]string)
+
+	doc, err := c.GetPackage(ctx, "postinstall-beacon")
+	if err != nil {
+		t.Fatalf("GetPackage: %v", err)
+	}
+	out["package"] = doc["name"].(string) + "@" + doc["dist-tags"].(map[string]interface{})["latest"].(string)
+
+	for i := 0; i < 2; i++ {
+		feed, err := c.GetChanges(ctx, "0", 2)
+		if err != nil {
+			t.Fatalf("GetChanges: %v", err)
+		}
+		out["changes"+strconv.Itoa(i)] = strconv.Itoa(len(feed["results"].([]interface{})))
+	}
+
+	seq, err := c.GetUpdateSequence(ctx)
+	if err != nil {
+		t.Fatalf("GetUpdateSequence: %v", err)
+	}
+	out["seq"] = seq
+
+	docs, err := c.GetAllDocs(ctx, "left-pad", 10, false)
+	if err != nil {
+		t.Fatalf("GetAllDocs: %v", err)
+	}
+	out["all_docs"] = strconv.Itoa(len(docs["rows"].([]interface{})))
+
+	downloads, err := c.GetDownloadCount(ctx, "left-pad")
+	if err != nil {
+		t.Fatalf("GetDownloadCount: %v", err)
+	}
+	out["downloads"] = strconv.FormatInt(downloads, 10)
+
+	_, err = c.GetPackage(ctx, "no-such-package")
+	out["missing"] = errString(err)
+
+	return out
+}
+
+type rawResponse struct {
+	body          []byte
+	contentLength string
+}
+
+// fetchRaw requests each raw body path, through a recorder writing to dir
+// when transport is nil and through transport otherwise.
+func fetchRaw(t *testing.T, dir, baseURL string, transport http.RoundTripper) map[string]rawResponse {
+	t.Helper()
+	if transport == nil {
+		recorder, err := discovery.NewRecordingTransport(dir, http.DefaultTransport)
+		if err != nil {
+			t.Fatalf("NewRecordingTransport: %v", err)
+		}
+		transport = recorder
+	}
+	client := &http.Client{Transport: transport}
+
+	out := make(map[string]rawResponse)
+	for path := range rawBodies {
+		resp, err := client.Get(baseURL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		out[path] = rawResponse{body: body, contentLength: resp.Header.Get("Content-Length")}
+	}
+	return out
+}
+
+func requestCount(t *testing.T, host, status string) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.UpstreamRequests.WithLabelValues(host, status).Write(&m); err != nil {
+		t.Fatalf("read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func mustHost(t *testing.T, raw string) string {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %s: %v", raw, err)
+	}
+	return u.Host
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -3,44 +3,78 @@ package discovery
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync/atomic"
 	"time"
 
-	"scrapeNPM/internal/models"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/metrics"
+	"scrapeNPM/internal/tracing"
 )
 
 type Config struct {
-	BatchSize    int
-	RequestDelay time.Duration
-	MaxRetries   int
+	// BatchSize is how many changes are read from the feed at once.
+	BatchSize int `yaml:"batch_size"`
+
+	// RequestDelay is the pause between batches. Failed batches wait three
+	// times as long.
+	RequestDelay time.Duration `yaml:"request_delay"`
+
+	// IdleDelay is the pause after a batch with no changes.
+	IdleDelay time.Duration `yaml:"idle_delay"`
+
+	MaxRetries int `yaml:"-"`
 }
 
 func DefaultConfig() Config {
 	return Config{
 		BatchSize:    1000,
 		RequestDelay: time.Second * 2,
+		IdleDelay:    time.Second * 30,
 		MaxRetries:   3,
 	}
 }
 
+// feedLagInterval is how often the scraper asks the registry for its latest
+// sequence.
+const feedLagInterval = time.Minute
+
 type Scraper struct {
 	config         Config
-	npmClient      *Client
-	jobQueue       *JobQueueRepository
+	changes        ChangesSource
+	jobQueue       JobStore
+	prioritizer    *Prioritizer
 	lastSequence   string
 	totalProcessed int64
+	updateSequence string
+	lagCheckedAt   time.Time
+
+	// progressAt is when the scraper last fetched a batch successfully, in
+	// Unix nanoseconds.
+	progressAt int64
 }
 
-func NewScraper(config Config, npmClient *Client, jobQueue *JobQueueRepository) *Scraper {
-	return &Scraper{
-		config:    config,
-		npmClient: npmClient,
-		jobQueue:  jobQueue,
+// NewScraper returns a scraper enqueueing fetch jobs for every change. When
+// signals is nil every job gets DefaultPriority.
+func NewScraper(config Config, changes ChangesSource, jobQueue JobStore, signals SignalSource) *Scraper {
+	s := &Scraper{
+		config:     config,
+		changes:    changes,
+		jobQueue:   jobQueue,
+		progressAt: time.Now().UnixNano(),
+	}
+	if signals != nil {
+		s.prioritizer = NewPrioritizer(signals)
 	}
+	return s
 }
 
 func (s *Scraper) Run(ctx context.Context) error {
-	log.Printf("Starting NPM registry scraper")
+	slog.Info("Starting NPM registry scraper")
 
 	lastSeq, processed, err := s.jobQueue.GetScrapeProgress(ctx, "npm_changes")
 	if err != nil {
@@ -50,29 +84,41 @@ func (s *Scraper) Run(ctx context.Context) error {
 	s.lastSequence = lastSeq
 	s.totalProcessed = processed
 
-	log.Printf("Resuming from sequence ID: %s (processed %d packages so far)", s.lastSequence, s.totalProcessed)
+	slog.Info("Resuming changes feed", "sequence", s.lastSequence, "total_processed", s.totalProcessed)
+	atomic.StoreInt64(&s.progressAt, time.Now().UnixNano())
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Printf("Scraper stopping due to context cancellation")
+			slog.Info("Scraper stopping due to context cancellation")
 			return nil
 		default:
 			if err := s.processBatch(ctx); err != nil {
-				log.Printf("Error processing batch: %v", err)
+				slog.Error("Error processing changes batch", "sequence", s.lastSequence, logging.Err(err))
 				time.Sleep(s.config.RequestDelay * 3)
 				continue
 			}
+			atomic.StoreInt64(&s.progressAt, time.Now().UnixNano())
 
 			time.Sleep(s.config.RequestDelay)
 		}
 	}
 }
 
-func (s *Scraper) processBatch(ctx context.Context) error {
-	log.Printf("Fetching changes since %s", s.lastSequence)
+// LastProgress reports when the scraper last fetched and queued a batch of
+// changes, or when it started if it has not managed one yet.
+func (s *Scraper) LastProgress() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&s.progressAt))
+}
+
+func (s *Scraper) processBatch(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "discovery.process_batch",
+		trace.WithAttributes(attribute.String("npm.since", s.lastSequence)))
+	defer func() { tracing.End(span, err) }()
+
+	slog.Debug("Fetching changes", "since", s.lastSequence)
 
-	changes, err := s.npmClient.GetChanges(ctx, s.lastSequence, s.config.BatchSize)
+	changes, err := s.changes.GetChanges(ctx, s.lastSequence, s.config.BatchSize)
 	if err != nil {
 		return fmt.Errorf("failed to fetch changes: %w", err)
 	}
@@ -82,13 +128,15 @@ func (s *Scraper) processBatch(ctx context.Context) error {
 		return fmt.Errorf("unexpected response format: results not found or not an array")
 	}
 
+	span.SetAttributes(attribute.Int("npm.changes", len(results)))
+
 	if len(results) == 0 {
-		log.Printf("No new changes found, waiting longer before next check")
-		time.Sleep(time.Second * 30)
+		slog.Debug("No new changes found, waiting longer before next check")
+		time.Sleep(s.config.IdleDelay)
 		return nil
 	}
 
-	processed := 0
+	var names []string
 	for _, result := range results {
 		change, ok := result.(map[string]interface{})
 		if !ok {
@@ -108,20 +156,23 @@ func (s *Scraper) processBatch(ctx context.Context) error {
 			continue
 		}
 
-		job := models.Job{
-			Type:        "fetch_package",
-			Status:      "pending",
-			Priority:    5,
-			MaxAttempts: 3,
-			Payload: map[string]interface{}{
-				"package_name": id,
-				"created_at":   time.Now(),
-			},
+		names = append(names, id)
+	}
+
+	var priorities map[string]int
+	if s.prioritizer != nil && len(names) > 0 {
+		priorities = s.prioritizer.Prioritize(ctx, names)
+	}
+
+	processed := 0
+	for _, id := range names {
+		priority := DefaultPriority
+		if p, ok := priorities[id]; ok {
+			priority = p
 		}
 
-		_, err := s.jobQueue.EnqueueJob(ctx, job)
-		if err != nil {
-			log.Printf("Failed to enqueue job for package %s: %v", id, err)
+		if err := s.enqueue(ctx, id, priority); err != nil {
+			slog.Error("Failed to enqueue fetch job", logging.KeyPackage, id, logging.Err(err))
 			continue
 		}
 
@@ -129,31 +180,73 @@ func (s *Scraper) processBatch(ctx context.Context) error {
 		s.totalProcessed++
 	}
 
-	log.Printf("Processed batch: queued %d new packages", processed)
-
-	newLastSeq := ""
-	if lastSeq, ok := changes["last_seq"].(string); ok {
-		newLastSeq = lastSeq
-	} else if lastSeqNum, ok := changes["last_seq"].(float64); ok {
-		newLastSeq = fmt.Sprintf("%d", int(lastSeqNum))
-	} else if lastSeqObj, ok := changes["last_seq"].(map[string]interface{}); ok {
-		if seq, ok := lastSeqObj["seq"]; ok {
-			if seqStr, ok := seq.(string); ok {
-				newLastSeq = seqStr
-			} else if seqNum, ok := seq.(float64); ok {
-				newLastSeq = fmt.Sprintf("%d", int(seqNum))
-			}
-		}
-	}
+	slog.Info("Processed changes batch", "changes", len(results), "queued", processed)
 
+	newLastSeq := sequenceString(changes["last_seq"])
 	if newLastSeq != "" && newLastSeq != s.lastSequence {
 		s.lastSequence = newLastSeq
 
 		err := s.jobQueue.UpdateScrapeProgress(ctx, "npm_changes", s.lastSequence, s.totalProcessed)
 		if err != nil {
-			log.Printf("Warning: failed to update scrape progress: %v", err)
+			slog.Warn("Failed to update scrape progress", logging.Err(err))
 		}
 	}
 
+	s.updateFeedLag(ctx)
+
 	return nil
 }
+
+// enqueue queues a fetch job for a changed package. Each change starts its
+// own trace, linked to the batch it was seen in, which the job carries on to
+// the worker that processes it.
+func (s *Scraper) enqueue(ctx context.Context, name string, priority int) (err error) {
+	ctx, span := tracing.Start(ctx, "discovery.change",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(ctx)),
+		trace.WithAttributes(
+			attribute.String("npm.package", name),
+			attribute.Int("job.priority", priority),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	job, err := jobs.NewJob(jobs.TypeFetchPackage, &jobs.FetchPackagePayload{PackageName: name}, priority)
+	if err != nil {
+		return err
+	}
+	tracing.InjectJob(ctx, &job)
+
+	_, err = s.jobQueue.EnqueueJob(ctx, job)
+	return err
+}
+
+// updateFeedLag compares the checkpoint with the registry's latest sequence,
+// which is re-read at most once per feedLagInterval.
+func (s *Scraper) updateFeedLag(ctx context.Context) {
+	if time.Since(s.lagCheckedAt) >= feedLagInterval {
+		s.lagCheckedAt = time.Now()
+
+		latest, err := s.changes.GetUpdateSequence(ctx)
+		if err != nil {
+			slog.Warn("Failed to get registry update sequence", logging.Err(err))
+		} else {
+			s.updateSequence = latest
+		}
+	}
+
+	metrics.SetFeedPosition(s.lastSequence, s.updateSequence)
+}
+
+// sequenceString normalises a feed sequence, which the registry reports as
+// a string, a number or an object with a seq field.
+func sequenceString(v interface{}) string {
+	switch seq := v.(type) {
+	case string:
+		return seq
+	case float64:
+		return fmt.Sprintf("%d", int(seq))
+	case map[string]interface{}:
+		return sequenceString(seq["seq"])
+	}
+	return ""
+}
@@ -0,0 +1,140 @@
+package fakeregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Fixtures holds the registry contents served by a fake Server.
+//
+// A fixture directory is laid out as follows, and every part is optional:
+//
+//	packuments/*.json   full package documents, keyed by their "name" field
+//	changes.json        array of _changes results ({"seq", "id", "deleted"})
+//	downloads.json      object mapping package name to monthly downloads
+//	tarballs/*.tgz      tarballs served at /<name>/-/<file>
+//
+// When changes.json is absent a feed is generated from the packuments in
+// name order.
+type Fixtures struct {
+	Packuments map[string]map[string]interface{}
+	Changes    []Change
+	Downloads  map[string]int64
+	Tarballs   map[string][]byte
+}
+
+type Change struct {
+	Seq     int64  `json:"seq"`
+	ID      string `json:"id"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+func NewFixtures() *Fixtures {
+	return &Fixtures{
+		Packuments: make(map[string]map[string]interface{}),
+		Downloads:  make(map[string]int64),
+		Tarballs:   make(map[string][]byte),
+	}
+}
+
+func LoadFixtures(dir string) (*Fixtures, error) {
+	fx := NewFixtures()
+
+	files, err := filepath.Glob(filepath.Join(dir, "packuments", "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list packuments: %w", err)
+	}
+
+	for _, file := range files {
+		var doc map[string]interface{}
+		if err := readJSON(file, &doc); err != nil {
+			return nil, err
+		}
+
+		name, ok := doc["name"].(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("packument %s has no name", filepath.Base(file))
+		}
+		fx.Packuments[name] = doc
+	}
+
+	changesFile := filepath.Join(dir, "changes.json")
+	if _, err := os.Stat(changesFile); err == nil {
+		if err := readJSON(changesFile, &fx.Changes); err != nil {
+			return nil, err
+		}
+	} else {
+		fx.Changes = fx.generateChanges()
+	}
+
+	downloadsFile := filepath.Join(dir, "downloads.json")
+	if _, err := os.Stat(downloadsFile); err == nil {
+		if err := readJSON(downloadsFile, &fx.Downloads); err != nil {
+			return nil, err
+		}
+	}
+
+	tarballs, err := filepath.Glob(filepath.Join(dir, "tarballs", "*.tgz"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tarballs: %w", err)
+	}
+
+	for _, file := range tarballs {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tarball %s: %w", filepath.Base(file), err)
+		}
+		fx.Tarballs[filepath.Base(file)] = content
+	}
+
+	return fx, nil
+}
+
+// AddPackument registers a package document and appends it to the changes
+// feed so a running scraper will discover it.
+func (fx *Fixtures) AddPackument(doc map[string]interface{}) {
+	name, _ := doc["name"].(string)
+	fx.Packuments[name] = doc
+	fx.Changes = append(fx.Changes, Change{Seq: fx.lastSeq() + 1, ID: name})
+}
+
+func (fx *Fixtures) generateChanges() []Change {
+	names := fx.names()
+	changes := make([]Change, 0, len(names))
+	for i, name := range names {
+		changes = append(changes, Change{Seq: int64(i + 1), ID: name})
+	}
+	return changes
+}
+
+func (fx *Fixtures) names() []string {
+	names := make([]string, 0, len(fx.Packuments))
+	for name := range fx.Packuments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (fx *Fixtures) lastSeq() int64 {
+	if len(fx.Changes) == 0 {
+		return 0
+	}
+	return fx.Changes[len(fx.Changes)-1].Seq
+}
+
+func readJSON(file string, v interface{}) error {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("failed to read fixture %s: %w", filepath.Base(file), err)
+	}
+
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to parse fixture %s: %w", filepath.Base(file), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,354 @@
+package fakeregistry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"scrapeNPM/internal/discovery"
+)
+
+const abbreviatedMediaType = "application/vnd.npm.install-v1+json"
+
+// Fault describes a failure injected into responses whose path starts with
+// PathPrefix. Times limits how many requests are affected; zero means every
+// matching request until the fault is cleared.
+type Fault struct {
+	PathPrefix string
+	Status     int
+	Delay      time.Duration
+	Malformed  bool
+	Times      int
+}
+
+// Server is an in-process stand-in for registry.npmjs.org,
+// replicate.npmjs.com and api.npmjs.org, all served from one host.
+type Server struct {
+	mu       sync.Mutex
+	fixtures *Fixtures
+	faults   []*Fault
+	requests []string
+}
+
+func New(fixtures *Fixtures) *Server {
+	return &Server{fixtures: fixtures}
+}
+
+// Start serves s on a loopback httptest server. Callers must Close it.
+func (s *Server) Start() *httptest.Server {
+	return httptest.NewServer(s)
+}
+
+// ClientConfig returns a discovery client configuration pointing every
+// upstream at the fake server listening on baseURL.
+func ClientConfig(baseURL string) discovery.ClientConfig {
+	cfg := discovery.DefaultClientConfig()
+	cfg.RegistryURL = baseURL
+	cfg.ReplicateURL = baseURL + "/registry"
+	cfg.DownloadsURL = baseURL
+	return cfg
+}
+
+func (s *Server) Inject(fault Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f := fault
+	s.faults = append(s.faults, &f)
+}
+
+func (s *Server) InjectStatus(pathPrefix string, status int, times int) {
+	s.Inject(Fault{PathPrefix: pathPrefix, Status: status, Times: times})
+}
+
+func (s *Server) InjectDelay(pathPrefix string, delay time.Duration, times int) {
+	s.Inject(Fault{PathPrefix: pathPrefix, Delay: delay, Times: times})
+}
+
+func (s *Server) InjectMalformedJSON(pathPrefix string, times int) {
+	s.Inject(Fault{PathPrefix: pathPrefix, Malformed: true, Times: times})
+}
+
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// AddPackument publishes a new package document while the server is running.
+func (s *Server) AddPackument(doc map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures.AddPackument(doc)
+}
+
+// Requests returns the method and request URI of every request served so far.
+func (s *Server) Requests() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+	fault := s.takeFault(r.URL.Path)
+	s.mu.Unlock()
+
+	if fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		if fault.Status != 0 {
+			if fault.Status == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			http.Error(w, http.StatusText(fault.Status), fault.Status)
+			return
+		}
+
+		if fault.Malformed {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"name": "truncated", "versions": {`)
+			return
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case path == "/registry" || path == "/registry/":
+		s.serveRoot(w)
+	case path == "/registry/_changes":
+		s.serveChanges(w, r)
+	case path == "/registry/_all_docs":
+		s.serveAllDocs(w, r)
+	case strings.HasPrefix(path, "/downloads/point/"):
+		s.serveDownloads(w, strings.TrimPrefix(path, "/downloads/point/"))
+	case strings.Contains(path, "/-/"):
+		s.serveTarball(w, path)
+	default:
+		s.servePackument(w, r, strings.TrimPrefix(path, "/"))
+	}
+}
+
+// takeFault returns the first fault matching path and consumes one of its
+// uses. Callers must hold s.mu.
+func (s *Server) takeFault(path string) *Fault {
+	for i, f := range s.faults {
+		if !strings.HasPrefix(path, f.PathPrefix) {
+			continue
+		}
+
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+
+		return f
+	}
+	return nil
+}
+
+func (s *Server) serveRoot(w http.ResponseWriter) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"db_name":    "registry",
+		"doc_count":  len(s.fixtures.Packuments),
+		"update_seq": s.fixtures.lastSeq(),
+	})
+}
+
+func (s *Server) serveChanges(w http.ResponseWriter, r *http.Request) {
+	since, _ := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit < 1 {
+		limit = 1000
+	}
+
+	results := []map[string]interface{}{}
+	lastSeq := since
+	for _, change := range s.fixtures.Changes {
+		if change.Seq <= since {
+			continue
+		}
+		if len(results) >= limit {
+			break
+		}
+
+		result := map[string]interface{}{
+			"seq":     change.Seq,
+			"id":      change.ID,
+			"changes": []map[string]string{{"rev": "1-fake"}},
+		}
+		if change.Deleted {
+			result["deleted"] = true
+		}
+
+		results = append(results, result)
+		lastSeq = change.Seq
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"results":  results,
+		"last_seq": lastSeq,
+	})
+}
+
+func (s *Server) serveAllDocs(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	startKey := strings.Trim(query.Get("startkey"), `"`)
+	descending := query.Get("descending") == "true"
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit < 1 {
+		limit = 1000
+	}
+
+	names := s.fixtures.names()
+	if descending {
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	}
+
+	rows := []map[string]interface{}{}
+	offset := 0
+	for _, name := range names {
+		if startKey != "" && ((!descending && name < startKey) || (descending && name > startKey)) {
+			offset++
+			continue
+		}
+		if len(rows) >= limit {
+			break
+		}
+
+		rows = append(rows, map[string]interface{}{
+			"id":    name,
+			"key":   name,
+			"value": map[string]string{"rev": "1-fake"},
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total_rows": len(names),
+		"offset":     offset,
+		"rows":       rows,
+	})
+}
+
+func (s *Server) serveDownloads(w http.ResponseWriter, rest string) {
+	period, name, ok := strings.Cut(rest, "/")
+	if !ok || name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+		return
+	}
+
+	downloads, ok := s.fixtures.Downloads[name]
+	if !ok {
+		if _, exists := s.fixtures.Packuments[name]; !exists {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "package " + name + " not found"})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"downloads": downloads,
+		"package":   name,
+		"start":     "2000-01-01",
+		"end":       "2000-01-31",
+		"period":    period,
+	})
+}
+
+func (s *Server) serveTarball(w http.ResponseWriter, path string) {
+	_, file, _ := strings.Cut(path, "/-/")
+	content, ok := s.fixtures.Tarballs[file]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+func (s *Server) servePackument(w http.ResponseWriter, r *http.Request, name string) {
+	doc, ok := s.fixtures.Packuments[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), abbreviatedMediaType) {
+		w.Header().Set("Content-Type", abbreviatedMediaType)
+		writeJSON(w, http.StatusOK, abbreviate(doc))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, doc)
+}
+
+// abbreviate reduces a packument to the corgi form npm clients request
+// with the install-v1 media type.
+func abbreviate(doc map[string]interface{}) map[string]interface{} {
+	out := map[string]interface{}{
+		"name":      doc["name"],
+		"dist-tags": doc["dist-tags"],
+	}
+	if times, ok := doc["time"].(map[string]interface{}); ok {
+		out["modified"] = times["modified"]
+	}
+
+	versions := map[string]interface{}{}
+	if fullVersions, ok := doc["versions"].(map[string]interface{}); ok {
+		for version, raw := range fullVersions {
+			full, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			abbreviated := map[string]interface{}{}
+			for _, key := range []string{
+				"name", "version", "dependencies", "optionalDependencies",
+				"peerDependencies", "bin", "engines", "dist", "deprecated",
+			} {
+				if v, ok := full[key]; ok {
+					abbreviated[key] = v
+				}
+			}
+
+			if scripts, ok := full["scripts"].(map[string]interface{}); ok {
+				for _, scriptType := range []string{"preinstall", "install", "postinstall"} {
+					if _, ok := scripts[scriptType]; ok {
+						abbreviated["hasInstallScript"] = true
+					}
+				}
+			}
+
+			versions[version] = abbreviated
+		}
+	}
+	out["versions"] = versions
+
+	return out
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
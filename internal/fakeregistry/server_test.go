@@ -0,0 +1,282 @@
+package fakeregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"scrapeNPM/internal/discovery"
+)
+
+// startBasic serves the basic fixtures and returns a client pointed at them
+// and the server's URL.
+func startBasic(t *testing.T) (*Server, *discovery.Client, string) {
+	t.Helper()
+
+	fixtures, err := LoadFixtures("testdata/basic")
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	server := New(fixtures)
+	ts := server.Start()
+	t.Cleanup(ts.Close)
+
+	client, err := discovery.NewClientWithConfig(ClientConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("NewClientWithConfig: %v", err)
+	}
+	return server, client, ts.URL
+}
+
+func TestGetPackage(t *testing.T) {
+	_, client, _ := startBasic(t)
+	ctx := context.Background()
+
+	for _, name := range []string{"left-pad", "postinstall-beacon", "@fixture/util"} {
+		doc, err := client.GetPackage(ctx, name)
+		if err != nil {
+			t.Fatalf("GetPackage(%s): %v", name, err)
+		}
+		if doc["name"] != name {
+			t.Errorf("GetPackage(%s) returned %v", name, doc["name"])
+		}
+	}
+
+	_, err := client.GetPackage(ctx, "no-such-package")
+	var httpErr *discovery.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetPackage of a missing package returned %v, want a 404", err)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	server, client, _ := startBasic(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		since   string
+		limit   int
+		ids     []string
+		lastSeq float64
+	}{
+		{"0", 1000, []string{"left-pad", "_design/app", "postinstall-beacon", "unpublished-thing", "@fixture/util"}, 5},
+		{"0", 2, []string{"left-pad", "_design/app"}, 2},
+		{"3", 1000, []string{"unpublished-thing", "@fixture/util"}, 5},
+		{"5", 1000, nil, 5},
+	}
+	for _, tt := range tests {
+		feed, err := client.GetChanges(ctx, tt.since, tt.limit)
+		if err != nil {
+			t.Fatalf("GetChanges(%s, %d): %v", tt.since, tt.limit, err)
+		}
+		results, _ := feed["results"].([]interface{})
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.(map[string]interface{})["id"].(string))
+		}
+		if !equal(ids, tt.ids) {
+			t.Errorf("GetChanges(%s, %d) ids = %v, want %v", tt.since, tt.limit, ids, tt.ids)
+		}
+		if feed["last_seq"] != tt.lastSeq {
+			t.Errorf("GetChanges(%s, %d) last_seq = %v, want %v", tt.since, tt.limit, feed["last_seq"], tt.lastSeq)
+		}
+	}
+
+	feed, _ := client.GetChanges(ctx, "3", 1)
+	change := feed["results"].([]interface{})[0].(map[string]interface{})
+	if change["deleted"] != true {
+		t.Errorf("change for an unpublished package = %v, want deleted", change)
+	}
+
+	server.AddPackument(map[string]interface{}{"name": "fresh-package"})
+	seq, err := client.GetUpdateSequence(ctx)
+	if err != nil {
+		t.Fatalf("GetUpdateSequence: %v", err)
+	}
+	if seq != "6" {
+		t.Errorf("update sequence after AddPackument = %s, want 6", seq)
+	}
+	feed, _ = client.GetChanges(ctx, "5", 10)
+	if results := feed["results"].([]interface{}); len(results) != 1 || results[0].(map[string]interface{})["id"] != "fresh-package" {
+		t.Errorf("changes after AddPackument = %v", results)
+	}
+}
+
+func TestAllDocs(t *testing.T) {
+	_, client, _ := startBasic(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		startKey   string
+		limit      int
+		descending bool
+		ids        []string
+		offset     float64
+	}{
+		{"", 10, false, []string{"@fixture/util", "left-pad", "postinstall-beacon"}, 0},
+		{"", 2, false, []string{"@fixture/util", "left-pad"}, 0},
+		{"left-pad", 10, false, []string{"left-pad", "postinstall-beacon"}, 1},
+		{"left-pad", 10, true, []string{"left-pad", "@fixture/util"}, 1},
+	}
+	for _, tt := range tests {
+		docs, err := client.GetAllDocs(ctx, tt.startKey, tt.limit, tt.descending)
+		if err != nil {
+			t.Fatalf("GetAllDocs(%q): %v", tt.startKey, err)
+		}
+		var ids []string
+		for _, r := range docs["rows"].([]interface{}) {
+			ids = append(ids, r.(map[string]interface{})["id"].(string))
+		}
+		if !equal(ids, tt.ids) {
+			t.Errorf("GetAllDocs(%q, %d, %v) ids = %v, want %v", tt.startKey, tt.limit, tt.descending, ids, tt.ids)
+		}
+		if docs["offset"] != tt.offset || docs["total_rows"] != float64(3) {
+			t.Errorf("GetAllDocs(%q, %d, %v) offset = %v, total_rows = %v", tt.startKey, tt.limit, tt.descending,
+				docs["offset"], docs["total_rows"])
+		}
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	_, client, _ := startBasic(t)
+	ctx := context.Background()
+
+	tests := map[string]int64{
+		"left-pad":        3811265,
+		"@fixture/util":   450,
+		"no-such-package": 0,
+	}
+	for name, want := range tests {
+		got, err := client.GetDownloadCount(ctx, name)
+		if err != nil {
+			t.Fatalf("GetDownloadCount(%s): %v", name, err)
+		}
+		if got != want {
+			t.Errorf("GetDownloadCount(%s) = %d, want %d", name, got, want)
+		}
+	}
+}
+
+func TestAbbreviatedPackument(t *testing.T) {
+	_, _, url := startBasic(t)
+
+	req, _ := http.NewRequest("GET", url+"/postinstall-beacon", nil)
+	req.Header.Set("Accept", abbreviatedMediaType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != abbreviatedMediaType {
+		t.Errorf("Content-Type = %q, want %q", ct, abbreviatedMediaType)
+	}
+
+	var doc map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if doc["modified"] != "2024-02-03T04:05:06.000Z" {
+		t.Errorf("modified = %v", doc["modified"])
+	}
+	if _, ok := doc["description"]; ok {
+		t.Error("abbreviated document kept the description")
+	}
+
+	versions := doc["versions"].(map[string]interface{})
+	clean := versions["2.0.0"].(map[string]interface{})
+	scripted := versions["2.0.1"].(map[string]interface{})
+	if _, ok := clean["hasInstallScript"]; ok {
+		t.Error("2.0.0 has no install script but is marked as having one")
+	}
+	if scripted["hasInstallScript"] != true {
+		t.Error("2.0.1 has install scripts but is not marked as having one")
+	}
+	for _, key := range []string{"scripts", "_npmUser"} {
+		if _, ok := scripted[key]; ok {
+			t.Errorf("abbreviated version kept %s", key)
+		}
+	}
+	if _, ok := scripted["dependencies"]; !ok {
+		t.Error("abbreviated version dropped dependencies")
+	}
+}
+
+func TestFaults(t *testing.T) {
+	server, client, _ := startBasic(t)
+	ctx := context.Background()
+
+	server.InjectStatus("/registry/_changes", http.StatusTooManyRequests, 2)
+	for i := 0; i < 2; i++ {
+		_, err := client.GetChanges(ctx, "0", 10)
+		var httpErr *discovery.HTTPError
+		if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("request %d returned %v, want a 429", i+1, err)
+		}
+	}
+	if _, err := client.GetChanges(ctx, "0", 10); err != nil {
+		t.Fatalf("request after the fault was used up: %v", err)
+	}
+
+	server.InjectStatus("/left-pad", http.StatusInternalServerError, 0)
+	for i := 0; i < 3; i++ {
+		if _, err := client.GetPackage(ctx, "left-pad"); err == nil {
+			t.Fatalf("request %d succeeded despite an unlimited fault", i+1)
+		}
+	}
+	if _, err := client.GetPackage(ctx, "postinstall-beacon"); err != nil {
+		t.Errorf("fault on /left-pad affected another path: %v", err)
+	}
+	server.ClearFaults()
+	if _, err := client.GetPackage(ctx, "left-pad"); err != nil {
+		t.Errorf("request after ClearFaults: %v", err)
+	}
+
+	server.InjectMalformedJSON("/left-pad", 1)
+	if _, err := client.GetPackage(ctx, "left-pad"); err == nil {
+		t.Error("malformed JSON was parsed")
+	}
+
+	server.InjectDelay("/left-pad", 200*time.Millisecond, 1)
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.GetPackage(timeoutCtx, "left-pad"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("delayed request returned %v, want a deadline error", err)
+	}
+}
+
+func TestTakeFault(t *testing.T) {
+	s := New(NewFixtures())
+	s.InjectStatus("/a", 500, 1)
+	s.InjectStatus("/", 503, 2)
+
+	want := []int{500, 503, 503, 0}
+	for i, status := range want {
+		f := s.takeFault("/a/b")
+		got := 0
+		if f != nil {
+			got = f.Status
+		}
+		if got != status {
+			t.Errorf("request %d got fault %d, want %d", i+1, got, status)
+		}
+	}
+	if len(s.faults) != 0 {
+		t.Errorf("%d faults left after they were used up", len(s.faults))
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,135 @@
+// Package health serves liveness and readiness probes built from named
+// checks.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"scrapeNPM/internal/logging"
+)
+
+type Config struct {
+	// ProgressWindow is how long the changes follower may go without a
+	// successful batch before the instance is reported not ready.
+	ProgressWindow time.Duration `yaml:"progress_window"`
+
+	// StallTimeout is how long a worker may go without starting a loop
+	// iteration or, while running a batch, without a heartbeat before the
+	// instance is reported not live. Idle workers only loop every 30 seconds
+	// and heartbeats come every third of the lease, so it must be well above
+	// both.
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+
+	// CheckTimeout bounds each probe.
+	CheckTimeout time.Duration `yaml:"check_timeout"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ProgressWindow: 15 * time.Minute,
+		StallTimeout:   10 * time.Minute,
+		CheckTimeout:   5 * time.Second,
+	}
+}
+
+// Check returns nil when the thing it checks is healthy.
+type Check func(ctx context.Context) error
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+type Checker struct {
+	timeout   time.Duration
+	liveness  []namedCheck
+	readiness []namedCheck
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// AddLiveness adds a check that fails /healthz. Liveness checks should only
+// fail when restarting the process is the fix.
+func (c *Checker) AddLiveness(name string, check Check) {
+	c.liveness = append(c.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadiness adds a check that fails /readyz.
+func (c *Checker) AddReadiness(name string, check Check) {
+	c.readiness = append(c.readiness, namedCheck{name: name, check: check})
+}
+
+func (c *Checker) Liveness() http.Handler {
+	return c.handler("liveness", &c.liveness)
+}
+
+func (c *Checker) Readiness() http.Handler {
+	return c.handler("readiness", &c.readiness)
+}
+
+type report struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (c *Checker) handler(kind string, checks *[]namedCheck) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), c.timeout)
+		defer cancel()
+
+		rep := report{Status: "ok", Checks: make(map[string]string)}
+		for _, nc := range *checks {
+			if err := nc.check(ctx); err != nil {
+				rep.Status = "fail"
+				rep.Checks[nc.name] = err.Error()
+				slog.Warn("Health check failed", "probe", kind, "check", nc.name, logging.Err(err))
+				continue
+			}
+			rep.Checks[nc.name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if rep.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(rep)
+	})
+}
+
+// ProgressCheck fails when last reports a time more than window ago.
+func ProgressCheck(last func() time.Time, window time.Duration) Check {
+	return func(ctx context.Context) error {
+		if age := time.Since(last()); age > window {
+			return fmt.Errorf("no progress for %s", age.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// StallCheck fails when stalled reports any worker idle for longer than
+// timeout.
+func StallCheck(stalled func(timeout time.Duration) []int, timeout time.Duration) Check {
+	return func(ctx context.Context) error {
+		ids := stalled(timeout)
+		if len(ids) == 0 {
+			return nil
+		}
+
+		sort.Ints(ids)
+		names := make([]string, len(ids))
+		for i, id := range ids {
+			names[i] = strconv.Itoa(id)
+		}
+		return fmt.Errorf("workers %s stalled for over %s", strings.Join(names, ", "), timeout)
+	}
+}
@@ -0,0 +1,273 @@
+// Package inspect builds a triage report for a single package from its
+// packument, or from what is stored about it.
+package inspect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// Report sources.
+const (
+	SourceRegistry = "registry"
+	SourceDatabase = "database"
+)
+
+// LifecycleScripts are the scripts npm runs on its own when a package is
+// installed, in the order it runs them. prepare and its hooks run for git
+// dependencies, and prepublish still runs on install with older clients.
+var LifecycleScripts = []string{
+	"preinstall", "install", "postinstall",
+	"prepublish", "preprepare", "prepare", "postprepare",
+}
+
+// Dependencies counts the dependencies the inspected version declares.
+type Dependencies struct {
+	Runtime  int `json:"runtime"`
+	Dev      int `json:"dev"`
+	Optional int `json:"optional"`
+	Peer     int `json:"peer"`
+}
+
+// ScriptChange is a lifecycle script added, changed or removed by a version,
+// compared with the version published before it. NewPublisher is set when
+// the version was the first one its publisher released, other than the
+// package's first version; a takeover looks like this.
+type ScriptChange struct {
+	Version      string                 `json:"version"`
+	Published    time.Time              `json:"published"`
+	Publisher    *models.Maintainer     `json:"publisher,omitempty"`
+	NewPublisher bool                   `json:"new_publisher,omitempty"`
+	Script       string                 `json:"script"`
+	Change       string                 `json:"change"`
+	Content      string                 `json:"content,omitempty"`
+	Findings     []models.ScriptFinding `json:"findings,omitempty"`
+}
+
+// Report is everything known about one version of a package. Publisher,
+// Dependencies and History are only known when the report was built from the
+// packument; Versions is zero otherwise.
+type Report struct {
+	Source       string                 `json:"source"`
+	Package      models.Package         `json:"package"`
+	Latest       string                 `json:"latest,omitempty"`
+	Published    time.Time              `json:"published"`
+	Publisher    *models.Maintainer     `json:"publisher,omitempty"`
+	Maintainers  []models.Maintainer    `json:"maintainers,omitempty"`
+	Versions     int                    `json:"versions,omitempty"`
+	Dependencies *Dependencies          `json:"dependencies,omitempty"`
+	Scripts      []models.PackageScript `json:"scripts"`
+	History      []ScriptChange         `json:"history,omitempty"`
+}
+
+// Findings returns how many findings the report's scripts have of each
+// severity.
+func (r *Report) Findings() map[string]int {
+	counts := make(map[string]int)
+	for _, s := range r.Scripts {
+		for _, f := range s.Findings {
+			counts[f.Severity]++
+		}
+	}
+	return counts
+}
+
+// ParseSpec splits name[@version], keeping the leading @ of a scoped name.
+func ParseSpec(spec string) (name, version string) {
+	if i := strings.LastIndex(spec, "@"); i > 0 {
+		return spec[:i], spec[i+1:]
+	}
+	return spec, ""
+}
+
+// Builder builds reports with one extractor and analyzer.
+type Builder struct {
+	extractor *processor.Extractor
+	analyzer  *analysis.Analyzer
+}
+
+func NewBuilder() *Builder {
+	return &Builder{
+		extractor: processor.NewExtractor(),
+		analyzer:  analysis.NewAnalyzer(),
+	}
+}
+
+// FromPackument builds a report for version of the package in raw. version
+// may be a dist-tag, and defaults to latest.
+func (b *Builder) FromPackument(name, version string, raw map[string]interface{}) (*Report, error) {
+	pkg, err := b.extractor.ExtractPackageData(name, raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract package data: %w", err)
+	}
+
+	versions, _ := raw["versions"].(map[string]interface{})
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%s has no published versions", name)
+	}
+
+	report := &Report{
+		Source:      SourceRegistry,
+		Latest:      pkg.Version,
+		Maintainers: b.extractor.ExtractMaintainers(raw),
+		Versions:    len(versions),
+	}
+
+	if version == "" {
+		version = pkg.Version
+	}
+	if tags, ok := raw["dist-tags"].(map[string]interface{}); ok {
+		if tagged, ok := tags[version].(string); ok {
+			version = tagged
+		}
+	}
+	data, ok := versions[version].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no version %s", name, version)
+	}
+	pkg.Version = version
+	report.Package = pkg
+
+	published := publishTimes(raw)
+	report.Published = published[version]
+	report.Publisher = publisher(data)
+	report.Dependencies = &Dependencies{
+		Runtime:  count(data["dependencies"]),
+		Dev:      count(data["devDependencies"]),
+		Optional: count(data["optionalDependencies"]),
+		Peer:     count(data["peerDependencies"]),
+	}
+
+	current := lifecycleScripts(data)
+	for _, scriptType := range LifecycleScripts {
+		if content, ok := current[scriptType]; ok {
+			report.Scripts = append(report.Scripts, b.analyze(scriptType, content))
+		}
+	}
+
+	report.History = b.history(versions, published)
+	return report, nil
+}
+
+// FromStored builds a report from a stored package, which only holds the
+// maintainers and install scripts of the version last fetched.
+func (b *Builder) FromStored(result models.PackageResult) *Report {
+	return &Report{
+		Source:      SourceDatabase,
+		Package:     result.Package,
+		Latest:      result.Package.Version,
+		Maintainers: result.Maintainers,
+		Scripts:     result.Scripts,
+	}
+}
+
+func (b *Builder) analyze(scriptType, content string) models.PackageScript {
+	script := models.PackageScript{ScriptType: scriptType, Content: content}
+	script.Findings = b.analyzer.Analyze(script)
+	return script
+}
+
+// history compares the lifecycle scripts of each version with the version
+// published before it, oldest first.
+func (b *Builder) history(versions map[string]interface{}, published map[string]time.Time) []ScriptChange {
+	var changes []ScriptChange
+	previous := map[string]string{}
+	publishers := map[string]bool{}
+	for i, version := range publishOrder(versions, published) {
+		data, _ := versions[version].(map[string]interface{})
+		current := lifecycleScripts(data)
+		by := publisher(data)
+		newPublisher := by != nil && i > 0 && !publishers[by.Name]
+		if by != nil {
+			publishers[by.Name] = true
+		}
+		for _, scriptType := range LifecycleScripts {
+			before, had := previous[scriptType]
+			after, has := current[scriptType]
+
+			change := ScriptChange{
+				Version:      version,
+				Published:    published[version],
+				Publisher:    by,
+				NewPublisher: newPublisher,
+				Script:       scriptType,
+			}
+			switch {
+			case has && !had:
+				change.Change = "added"
+			case has && after != before:
+				change.Change = "changed"
+			case had && !has:
+				change.Change = "removed"
+				change.Content = before
+				changes = append(changes, change)
+				continue
+			default:
+				continue
+			}
+			change.Content = after
+			change.Findings = b.analyze(scriptType, after).Findings
+			changes = append(changes, change)
+		}
+		previous = current
+	}
+	return changes
+}
+
+// publishOrder returns version names by publish time. Versions missing from
+// the time map, which old packuments can have, sort first by name.
+func publishOrder(versions map[string]interface{}, published map[string]time.Time) []string {
+	order := make([]string, 0, len(versions))
+	for version := range versions {
+		order = append(order, version)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		ti, tj := published[order[i]], published[order[j]]
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return order[i] < order[j]
+	})
+	return order
+}
+
+func publishTimes(raw map[string]interface{}) map[string]time.Time {
+	times := make(map[string]time.Time)
+	entries, _ := raw["time"].(map[string]interface{})
+	for version, value := range entries {
+		s, _ := value.(string)
+		if t, err := time.Parse(time.RFC3339, s); err == nil {
+			times[version] = t
+		}
+	}
+	return times
+}
+
+func lifecycleScripts(data map[string]interface{}) map[string]string {
+	scripts := make(map[string]string)
+	all, _ := data["scripts"].(map[string]interface{})
+	for _, scriptType := range LifecycleScripts {
+		if content, ok := all[scriptType].(string); ok && content != "" {
+			scripts[scriptType] = content
+		}
+	}
+	return scripts
+}
+
+func publisher(data map[string]interface{}) *models.Maintainer {
+	if m, ok := processor.ParseMaintainer(data["_npmUser"]); ok {
+		return &m
+	}
+	return nil
+}
+
+func count(value interface{}) int {
+	deps, _ := value.(map[string]interface{})
+	return len(deps)
+}
@@ -0,0 +1,67 @@
+package jobs
+
+import "fmt"
+
+const TypeFetchPackage = "fetch_package"
+
+// FetchPackagePayload asks for a package's metadata and scripts to be
+// fetched from the registry and stored.
+type FetchPackagePayload struct {
+	PackageName string `json:"package_name"`
+}
+
+func (p *FetchPackagePayload) Validate() error {
+	if p.PackageName == "" {
+		return fmt.Errorf("package_name is required")
+	}
+	return nil
+}
+
+const TypeRefreshDownloads = "refresh_downloads"
+
+// RefreshDownloadsPayload asks for the download counts of the Top most
+// downloaded stored packages to be refreshed.
+type RefreshDownloadsPayload struct {
+	Top int `json:"top"`
+}
+
+func (p *RefreshDownloadsPayload) Validate() error {
+	if p.Top < 1 || p.Top > 1000000 {
+		return fmt.Errorf("top must be between 1 and 1000000")
+	}
+	return nil
+}
+
+const TypeAnalyzeScripts = "analyze_scripts"
+
+// AnalyzeScriptsPayload asks for every stored install script to be
+// re-analyzed with the current rules.
+type AnalyzeScriptsPayload struct{}
+
+func (p *AnalyzeScriptsPayload) Validate() error {
+	return nil
+}
+
+const TypePruneJobs = "prune_jobs"
+
+// PruneJobsPayload is the job queue retention policy. Finished jobs older than
+// the given number of days are moved to the archive, or deleted when Archive
+// is false; cancelled jobs follow FailedDays. Zero days keeps jobs of that
+// status. Archive partitions entirely older than ArchiveDays are dropped;
+// zero keeps the archive forever.
+type PruneJobsPayload struct {
+	CompletedDays int  `json:"completed_days"`
+	FailedDays    int  `json:"failed_days"`
+	Archive       bool `json:"archive"`
+	ArchiveDays   int  `json:"archive_days"`
+}
+
+func (p *PruneJobsPayload) Validate() error {
+	if p.CompletedDays < 0 || p.FailedDays < 0 || p.ArchiveDays < 0 {
+		return fmt.Errorf("retention days must not be negative")
+	}
+	if p.CompletedDays == 0 && p.FailedDays == 0 && p.ArchiveDays == 0 {
+		return fmt.Errorf("at least one retention period is required")
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+// Package jobs defines job types, their payload schemas and the registry of
+// handlers that process them.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"scrapeNPM/internal/models"
+)
+
+// ErrInvalidPayload marks jobs that can never succeed because their payload
+// is missing or malformed.
+var ErrInvalidPayload = errors.New("invalid job payload")
+
+// ErrUnknownJobType is returned for jobs with no registered handler.
+var ErrUnknownJobType = errors.New("unknown job type")
+
+// ErrNoSlot is returned by Run when every concurrency slot for the job's
+// type is taken. The job has not run and should go back to the queue.
+var ErrNoSlot = errors.New("no free concurrency slot")
+
+// Payload is the typed form of a job's JSON payload. Validate is called at
+// enqueue time and again before processing.
+type Payload interface {
+	Validate() error
+}
+
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   2 * time.Minute,
+		MaxDelay:    time.Hour,
+	}
+}
+
+// Backoff returns how long to wait before retrying after the given attempt
+// (1-based) failed, doubling from BaseDelay up to MaxDelay.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+// Spec describes how jobs of one type are validated and run.
+type Spec struct {
+	Type string
+
+	// NewPayload returns a pointer to an empty payload struct for this type.
+	NewPayload func() Payload
+
+	// Concurrency caps how many jobs of this type run at once in this
+	// process. Zero means no limit beyond the worker count.
+	Concurrency int
+
+	// Timeout bounds a single attempt. Zero means no timeout.
+	Timeout time.Duration
+
+	Retry RetryPolicy
+}
+
+// Result carries what a handler produced for the worker to persist.
+type Result struct {
+	Packages []models.PackageResult
+}
+
+type Handler interface {
+	Spec() Spec
+	Handle(ctx context.Context, job *models.Job, payload Payload) (*Result, error)
+}
+
+type registration struct {
+	handler Handler
+	spec    Spec
+	slots   chan struct{}
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	handlers map[string]*registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: make(map[string]*registration)}
+}
+
+func (r *Registry) Register(handler Handler) error {
+	spec := handler.Spec()
+	if spec.Type == "" {
+		return fmt.Errorf("handler has no job type")
+	}
+	if spec.NewPayload == nil {
+		return fmt.Errorf("handler for %s declares no payload", spec.Type)
+	}
+	if spec.Retry.MaxAttempts < 1 {
+		spec.Retry = DefaultRetryPolicy()
+	}
+	if spec.Retry.MaxAttempts > 1 && spec.Retry.BaseDelay <= 0 {
+		return fmt.Errorf("handler for %s retries with no base delay", spec.Type)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.handlers[spec.Type]; exists {
+		return fmt.Errorf("handler for %s already registered", spec.Type)
+	}
+
+	reg := &registration{handler: handler, spec: spec}
+	if spec.Concurrency > 0 {
+		reg.slots = make(chan struct{}, spec.Concurrency)
+	}
+	r.handlers[spec.Type] = reg
+
+	return nil
+}
+
+func (r *Registry) MustRegister(handler Handler) {
+	if err := r.Register(handler); err != nil {
+		panic(err)
+	}
+}
+
+func (r *Registry) lookup(jobType string) (*registration, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reg, ok := r.handlers[jobType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownJobType, jobType)
+	}
+	return reg, nil
+}
+
+func (r *Registry) Spec(jobType string) (Spec, error) {
+	reg, err := r.lookup(jobType)
+	if err != nil {
+		return Spec{}, err
+	}
+	return reg.spec, nil
+}
+
+// Types returns every registered job type in name order.
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.handlers))
+	for t := range r.handlers {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Claimable narrows jobTypes, or every registered type when jobTypes is
+// empty, to the types with a free concurrency slot, so that workers do not
+// claim jobs they cannot start. When every remaining type is capped, limit is
+// lowered to the free slots. A zero limit means nothing can be claimed; nil
+// types with a non-zero limit means any type.
+func (r *Registry) Claimable(jobTypes []string, limit int) ([]string, int) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	candidates := jobTypes
+	if len(candidates) == 0 {
+		candidates = make([]string, 0, len(r.handlers))
+		for t := range r.handlers {
+			candidates = append(candidates, t)
+		}
+		sort.Strings(candidates)
+	}
+
+	var types []string
+	free, capped := 0, true
+	for _, t := range candidates {
+		reg, ok := r.handlers[t]
+		if !ok || reg.slots == nil {
+			types = append(types, t)
+			capped = false
+			continue
+		}
+		if n := cap(reg.slots) - len(reg.slots); n > 0 {
+			types = append(types, t)
+			free += n
+		}
+	}
+
+	if len(types) == 0 {
+		return nil, 0
+	}
+	if capped && free < limit {
+		limit = free
+	}
+	if len(jobTypes) == 0 && len(types) == len(candidates) {
+		return nil, limit
+	}
+	return types, limit
+}
+
+// Prepare validates a job about to be enqueued and fills in defaults from
+// its spec.
+func (r *Registry) Prepare(job *models.Job) error {
+	reg, err := r.lookup(job.Type)
+	if err != nil {
+		return err
+	}
+
+	if _, err := decode(reg.spec, job.Payload); err != nil {
+		return err
+	}
+
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = reg.spec.Retry.MaxAttempts
+	}
+
+	return nil
+}
+
+// Run decodes the job's payload and runs its handler, honouring the
+// handler's concurrency limit and timeout. It returns ErrNoSlot without
+// waiting when the handler is already running at its limit.
+func (r *Registry) Run(ctx context.Context, job *models.Job) (*Result, error) {
+	reg, err := r.lookup(job.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := decode(reg.spec, job.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if reg.slots != nil {
+		select {
+		case reg.slots <- struct{}{}:
+			defer func() { <-reg.slots }()
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrNoSlot, job.Type)
+		}
+	}
+
+	if reg.spec.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, reg.spec.Timeout)
+		defer cancel()
+	}
+
+	return reg.handler.Handle(ctx, job, payload)
+}
+
+// RetryDelay returns how long to wait before retrying a failed attempt, or
+// zero if the job should not be retried.
+func (r *Registry) RetryDelay(job *models.Job, err error) time.Duration {
+	if errors.Is(err, ErrInvalidPayload) || errors.Is(err, ErrUnknownJobType) {
+		return 0
+	}
+
+	reg, lookupErr := r.lookup(job.Type)
+	if lookupErr != nil {
+		return DefaultRetryPolicy().Backoff(job.Attempts)
+	}
+	return reg.spec.Retry.Backoff(job.Attempts)
+}
+
+// NewJob builds a job of the given type from a typed payload.
+func NewJob(jobType string, payload Payload, priority int) (models.Job, error) {
+	if err := payload.Validate(); err != nil {
+		return models.Job{}, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	var raw map[string]interface{}
+	if err := json.Unmarshal(encoded, &raw); err != nil {
+		return models.Job{}, fmt.Errorf("failed to unmarshal payload: %w", err)
+	}
+
+	return models.Job{
+		Type:     jobType,
+		Status:   "pending",
+		Priority: priority,
+		Payload:  raw,
+	}, nil
+}
+
+func decode(spec Spec, raw map[string]interface{}) (Payload, error) {
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	payload := spec.NewPayload()
+	if err := json.Unmarshal(encoded, payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	if err := payload.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPayload, err)
+	}
+
+	return payload, nil
+}
@@ -0,0 +1,110 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"scrapeNPM/internal/models"
+)
+
+type testPayload struct{}
+
+func (p *testPayload) Validate() error { return nil }
+
+// blockingHandler holds its slot until release is closed.
+type blockingHandler struct {
+	spec    Spec
+	started chan struct{}
+	release chan struct{}
+}
+
+func (h *blockingHandler) Spec() Spec { return h.spec }
+
+func (h *blockingHandler) Handle(ctx context.Context, job *models.Job, payload Payload) (*Result, error) {
+	h.started <- struct{}{}
+	<-h.release
+	return &Result{}, nil
+}
+
+func newBlockingHandler(jobType string, concurrency int) *blockingHandler {
+	return &blockingHandler{
+		spec: Spec{
+			Type:        jobType,
+			NewPayload:  func() Payload { return &testPayload{} },
+			Concurrency: concurrency,
+		},
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+}
+
+func TestRegisterRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry RetryPolicy
+		ok    bool
+	}{
+		{"default", RetryPolicy{}, true},
+		{"backoff", RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second}, true},
+		{"single attempt", RetryPolicy{MaxAttempts: 1}, true},
+		{"retries without delay", RetryPolicy{MaxAttempts: 3}, false},
+		{"negative delay", RetryPolicy{MaxAttempts: 2, BaseDelay: -time.Second}, false},
+	}
+	for _, tt := range tests {
+		h := newBlockingHandler("test", 0)
+		h.spec.Retry = tt.retry
+		err := NewRegistry().Register(h)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: Register returned %v", tt.name, err)
+		}
+	}
+}
+
+func TestClaimable(t *testing.T) {
+	r := NewRegistry()
+	capped := newBlockingHandler("capped", 2)
+	other := newBlockingHandler("other", 1)
+	r.MustRegister(capped)
+	r.MustRegister(other)
+	r.MustRegister(newBlockingHandler("free", 0))
+	defer close(capped.release)
+	defer close(other.release)
+
+	check := func(name string, jobTypes []string, limit int, wantTypes []string, wantLimit int) {
+		t.Helper()
+		types, got := r.Claimable(jobTypes, limit)
+		if !equal(types, wantTypes) || got != wantLimit {
+			t.Errorf("%s: Claimable(%v, %d) = %v, %d, want %v, %d", name, jobTypes, limit, types, got, wantTypes, wantLimit)
+		}
+	}
+
+	check("idle, any type", nil, 10, nil, 10)
+	check("idle, capped types", []string{"capped", "other"}, 10, []string{"capped", "other"}, 3)
+	check("unregistered type", []string{"capped", "unknown"}, 10, []string{"capped", "unknown"}, 10)
+
+	job := &models.Job{Type: "other", Payload: map[string]interface{}{}}
+	go r.Run(context.Background(), job)
+	<-other.started
+
+	check("saturated, any type", nil, 10, []string{"capped", "free"}, 10)
+	check("saturated, capped types", []string{"capped", "other"}, 10, []string{"capped"}, 2)
+	check("saturated only", []string{"other"}, 10, nil, 0)
+
+	if _, err := r.Run(context.Background(), job); !errors.Is(err, ErrNoSlot) {
+		t.Errorf("Run on a saturated type returned %v, want ErrNoSlot", err)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,86 @@
+// Package logging configures the process-wide slog logger and carries
+// per-request loggers through contexts, so that a job's fields appear on
+// every line logged while it runs.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Field names shared by every component, so that log pipelines can index
+// them.
+const (
+	KeyWorkerID       = "worker_id"
+	KeyJobID          = "job_id"
+	KeyJobType        = "job_type"
+	KeyPackage        = "package"
+	KeyAttempt        = "attempt"
+	KeyDuration       = "duration"
+	KeyUpstreamStatus = "upstream_status"
+	KeyErrorClass     = "error_class"
+	KeyPool           = "pool"
+	KeySchedule       = "schedule"
+	KeyError          = "error"
+)
+
+type Config struct {
+	// Level is debug, info, warn or error.
+	Level string `yaml:"level"`
+
+	// Format is text or json.
+	Format string `yaml:"format"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Level:  "info",
+		Format: "text",
+	}
+}
+
+// New returns a logger writing to w in the configured format and level.
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.Level, err)
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q: want text or json", cfg.Format)
+	}
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// With returns a context whose logger adds args to every record.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithLogger(ctx, FromContext(ctx).With(args...))
+}
+
+// Err formats an error as a log attribute.
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
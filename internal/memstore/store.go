@@ -0,0 +1,876 @@
+// Package memstore is an in-memory implementation of the job queue and
+// package storage interfaces, for running the pipeline without Postgres.
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/metrics"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+	"scrapeNPM/internal/stats"
+)
+
+type scriptKey struct {
+	packageID  uuid.UUID
+	scriptType string
+}
+
+type Store struct {
+	mu          sync.Mutex
+	jobs        map[uuid.UUID]*models.Job
+	packages    map[string]*models.Package
+	scripts     map[scriptKey]*models.PackageScript
+	maintainers map[uuid.UUID]map[string]models.Maintainer
+	versions    map[uuid.UUID]map[string]models.PackageVersion
+	events      []models.PackageEvent
+	progress    map[string]progress
+	attempts    []models.JobAttempt
+	archive     []models.Job
+	pools       []processor.PoolConfig
+	paused      map[string]bool
+	lease       time.Duration
+	wakes       *processor.WakeSet
+	handlers    *jobs.Registry
+	now         func() time.Time
+}
+
+type progress struct {
+	lastSequence   string
+	totalProcessed int64
+}
+
+// New returns an empty store. When handlers is non-nil enqueued jobs are
+// validated against it, as the Postgres queue does.
+func New(handlers *jobs.Registry) *Store {
+	return &Store{
+		handlers:    handlers,
+		jobs:        make(map[uuid.UUID]*models.Job),
+		packages:    make(map[string]*models.Package),
+		scripts:     make(map[scriptKey]*models.PackageScript),
+		maintainers: make(map[uuid.UUID]map[string]models.Maintainer),
+		versions:    make(map[uuid.UUID]map[string]models.PackageVersion),
+		progress:    make(map[string]progress),
+		paused:      make(map[string]bool),
+		lease:       processor.DefaultLeaseDuration,
+		wakes:       processor.NewWakeSet(),
+		now:         time.Now,
+	}
+}
+
+func (s *Store) EnqueueJob(ctx context.Context, job models.Job) (uuid.UUID, error) {
+	if s.handlers != nil {
+		if err := s.handlers.Prepare(&job); err != nil {
+			return uuid.Nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	job.ID = uuid.New()
+	job.Status = "pending"
+	job.CreatedAt = now
+	job.NextAttemptAfter = now
+	if job.MaxAttempts == 0 {
+		job.MaxAttempts = 3
+	}
+
+	s.jobs[job.ID] = &job
+	s.wakes.Notify(job.Type)
+	return job.ID, nil
+}
+
+// Wait returns a channel closed the next time a job of one of jobTypes
+// becomes claimable.
+func (s *Store) Wait(jobTypes []string) <-chan struct{} {
+	return s.wakes.Wait(jobTypes)
+}
+
+func (s *Store) GetScrapeProgress(ctx context.Context, id string) (string, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.progress[id]
+	if !ok {
+		return "0", 0, nil
+	}
+	return p.lastSequence, p.totalProcessed, nil
+}
+
+func (s *Store) UpdateScrapeProgress(ctx context.Context, id string, lastSequence string, totalProcessed int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.progress[id] = progress{lastSequence: lastSequence, totalProcessed: totalProcessed}
+	return nil
+}
+
+func (s *Store) ClaimJobs(ctx context.Context, workerID string, jobTypes []string, limit int) ([]*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	types := make(map[string]bool, len(jobTypes))
+	for _, t := range jobTypes {
+		types[t] = true
+	}
+
+	now := s.now()
+	var candidates []*models.Job
+	for _, job := range s.jobs {
+		if (len(types) > 0 && !types[job.Type]) || s.paused[job.Type] {
+			continue
+		}
+		if job.Status == "pending" && !job.NextAttemptAfter.After(now) {
+			candidates = append(candidates, job)
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Priority != candidates[j].Priority {
+			return candidates[i].Priority < candidates[j].Priority
+		}
+		return candidates[i].CreatedAt.Before(candidates[j].CreatedAt)
+	})
+
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+
+	claimed := make([]*models.Job, 0, len(candidates))
+	leaseExpiresAt := now.Add(s.lease)
+	for _, job := range candidates {
+		job.Status = "processing"
+		job.StartedAt = &now
+		job.WorkerID = workerID
+		job.Attempts++
+		job.LeaseExpiresAt = &leaseExpiresAt
+
+		c := *job
+		claimed = append(claimed, &c)
+	}
+
+	return claimed, nil
+}
+
+func (s *Store) CompleteJobs(ctx context.Context, attempts []models.JobAttempt) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var completed []uuid.UUID
+	for _, attempt := range attempts {
+		job, ok := s.jobs[attempt.JobID]
+		if !ok {
+			return nil, fmt.Errorf("job %s not found", attempt.JobID)
+		}
+		if !heldBy(job, attempt.WorkerID) {
+			continue
+		}
+
+		s.recordAttempt(attempt)
+		finishedAt := attempt.FinishedAt
+		job.Status = "completed"
+		job.CompletedAt = &finishedAt
+		job.LeaseExpiresAt = nil
+		completed = append(completed, job.ID)
+	}
+
+	return completed, nil
+}
+
+func (s *Store) FailJob(ctx context.Context, attempt models.JobAttempt, retryAfter time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[attempt.JobID]
+	if !ok {
+		return false, fmt.Errorf("job %s not found", attempt.JobID)
+	}
+	if !heldBy(job, attempt.WorkerID) {
+		return false, nil
+	}
+
+	s.recordAttempt(attempt)
+	job.ErrorMessage = attempt.ErrorMessage
+	job.ErrorClass = attempt.ErrorClass
+	job.LeaseExpiresAt = nil
+	if retryAfter <= 0 || job.Attempts >= job.MaxAttempts {
+		now := s.now()
+		job.Status = "failed"
+		job.CompletedAt = &now
+		return true, nil
+	}
+
+	job.Status = "pending"
+	job.NextAttemptAfter = s.now().Add(retryAfter)
+	return true, nil
+}
+
+func (s *Store) ReleaseJobs(ctx context.Context, workerID string, jobIDs []uuid.UUID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	released := 0
+	for _, id := range jobIDs {
+		job, ok := s.jobs[id]
+		if !ok || !heldBy(job, workerID) {
+			continue
+		}
+		job.Status = "pending"
+		if job.Attempts > 0 {
+			job.Attempts--
+		}
+		job.WorkerID = ""
+		job.StartedAt = nil
+		job.LeaseExpiresAt = nil
+		job.NextAttemptAfter = s.now()
+		released++
+		s.wakes.Notify(job.Type)
+	}
+
+	return released, nil
+}
+
+// heldBy reports whether workerID is still processing job.
+func heldBy(job *models.Job, workerID string) bool {
+	return job.Status == "processing" && job.WorkerID == workerID
+}
+
+// SetLeaseDuration changes the lease given to claimed jobs, and so how often
+// workers heartbeat.
+func (s *Store) SetLeaseDuration(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lease = d
+}
+
+func (s *Store) LeaseDuration() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lease
+}
+
+func (s *Store) ExtendLeases(ctx context.Context, workerID string, jobIDs []uuid.UUID) ([]uuid.UUID, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	leaseExpiresAt := s.now().Add(s.lease)
+	var held []uuid.UUID
+	for _, id := range jobIDs {
+		job, ok := s.jobs[id]
+		if !ok || !heldBy(job, workerID) {
+			continue
+		}
+		job.LeaseExpiresAt = &leaseExpiresAt
+		held = append(held, id)
+	}
+
+	return held, nil
+}
+
+func (s *Store) ReapExpiredJobs(ctx context.Context) (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	var requeued, failed int
+	for _, job := range s.jobs {
+		if job.Status != "processing" || job.LeaseExpiresAt == nil || !job.LeaseExpiresAt.Before(now) {
+			continue
+		}
+
+		job.ErrorMessage = "lease expired while processing on " + job.WorkerID
+		job.ErrorClass = processor.ErrorClassLeaseExpired
+		job.LeaseExpiresAt = nil
+		startedAt := now
+		if job.StartedAt != nil {
+			startedAt = *job.StartedAt
+		}
+		s.recordAttempt(models.JobAttempt{
+			JobID:        job.ID,
+			Attempt:      job.Attempts,
+			WorkerID:     job.WorkerID,
+			StartedAt:    startedAt,
+			FinishedAt:   now,
+			Duration:     now.Sub(startedAt),
+			Outcome:      "lease_expired",
+			ErrorClass:   job.ErrorClass,
+			ErrorMessage: job.ErrorMessage,
+		})
+		if job.Attempts >= job.MaxAttempts {
+			finishedAt := now
+			job.Status = "failed"
+			job.CompletedAt = &finishedAt
+			failed++
+			continue
+		}
+
+		job.Status = "pending"
+		job.NextAttemptAfter = now
+		requeued++
+		s.wakes.Notify(job.Type)
+	}
+
+	return requeued, failed, nil
+}
+
+func (s *Store) StoreResults(ctx context.Context, results []models.PackageResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	for i := range results {
+		pkg := results[i].Package
+		existing, stored := s.packages[pkg.Name]
+		if stored {
+			pkg.ID = existing.ID
+		} else {
+			pkg.ID = uuid.New()
+		}
+		pkg.LastUpdated = now
+		s.packages[pkg.Name] = &pkg
+		results[i].Package.ID = pkg.ID
+
+		for j := range results[i].Scripts {
+			script := results[i].Scripts[j]
+			script.PackageID = pkg.ID
+			results[i].Scripts[j].PackageID = pkg.ID
+
+			key := scriptKey{packageID: pkg.ID, scriptType: script.ScriptType}
+			if existing, ok := s.scripts[key]; ok {
+				countNewFindings(existing.Findings, script.Findings)
+				existing.Content = script.Content
+				existing.Findings = withScriptID(script.Findings, existing.ID)
+				existing.UpdatedAt = now
+				results[i].Scripts[j].ID = existing.ID
+				continue
+			}
+
+			script.ID = uuid.New()
+			countNewFindings(nil, script.Findings)
+			script.Findings = withScriptID(script.Findings, script.ID)
+			script.CreatedAt = now
+			script.UpdatedAt = now
+			s.scripts[key] = &script
+			results[i].Scripts[j].ID = script.ID
+		}
+
+		results[i].Events = nil
+		s.storeMaintainers(&results[i], stored, now)
+		if len(results[i].Versions) > 0 {
+			s.storeVersions(&results[i], now)
+		}
+	}
+
+	return nil
+}
+
+// storeMaintainers replaces the package's maintainers, recording additions
+// if the package was stored before and removals, including of the last one.
+func (s *Store) storeMaintainers(result *models.PackageResult, stored bool, now time.Time) {
+	pkgID := result.Package.ID
+	before := s.maintainers[pkgID]
+
+	current := make(map[string]models.Maintainer, len(result.Maintainers))
+	for _, m := range result.Maintainers {
+		current[m.Name] = m
+		if _, ok := before[m.Name]; stored && !ok {
+			s.recordEvent(result, models.PackageEvent{Type: models.EventMaintainerAdded, Account: m.Name}, now)
+		}
+	}
+	for _, name := range sortedKeys(before) {
+		if _, ok := current[name]; !ok {
+			s.recordEvent(result, models.PackageEvent{Type: models.EventMaintainerRemoved, Account: name}, now)
+		}
+	}
+	s.maintainers[pkgID] = current
+}
+
+// storeVersions adds the versions not stored yet, recording the first
+// version by each account that had not published the package before, if
+// any versions were stored before.
+func (s *Store) storeVersions(result *models.PackageResult, now time.Time) {
+	pkgID := result.Package.ID
+	stored, seen := s.versions[pkgID]
+	if !seen {
+		stored = make(map[string]models.PackageVersion)
+		s.versions[pkgID] = stored
+	}
+
+	publishers := make(map[string]bool)
+	for _, v := range stored {
+		publishers[v.Publisher.Name] = true
+	}
+
+	added := make([]models.PackageVersion, 0, len(result.Versions))
+	for _, v := range result.Versions {
+		if _, ok := stored[v.Version]; !ok {
+			added = append(added, v)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		if !added[i].PublishedAt.Equal(added[j].PublishedAt) {
+			return added[i].PublishedAt.Before(added[j].PublishedAt)
+		}
+		return added[i].Version < added[j].Version
+	})
+
+	for _, v := range added {
+		stored[v.Version] = v
+		if !seen || v.Publisher.Name == "" || publishers[v.Publisher.Name] {
+			continue
+		}
+		publishers[v.Publisher.Name] = true
+		s.recordEvent(result, models.PackageEvent{
+			Type:              models.EventNewPublisher,
+			Account:           v.Publisher.Name,
+			Version:           v.Version,
+			HasInstallScripts: v.HasInstallScripts,
+		}, now)
+	}
+}
+
+func (s *Store) recordEvent(result *models.PackageResult, event models.PackageEvent, now time.Time) {
+	event.ID = int64(len(s.events) + 1)
+	event.PackageID = result.Package.ID
+	event.PackageName = result.Package.Name
+	event.CreatedAt = now
+	s.events = append(s.events, event)
+	result.Events = append(result.Events, event)
+}
+
+func sortedKeys(m map[string]models.Maintainer) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countNewFindings adds the findings whose rule was not among before to the
+// findings metric, as the Postgres store does.
+func countNewFindings(before, after []models.ScriptFinding) {
+	seen := make(map[string]bool, len(before))
+	for _, f := range before {
+		seen[f.Rule] = true
+	}
+	for _, f := range after {
+		if !seen[f.Rule] {
+			metrics.ScriptFindings.WithLabelValues(f.Rule, f.Severity).Inc()
+		}
+	}
+}
+
+func withScriptID(findings []models.ScriptFinding, id uuid.UUID) []models.ScriptFinding {
+	out := make([]models.ScriptFinding, len(findings))
+	for i, f := range findings {
+		f.ScriptID = id
+		out[i] = f
+	}
+	return out
+}
+
+func (s *Store) TopPackages(ctx context.Context, limit int) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pkgs := make([]*models.Package, 0, len(s.packages))
+	for _, pkg := range s.packages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].Downloads > pkgs[j].Downloads
+	})
+
+	var names []string
+	for i := 0; i < len(pkgs) && i < limit; i++ {
+		names = append(names, pkgs[i].Name)
+	}
+	return names, nil
+}
+
+func (s *Store) UpdateDownloads(ctx context.Context, packages []models.Package) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, update := range packages {
+		if pkg, ok := s.packages[update.Name]; ok {
+			pkg.Downloads = update.Downloads
+			pkg.PopularityScore = update.PopularityScore
+			pkg.LastUpdated = s.now()
+		}
+	}
+	return nil
+}
+
+func (s *Store) ListScripts(ctx context.Context, after uuid.UUID, limit int) ([]models.PackageScript, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scripts []models.PackageScript
+	for _, script := range s.scripts {
+		if script.ID.String() > after.String() {
+			scripts = append(scripts, *script)
+		}
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].ID.String() < scripts[j].ID.String()
+	})
+
+	if len(scripts) > limit {
+		scripts = scripts[:limit]
+	}
+	return scripts, nil
+}
+
+func (s *Store) ReplaceFindings(ctx context.Context, scripts []models.PackageScript) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, update := range scripts {
+		key := scriptKey{packageID: update.PackageID, scriptType: update.ScriptType}
+		if script, ok := s.scripts[key]; ok {
+			countNewFindings(script.Findings, update.Findings)
+			script.Findings = withScriptID(update.Findings, script.ID)
+		}
+	}
+	return nil
+}
+
+func (s *Store) PackageSignals(ctx context.Context, names []string) (map[string]discovery.PackageSignal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	signals := make(map[string]discovery.PackageSignal, len(names))
+	for _, name := range names {
+		pkg, ok := s.packages[name]
+		if !ok {
+			continue
+		}
+
+		signal := discovery.PackageSignal{
+			Known:      true,
+			Downloads:  pkg.Downloads,
+			HasScripts: s.versions[pkg.ID][pkg.Version].HasInstallScripts,
+		}
+		for key, script := range s.scripts {
+			if key.packageID != pkg.ID {
+				continue
+			}
+			for _, f := range script.Findings {
+				if f.Severity == analysis.SeverityHigh {
+					signal.HighFindings = true
+				}
+			}
+		}
+		signals[name] = signal
+	}
+	return signals, nil
+}
+
+func (s *Store) PopularPackages(ctx context.Context, limit int) ([]string, error) {
+	return s.TopPackages(ctx, limit)
+}
+
+// ArchiveMonths returns nothing: the in-memory archive is not partitioned.
+func (s *Store) ArchiveMonths(ctx context.Context, status string, olderThan time.Duration) ([]time.Time, error) {
+	return nil, nil
+}
+
+func (s *Store) ArchivePartitions(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (s *Store) EnsureArchivePartition(ctx context.Context, month time.Time) error {
+	return nil
+}
+
+func (s *Store) PruneJobs(ctx context.Context, status string, olderThan time.Duration, limit int, archive bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-olderThan)
+	var pruned int
+	for id, job := range s.jobs {
+		if pruned >= limit {
+			break
+		}
+		if job.Status != status || job.CompletedAt == nil || !job.CompletedAt.Before(cutoff) {
+			continue
+		}
+		if archive {
+			s.archive = append(s.archive, *job)
+		}
+		delete(s.jobs, id)
+		pruned++
+	}
+	return pruned, nil
+}
+
+func (s *Store) DropArchivePartitions(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := s.now().Add(-olderThan)
+	kept := s.archive[:0]
+	for _, job := range s.archive {
+		if job.CompletedAt.After(cutoff) {
+			kept = append(kept, job)
+		}
+	}
+	s.archive = kept
+	return nil, nil
+}
+
+// Events returns every ownership change recorded by StoreResults.
+func (s *Store) Events() []models.PackageEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.PackageEvent(nil), s.events...)
+}
+
+// Archived returns the jobs moved out of the queue by PruneJobs.
+func (s *Store) Archived() []models.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.Job(nil), s.archive...)
+}
+
+func (s *Store) recordAttempt(attempt models.JobAttempt) {
+	attempt.ID = int64(len(s.attempts) + 1)
+	s.attempts = append(s.attempts, attempt)
+}
+
+// Attempts returns the recorded attempt history for a job.
+func (s *Store) Attempts(jobID uuid.UUID) []models.JobAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var attempts []models.JobAttempt
+	for _, attempt := range s.attempts {
+		if attempt.JobID == jobID {
+			attempts = append(attempts, attempt)
+		}
+	}
+	return attempts
+}
+
+// GetQueueStats mirrors the Postgres queue stats over the jobs and attempts
+// held in memory.
+func (s *Store) GetQueueStats(ctx context.Context, window time.Duration) (*discovery.QueueStats, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	since := now.Add(-window)
+	minutes := window.Minutes()
+
+	byType := make(map[string]*discovery.JobTypeStats)
+	typeStats := func(jobType string) *discovery.JobTypeStats {
+		t, ok := byType[jobType]
+		if !ok {
+			t = &discovery.JobTypeStats{JobType: jobType}
+			byType[jobType] = t
+		}
+		return t
+	}
+
+	for _, job := range s.jobs {
+		t := typeStats(job.Type)
+		switch job.Status {
+		case "pending":
+			t.Pending++
+			if age := now.Sub(job.CreatedAt).Seconds(); age > t.OldestPendingSeconds {
+				t.OldestPendingSeconds = age
+			}
+		case "paused":
+			t.Paused++
+		case "processing":
+			t.Processing++
+		case "completed":
+			t.Completed++
+		case "failed":
+			t.Failed++
+		case "cancelled":
+			t.Cancelled++
+		}
+		if !job.CreatedAt.Before(since) && minutes > 0 {
+			t.EnqueuedPerMinute += 1 / minutes
+		}
+	}
+
+	durations := make(map[string][]time.Duration)
+	attempts := make(map[string]int)
+	retries := make(map[string]int)
+	classes := make(map[[2]string]int64)
+	for _, attempt := range s.attempts {
+		job, ok := s.jobs[attempt.JobID]
+		if !ok || attempt.FinishedAt.Before(since) {
+			continue
+		}
+		t := typeStats(job.Type)
+		attempts[job.Type]++
+		if attempt.Attempt > 1 {
+			retries[job.Type]++
+		}
+		if attempt.Outcome == "completed" {
+			durations[job.Type] = append(durations[job.Type], attempt.Duration)
+			if minutes > 0 {
+				t.CompletedPerMinute += 1 / minutes
+			}
+			continue
+		}
+		if minutes > 0 {
+			t.FailedPerMinute += 1 / minutes
+		}
+		class := attempt.ErrorClass
+		if class == "" {
+			class = "unknown"
+		}
+		classes[[2]string{job.Type, class}]++
+	}
+
+	stats := &discovery.QueueStats{GeneratedAt: now, WindowSeconds: window.Seconds()}
+	for jobType, t := range byType {
+		if n := attempts[jobType]; n > 0 {
+			t.RetryRate = float64(retries[jobType]) / float64(n)
+		}
+		if d := durations[jobType]; len(d) > 0 {
+			sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+			t.P50Seconds = d[(len(d)-1)*50/100].Seconds()
+			t.P95Seconds = d[(len(d)-1)*95/100].Seconds()
+		}
+		stats.Types = append(stats.Types, *t)
+	}
+	sort.Slice(stats.Types, func(i, j int) bool { return stats.Types[i].JobType < stats.Types[j].JobType })
+
+	for key, count := range classes {
+		stats.FailureClasses = append(stats.FailureClasses, discovery.FailureClassCount{
+			JobType: key[0], ErrorClass: key[1], Count: count,
+		})
+	}
+	sort.Slice(stats.FailureClasses, func(i, j int) bool {
+		return stats.FailureClasses[i].Count > stats.FailureClasses[j].Count
+	})
+
+	if p, ok := s.progress["npm_changes"]; ok {
+		stats.Discovery.LastSequence = p.lastSequence
+		stats.Discovery.TotalProcessed = p.totalProcessed
+	}
+
+	return stats, nil
+}
+
+// PauseJobType stops jobs of jobType from being claimed.
+func (s *Store) PauseJobType(jobType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.paused[jobType] = true
+}
+
+func (s *Store) ResumeJobType(jobType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.paused, jobType)
+	s.wakes.Notify(jobType)
+}
+
+// CancelJob cancels an unfinished job. A running job is abandoned at its
+// worker's next heartbeat.
+func (s *Store) CancelJob(id uuid.UUID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok || (job.Status != "pending" && job.Status != "paused" && job.Status != "processing") {
+		return false
+	}
+
+	now := s.now()
+	job.Status = "cancelled"
+	job.CompletedAt = &now
+	job.LeaseExpiresAt = nil
+	job.ErrorMessage = "cancelled by operator"
+	return true
+}
+
+// SetWorkerPools replaces the pools returned to a processor.Supervisor.
+func (s *Store) SetWorkerPools(pools []processor.PoolConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pools = append([]processor.PoolConfig(nil), pools...)
+}
+
+func (s *Store) ListWorkerPools(ctx context.Context) ([]processor.PoolConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]processor.PoolConfig(nil), s.pools...), nil
+}
+
+// Jobs returns a snapshot of every job in the queue, oldest first.
+func (s *Store) Jobs() []models.Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]models.Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.Before(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// Package returns the stored package with the given name.
+func (s *Store) Package(name string) (models.Package, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pkg, ok := s.packages[name]
+	if !ok {
+		return models.Package{}, false
+	}
+	return *pkg, true
+}
+
+// Scripts returns the stored scripts for a package, ordered by type.
+func (s *Store) Scripts(packageID uuid.UUID) []models.PackageScript {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var scripts []models.PackageScript
+	for key, script := range s.scripts {
+		if key.packageID == packageID {
+			scripts = append(scripts, *script)
+		}
+	}
+	sort.Slice(scripts, func(i, j int) bool {
+		return scripts[i].ScriptType < scripts[j].ScriptType
+	})
+	return scripts
+}
+
+var (
+	_ discovery.JobStore       = (*Store)(nil)
+	_ processor.JobStore       = (*Store)(nil)
+	_ processor.PackageStore   = (*Store)(nil)
+	_ processor.LeaseReaper    = (*Store)(nil)
+	_ processor.Wakeups        = (*Store)(nil)
+	_ processor.PoolSource     = (*Store)(nil)
+	_ processor.DownloadStore  = (*Store)(nil)
+	_ processor.ScriptStore    = (*Store)(nil)
+	_ stats.Store              = (*Store)(nil)
+	_ processor.RetentionStore = (*Store)(nil)
+)
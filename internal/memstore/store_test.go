@@ -0,0 +1,650 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	dto "github.com/prometheus/client_model/go"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/fakeregistry"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/metrics"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// clock is a settable time source for the store's now field.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newStore(t *testing.T) (*Store, *clock) {
+	t.Helper()
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(nil, nil))
+	s := New(handlers)
+	c := &clock{t: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s.now = c.now
+	return s, c
+}
+
+func enqueue(t *testing.T, s *Store, name string, priority int) uuid.UUID {
+	t.Helper()
+	job, err := jobs.NewJob(jobs.TypeFetchPackage, &jobs.FetchPackagePayload{PackageName: name}, priority)
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+	id, err := s.EnqueueJob(context.Background(), job)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	return id
+}
+
+func claimedNames(claimed []*models.Job) []string {
+	var names []string
+	for _, job := range claimed {
+		names = append(names, job.Payload["package_name"].(string))
+	}
+	return names
+}
+
+func TestEnqueueValidatesPayload(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	if _, err := s.EnqueueJob(ctx, models.Job{Type: jobs.TypeFetchPackage}); err == nil {
+		t.Error("a fetch job without a package name was enqueued")
+	}
+	if _, err := s.EnqueueJob(ctx, models.Job{Type: "no_such_type"}); err == nil {
+		t.Error("a job of an unregistered type was enqueued")
+	}
+
+	id := enqueue(t, s, "left-pad", 5)
+	job := s.Jobs()[0]
+	if job.ID != id || job.Status != "pending" || job.MaxAttempts != jobs.DefaultRetryPolicy().MaxAttempts {
+		t.Errorf("enqueued job = %+v", job)
+	}
+}
+
+func TestClaimOrder(t *testing.T) {
+	s, c := newStore(t)
+	ctx := context.Background()
+
+	for i, name := range []string{"low", "high", "mid", "high-later"} {
+		priority := map[string]int{"low": 9, "high": 1, "mid": 5, "high-later": 1}[name]
+		c.t = c.t.Add(time.Duration(i) * time.Second)
+		enqueue(t, s, name, priority)
+	}
+
+	claimed, err := s.ClaimJobs(ctx, "w1", nil, 3)
+	if err != nil {
+		t.Fatalf("ClaimJobs: %v", err)
+	}
+	want := []string{"high", "high-later", "mid"}
+	if got := claimedNames(claimed); !equal(got, want) {
+		t.Errorf("claimed %v, want %v", got, want)
+	}
+	for _, job := range claimed {
+		if job.WorkerID != "w1" || job.Attempts != 1 || job.LeaseExpiresAt == nil {
+			t.Errorf("claimed job = %+v", job)
+		}
+	}
+
+	claimed, _ = s.ClaimJobs(ctx, "w2", nil, 10)
+	if got := claimedNames(claimed); !equal(got, []string{"low"}) {
+		t.Errorf("second claim got %v, want [low]", got)
+	}
+	if claimed, _ = s.ClaimJobs(ctx, "w2", nil, 10); len(claimed) != 0 {
+		t.Errorf("claimed %d jobs from an empty queue", len(claimed))
+	}
+}
+
+func TestClaimRespectsTypesAndPauses(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	enqueue(t, s, "left-pad", 5)
+
+	if claimed, _ := s.ClaimJobs(ctx, "w1", []string{jobs.TypeRefreshDownloads}, 10); len(claimed) != 0 {
+		t.Errorf("worker for %s claimed %v", jobs.TypeRefreshDownloads, claimedNames(claimed))
+	}
+
+	s.PauseJobType(jobs.TypeFetchPackage)
+	if claimed, _ := s.ClaimJobs(ctx, "w1", nil, 10); len(claimed) != 0 {
+		t.Errorf("claimed %v while the type was paused", claimedNames(claimed))
+	}
+
+	wake := s.Wait([]string{jobs.TypeFetchPackage})
+	other := s.Wait([]string{jobs.TypeRefreshDownloads})
+	s.ResumeJobType(jobs.TypeFetchPackage)
+	select {
+	case <-wake:
+	default:
+		t.Error("resuming a job type did not wake waiting workers")
+	}
+	select {
+	case <-other:
+		t.Error("resuming a job type woke workers of another type")
+	default:
+	}
+	if claimed, _ := s.ClaimJobs(ctx, "w1", []string{jobs.TypeFetchPackage}, 10); len(claimed) != 1 {
+		t.Errorf("claimed %d jobs after resuming, want 1", len(claimed))
+	}
+}
+
+func TestWakeupsMatchJobTypes(t *testing.T) {
+	s, _ := newStore(t)
+
+	fetch := s.Wait([]string{jobs.TypeFetchPackage, jobs.TypeRefreshDownloads})
+	all := s.Wait(nil)
+	refresh := s.Wait([]string{jobs.TypeRefreshDownloads})
+	enqueue(t, s, "left-pad", 5)
+
+	for name, wake := range map[string]<-chan struct{}{"fetch": fetch, "any": all} {
+		select {
+		case <-wake:
+		default:
+			t.Errorf("enqueueing %s did not wake the %s waiter", jobs.TypeFetchPackage, name)
+		}
+	}
+	select {
+	case <-refresh:
+		t.Errorf("enqueueing %s woke a %s waiter", jobs.TypeFetchPackage, jobs.TypeRefreshDownloads)
+	default:
+	}
+}
+
+func TestFailJobRetriesUntilExhausted(t *testing.T) {
+	s, c := newStore(t)
+	ctx := context.Background()
+	id := enqueue(t, s, "left-pad", 5)
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		claimed, _ := s.ClaimJobs(ctx, "w1", nil, 1)
+		if len(claimed) != 1 {
+			t.Fatalf("attempt %d: claimed %d jobs, want 1", attempt, len(claimed))
+		}
+		failed, err := s.FailJob(ctx, models.JobAttempt{
+			JobID:        id,
+			Attempt:      claimed[0].Attempts,
+			WorkerID:     "w1",
+			Outcome:      "failed",
+			ErrorClass:   processor.ErrorClassUpstream5xx,
+			ErrorMessage: "boom",
+		}, time.Minute)
+		if err != nil || !failed {
+			t.Fatalf("FailJob = %v, %v", failed, err)
+		}
+
+		job := s.Jobs()[0]
+		if attempt < 3 {
+			if job.Status != "pending" || !job.NextAttemptAfter.Equal(c.t.Add(time.Minute)) {
+				t.Errorf("attempt %d: job = %s, next attempt %v", attempt, job.Status, job.NextAttemptAfter)
+			}
+			if claimed, _ := s.ClaimJobs(ctx, "w1", nil, 1); len(claimed) != 0 {
+				t.Errorf("attempt %d: job was claimable before its backoff", attempt)
+			}
+			c.t = c.t.Add(time.Minute)
+			continue
+		}
+		if job.Status != "failed" || job.ErrorClass != processor.ErrorClassUpstream5xx {
+			t.Errorf("job after its last attempt = %+v", job)
+		}
+	}
+
+	if attempts := s.Attempts(id); len(attempts) != 3 {
+		t.Errorf("recorded %d attempts, want 3", len(attempts))
+	}
+}
+
+func TestFailJobWithoutRetry(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	id := enqueue(t, s, "left-pad", 5)
+
+	s.ClaimJobs(ctx, "w1", nil, 1)
+	if _, err := s.FailJob(ctx, models.JobAttempt{JobID: id, Attempt: 1, WorkerID: "w1", Outcome: "failed"}, 0); err != nil {
+		t.Fatalf("FailJob: %v", err)
+	}
+	if job := s.Jobs()[0]; job.Status != "failed" || job.Attempts != 1 {
+		t.Errorf("job failed without retry = %s after %d attempts", job.Status, job.Attempts)
+	}
+}
+
+func TestCompleteJobs(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	a := enqueue(t, s, "a", 5)
+	b := enqueue(t, s, "b", 5)
+
+	s.ClaimJobs(ctx, "w1", nil, 2)
+	completed, err := s.CompleteJobs(ctx, []models.JobAttempt{
+		{JobID: a, Attempt: 1, WorkerID: "w1", Outcome: "completed"},
+		{JobID: b, Attempt: 1, WorkerID: "w1", Outcome: "completed"},
+	})
+	if err != nil {
+		t.Fatalf("CompleteJobs: %v", err)
+	}
+	if len(completed) != 2 {
+		t.Errorf("completed %v, want both jobs", completed)
+	}
+	for _, job := range s.Jobs() {
+		if job.Status != "completed" || job.CompletedAt == nil || job.LeaseExpiresAt != nil {
+			t.Errorf("completed job = %+v", job)
+		}
+	}
+	if len(s.Attempts(a)) != 1 || len(s.Attempts(b)) != 1 {
+		t.Error("completing did not record one attempt per job")
+	}
+}
+
+// TestStaleWorker checks that a worker whose job was reaped and claimed by
+// another worker can no longer finish it.
+func TestStaleWorker(t *testing.T) {
+	s, c := newStore(t)
+	ctx := context.Background()
+	s.SetLeaseDuration(time.Minute)
+	a := enqueue(t, s, "a", 1)
+	b := enqueue(t, s, "b", 2)
+
+	s.ClaimJobs(ctx, "w1", nil, 2)
+	c.t = c.t.Add(2 * time.Minute)
+	s.ReapExpiredJobs(ctx)
+	if claimed, _ := s.ClaimJobs(ctx, "w2", nil, 2); len(claimed) != 2 {
+		t.Fatalf("w2 claimed %d reaped jobs, want 2", len(claimed))
+	}
+
+	completed, err := s.CompleteJobs(ctx, []models.JobAttempt{{JobID: a, Attempt: 1, WorkerID: "w1", Outcome: "completed"}})
+	if err != nil || len(completed) != 0 {
+		t.Errorf("stale CompleteJobs = %v, %v, want nothing completed", completed, err)
+	}
+	failed, err := s.FailJob(ctx, models.JobAttempt{JobID: b, Attempt: 1, WorkerID: "w1", Outcome: "failed"}, 0)
+	if err != nil || failed {
+		t.Errorf("stale FailJob = %v, %v, want false", failed, err)
+	}
+	if released, _ := s.ReleaseJobs(ctx, "w1", []uuid.UUID{a, b}); released != 0 {
+		t.Errorf("stale worker released %d jobs", released)
+	}
+
+	for _, job := range s.Jobs() {
+		if job.Status != "processing" || job.WorkerID != "w2" {
+			t.Errorf("job held by w2 = %s on %s", job.Status, job.WorkerID)
+		}
+		if attempts := s.Attempts(job.ID); len(attempts) != 1 || attempts[0].Outcome != "lease_expired" {
+			t.Errorf("attempts = %+v, want only the reaped one", attempts)
+		}
+	}
+
+	completed, _ = s.CompleteJobs(ctx, []models.JobAttempt{{JobID: a, Attempt: 2, WorkerID: "w2", Outcome: "completed"}})
+	if len(completed) != 1 || completed[0] != a {
+		t.Errorf("w2 completed %v, want %s", completed, a)
+	}
+}
+
+func TestReleaseJobs(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+	id := enqueue(t, s, "left-pad", 5)
+
+	s.ClaimJobs(ctx, "w1", nil, 1)
+	wake := s.Wait(nil)
+	released, err := s.ReleaseJobs(ctx, "w1", []uuid.UUID{id})
+	if err != nil || released != 1 {
+		t.Fatalf("ReleaseJobs = %d, %v, want 1", released, err)
+	}
+	select {
+	case <-wake:
+	default:
+		t.Error("releasing a job did not wake waiting workers")
+	}
+
+	job := s.Jobs()[0]
+	if job.Status != "pending" || job.Attempts != 0 || job.WorkerID != "" || job.LeaseExpiresAt != nil {
+		t.Errorf("released job = %+v", job)
+	}
+	if attempts := s.Attempts(id); len(attempts) != 0 {
+		t.Errorf("releasing recorded attempts %+v", attempts)
+	}
+	if claimed, _ := s.ClaimJobs(ctx, "w2", nil, 1); len(claimed) != 1 || claimed[0].Attempts != 1 {
+		t.Errorf("reclaimed %+v, want the job on its first attempt", claimed)
+	}
+}
+
+func TestLeases(t *testing.T) {
+	s, c := newStore(t)
+	ctx := context.Background()
+	s.SetLeaseDuration(time.Minute)
+	kept := enqueue(t, s, "kept", 1)
+	expired := enqueue(t, s, "expired", 2)
+	cancelled := enqueue(t, s, "cancelled", 3)
+
+	s.ClaimJobs(ctx, "w1", nil, 3)
+	s.CancelJob(cancelled)
+
+	c.t = c.t.Add(50 * time.Second)
+	held, err := s.ExtendLeases(ctx, "w1", []uuid.UUID{kept, cancelled})
+	if err != nil {
+		t.Fatalf("ExtendLeases: %v", err)
+	}
+	if len(held) != 1 || held[0] != kept {
+		t.Errorf("held leases = %v, want only %s", held, kept)
+	}
+	if held, _ := s.ExtendLeases(ctx, "w2", []uuid.UUID{kept}); len(held) != 0 {
+		t.Error("another worker extended a lease it does not hold")
+	}
+
+	c.t = c.t.Add(20 * time.Second)
+	requeued, failed, err := s.ReapExpiredJobs(ctx)
+	if err != nil {
+		t.Fatalf("ReapExpiredJobs: %v", err)
+	}
+	if requeued != 1 || failed != 0 {
+		t.Errorf("reaped %d requeued, %d failed, want 1 and 0", requeued, failed)
+	}
+
+	for _, job := range s.Jobs() {
+		switch job.ID {
+		case kept:
+			if job.Status != "processing" {
+				t.Errorf("job with a live lease is %s", job.Status)
+			}
+		case expired:
+			if job.Status != "pending" || job.ErrorClass != processor.ErrorClassLeaseExpired {
+				t.Errorf("reaped job = %+v", job)
+			}
+		case cancelled:
+			if job.Status != "cancelled" {
+				t.Errorf("cancelled job is %s", job.Status)
+			}
+		}
+	}
+	if attempts := s.Attempts(expired); len(attempts) != 1 || attempts[0].Outcome != "lease_expired" {
+		t.Errorf("attempts of the reaped job = %+v", attempts)
+	}
+}
+
+// TestPipeline runs the scraper and a worker against the fake registry and
+// checks that every changed package ends up stored.
+func TestPipeline(t *testing.T) {
+	fixtures, err := fakeregistry.LoadFixtures("../fakeregistry/testdata/basic")
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	ts := fakeregistry.New(fixtures).Start()
+	defer ts.Close()
+	client, err := discovery.NewClientWithConfig(fakeregistry.ClientConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("NewClientWithConfig: %v", err)
+	}
+
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(client, client))
+	s := New(handlers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scraper := discovery.NewScraper(discovery.Config{
+		BatchSize:    2,
+		RequestDelay: 5 * time.Millisecond,
+		IdleDelay:    5 * time.Millisecond,
+	}, client, s, s)
+	worker := processor.NewWorker(1, processor.Config{BatchSize: 2}, processor.Dependencies{
+		Jobs:     s,
+		Packages: s,
+		Handlers: handlers,
+		Wakeups:  s,
+	}, nil)
+
+	done := make(chan struct{}, 2)
+	go func() { scraper.Run(ctx); done <- struct{}{} }()
+	go func() { worker.Start(ctx); done <- struct{}{} }()
+	defer func() {
+		cancel()
+		<-done
+		<-done
+	}()
+
+	names := []string{"left-pad", "postinstall-beacon", "@fixture/util"}
+	waitFor(t, func() bool {
+		for _, name := range names {
+			if _, ok := s.Package(name); !ok {
+				return false
+			}
+		}
+		return true
+	})
+
+	seq, processed, _ := s.GetScrapeProgress(ctx, "npm_changes")
+	if seq != "5" || processed != 3 {
+		t.Errorf("scrape progress = %s/%d, want 5/3", seq, processed)
+	}
+
+	waitFor(t, func() bool {
+		for _, job := range s.Jobs() {
+			if job.Status != "completed" {
+				return false
+			}
+		}
+		return true
+	})
+	jobsQueued := s.Jobs()
+	if len(jobsQueued) != 3 {
+		t.Errorf("queued %d jobs, want 3", len(jobsQueued))
+	}
+	for _, job := range jobsQueued {
+		if attempts := s.Attempts(job.ID); len(attempts) != 1 || attempts[0].Outcome != "completed" {
+			t.Errorf("attempts of %v = %+v", job.Payload["package_name"], attempts)
+		}
+	}
+
+	pkg, _ := s.Package("postinstall-beacon")
+	if scripts := s.Scripts(pkg.ID); len(scripts) != 2 {
+		t.Errorf("stored %d scripts for postinstall-beacon, want 2", len(scripts))
+	}
+	if events := s.Events(); len(events) != 0 {
+		t.Errorf("first sighting of the packages recorded events %+v", events)
+	}
+}
+
+func TestStoreResultsRecordsOwnershipChanges(t *testing.T) {
+	s, c := newStore(t)
+	ctx := context.Background()
+
+	published := c.t.Add(-time.Hour)
+	result := func(maintainers []string, versions ...models.PackageVersion) []models.PackageResult {
+		r := models.PackageResult{Package: models.Package{Name: "left-pad"}, Versions: versions}
+		for _, name := range maintainers {
+			r.Maintainers = append(r.Maintainers, models.Maintainer{Name: name})
+		}
+		return []models.PackageResult{r}
+	}
+	v1 := models.PackageVersion{Version: "1.0.0", PublishedAt: published, Publisher: models.Maintainer{Name: "alice"}}
+	v2 := models.PackageVersion{Version: "1.0.1", PublishedAt: published.Add(time.Minute),
+		Publisher: models.Maintainer{Name: "mallory"}, HasInstallScripts: true}
+
+	if err := s.StoreResults(ctx, result([]string{"alice", "bob"}, v1)); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	if events := s.Events(); len(events) != 0 {
+		t.Fatalf("first store recorded events %+v", events)
+	}
+
+	results := result([]string{"alice", "mallory"}, v1, v2)
+	if err := s.StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+
+	want := []models.PackageEvent{
+		{Type: models.EventMaintainerAdded, Account: "mallory"},
+		{Type: models.EventMaintainerRemoved, Account: "bob"},
+		{Type: models.EventNewPublisher, Account: "mallory", Version: "1.0.1", HasInstallScripts: true},
+	}
+	events := s.Events()
+	if len(events) != len(want) || len(results[0].Events) != len(want) {
+		t.Fatalf("recorded %+v, want %d events", events, len(want))
+	}
+	for i, w := range want {
+		got := events[i]
+		if got.Type != w.Type || got.Account != w.Account || got.Version != w.Version ||
+			got.HasInstallScripts != w.HasInstallScripts || got.PackageName != "left-pad" {
+			t.Errorf("event %d = %+v, want %+v", i, got, w)
+		}
+	}
+
+	// Losing every maintainer is recorded too.
+	results = result(nil, v1, v2)
+	if err := s.StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	var removed []string
+	for _, event := range results[0].Events {
+		if event.Type == models.EventMaintainerRemoved {
+			removed = append(removed, event.Account)
+		}
+	}
+	if !equal(removed, []string{"alice", "mallory"}) || len(results[0].Events) != 2 {
+		t.Errorf("events after removing every maintainer = %+v", results[0].Events)
+	}
+
+	// So is gaining one when there were none, as the package was stored before.
+	results = result([]string{"eve"}, v1, v2)
+	if err := s.StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	if events := results[0].Events; len(events) != 1 || events[0].Type != models.EventMaintainerAdded || events[0].Account != "eve" {
+		t.Errorf("events after adding a maintainer to none = %+v", events)
+	}
+}
+
+func TestStoreResultsRecordsFirstMaintainer(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	// A package first stored without maintainers is quiet, then gaining one
+	// is an ownership change.
+	results := []models.PackageResult{{Package: models.Package{Name: "left-pad"}}}
+	if err := s.StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	if events := s.Events(); len(events) != 0 {
+		t.Fatalf("first store recorded events %+v", events)
+	}
+
+	results[0].Maintainers = []models.Maintainer{{Name: "mallory"}}
+	if err := s.StoreResults(ctx, results); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+	events := s.Events()
+	if len(events) != 1 || events[0].Type != models.EventMaintainerAdded || events[0].Account != "mallory" {
+		t.Errorf("events = %+v, want mallory added", events)
+	}
+}
+
+func TestPackageSignalsUseLatestVersion(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	store := func(latest string, versions ...models.PackageVersion) discovery.PackageSignal {
+		t.Helper()
+		err := s.StoreResults(ctx, []models.PackageResult{{
+			Package:  models.Package{Name: "left-pad", Version: latest, Downloads: 50},
+			Scripts:  []models.PackageScript{{ScriptType: "postinstall", Content: "node setup.js"}},
+			Versions: versions,
+		}})
+		if err != nil {
+			t.Fatalf("StoreResults: %v", err)
+		}
+		signals, err := s.PackageSignals(ctx, []string{"left-pad"})
+		if err != nil {
+			t.Fatalf("PackageSignals: %v", err)
+		}
+		return signals["left-pad"]
+	}
+
+	// The script stored for 1.0.0 stays, but 1.0.1 dropped it.
+	if signal := store("1.0.1",
+		models.PackageVersion{Version: "1.0.0", HasInstallScripts: true},
+		models.PackageVersion{Version: "1.0.1"},
+	); !signal.Known || signal.HasScripts {
+		t.Errorf("signal = %+v, want known without scripts", signal)
+	}
+
+	if signal := store("1.0.2", models.PackageVersion{Version: "1.0.2", HasInstallScripts: true}); !signal.HasScripts {
+		t.Errorf("signal = %+v, want scripts in the latest version", signal)
+	}
+}
+
+func TestFindingsCountedOnce(t *testing.T) {
+	s, _ := newStore(t)
+	ctx := context.Background()
+
+	shell := models.ScriptFinding{Rule: "test_remote_shell", Severity: "high"}
+	fetch := models.ScriptFinding{Rule: "test_network_fetch", Severity: "medium"}
+	store := func(findings ...models.ScriptFinding) {
+		t.Helper()
+		err := s.StoreResults(ctx, []models.PackageResult{{
+			Package: models.Package{Name: "left-pad"},
+			Scripts: []models.PackageScript{{ScriptType: "postinstall", Content: "curl | sh", Findings: findings}},
+		}})
+		if err != nil {
+			t.Fatalf("StoreResults: %v", err)
+		}
+	}
+
+	store(shell)
+	store(shell)
+	if got := findingCount(t, shell); got != 1 {
+		t.Errorf("storing an unchanged script twice counted %v findings, want 1", got)
+	}
+
+	pkg, _ := s.Package("left-pad")
+	script := s.Scripts(pkg.ID)[0]
+	script.Findings = []models.ScriptFinding{shell}
+	if err := s.ReplaceFindings(ctx, []models.PackageScript{script}); err != nil {
+		t.Fatalf("ReplaceFindings: %v", err)
+	}
+	if got := findingCount(t, shell); got != 1 {
+		t.Errorf("re-analysing counted the finding again: %v", got)
+	}
+
+	script.Findings = []models.ScriptFinding{shell, fetch}
+	s.ReplaceFindings(ctx, []models.PackageScript{script})
+	if shellCount, fetchCount := findingCount(t, shell), findingCount(t, fetch); shellCount != 1 || fetchCount != 1 {
+		t.Errorf("after adding a rule counted %v and %v, want 1 and 1", shellCount, fetchCount)
+	}
+}
+
+func findingCount(t *testing.T, f models.ScriptFinding) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := metrics.ScriptFindings.WithLabelValues(f.Rule, f.Severity).Write(&m); err != nil {
+		t.Fatalf("read metric: %v", err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
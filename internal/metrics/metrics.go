@@ -0,0 +1,170 @@
+// Package metrics holds the Prometheus collectors exported on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"scrapeNPM/internal/logging"
+)
+
+const namespace = "scrapenpm"
+
+// Registry is the registry served by Handler. Collectors that need a live
+// dependency, such as the database pool collector, are registered on it at
+// startup.
+var Registry = prometheus.NewRegistry()
+
+var (
+	UpstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_requests_total",
+		Help:      "HTTP requests to the npm registry, replication feed and downloads API by host and status.",
+	}, []string{"host", "status"})
+
+	UpstreamRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Time to receive response headers from upstream hosts.",
+		Buckets:   prometheus.ExponentialBuckets(0.025, 2, 10),
+	}, []string{"host"})
+
+	JobsClaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_claimed_total",
+		Help:      "Jobs claimed by workers in this process.",
+	}, []string{"job_type"})
+
+	JobsCompleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_completed_total",
+		Help:      "Jobs completed by workers in this process.",
+	}, []string{"job_type"})
+
+	JobsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_failed_total",
+		Help:      "Failed job attempts in this process, including attempts that will be retried.",
+	}, []string{"job_type", "error_class"})
+
+	JobDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "Time spent running job handlers.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"job_type", "outcome"})
+
+	ChangesFeedSequence = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "changes_feed_sequence",
+		Help:      "Last changes feed sequence checkpointed by the scraper.",
+	})
+
+	ChangesFeedUpdateSequence = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "changes_feed_update_sequence",
+		Help:      "Latest sequence reported by the registry replication endpoint.",
+	})
+
+	ChangesFeedLag = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "changes_feed_lag",
+		Help:      "Changes between the registry's latest sequence and the scraper's checkpoint.",
+	})
+
+	ScriptFindings = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "script_findings_total",
+		Help:      "Install script findings stored for the first time, by rule and severity.",
+	}, []string{"rule", "severity"})
+
+	PackageEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "package_events_total",
+		Help:      "Maintainer and publisher changes recorded for stored packages, by type.",
+	}, []string{"type"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		UpstreamRequests,
+		UpstreamRequestDuration,
+		JobsClaimed,
+		JobsCompleted,
+		JobsFailed,
+		JobDuration,
+		ChangesFeedSequence,
+		ChangesFeedUpdateSequence,
+		ChangesFeedLag,
+		ScriptFindings,
+		PackageEvents,
+	)
+}
+
+// Handler serves Registry in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// SetFeedPosition records the scraper's checkpoint and the registry's latest
+// sequence. Sequences that are not numeric are ignored.
+func SetFeedPosition(current, latest string) {
+	cur, err := strconv.ParseFloat(current, 64)
+	if err != nil {
+		return
+	}
+	ChangesFeedSequence.Set(cur)
+
+	if latest == "" {
+		return
+	}
+	upd, err := strconv.ParseFloat(latest, 64)
+	if err != nil {
+		return
+	}
+	ChangesFeedUpdateSequence.Set(upd)
+
+	// The latest sequence is only re-read periodically, so the checkpoint
+	// can briefly run ahead of it.
+	if lag := upd - cur; lag > 0 {
+		ChangesFeedLag.Set(lag)
+	} else {
+		ChangesFeedLag.Set(0)
+	}
+}
+
+// Transport counts and times requests made through the wrapped transport and
+// logs them at debug level with the fields of the request context.
+type Transport struct {
+	next http.RoundTripper
+}
+
+func NewTransport(next http.RoundTripper) *Transport {
+	return &Transport{next: next}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	elapsed := time.Since(start)
+	UpstreamRequestDuration.WithLabelValues(req.URL.Host).Observe(elapsed.Seconds())
+
+	logger := logging.FromContext(req.Context()).With("method", req.Method, "url", req.URL.String())
+	if err != nil {
+		UpstreamRequests.WithLabelValues(req.URL.Host, "error").Inc()
+		logger.Debug("Upstream request failed", logging.KeyDuration, elapsed, logging.Err(err))
+		return resp, err
+	}
+
+	UpstreamRequests.WithLabelValues(req.URL.Host, strconv.Itoa(resp.StatusCode)).Inc()
+	logger.Debug("Upstream request", logging.KeyUpstreamStatus, resp.StatusCode, logging.KeyDuration, elapsed)
+
+	return resp, nil
+}
@@ -29,6 +29,74 @@ type PackageScript struct {
 	Content    string    `json:"content" db:"content"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+
+	Findings []ScriptFinding `json:"findings,omitempty" db:"-"`
+}
+
+// ScriptFinding is a rule from internal/analysis that matched a script.
+type ScriptFinding struct {
+	ID        int64     `json:"id" db:"id"`
+	ScriptID  uuid.UUID `json:"script_id" db:"script_id"`
+	Rule      string    `json:"rule" db:"rule"`
+	Severity  string    `json:"severity" db:"severity"`
+	Match     string    `json:"match" db:"match"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Maintainer is an npm account, as listed in a packument's maintainers or
+// a version's _npmUser.
+type Maintainer struct {
+	Name  string `json:"name" db:"name"`
+	Email string `json:"email,omitempty" db:"email"`
+}
+
+func (m Maintainer) String() string {
+	if m.Email == "" {
+		return m.Name
+	}
+	return m.Name + " <" + m.Email + ">"
+}
+
+// PackageVersion is a published version and the account that published it.
+// Publisher is empty for versions published before npm recorded it.
+type PackageVersion struct {
+	Version           string     `json:"version" db:"version"`
+	PublishedAt       time.Time  `json:"published_at" db:"published_at"`
+	Publisher         Maintainer `json:"publisher" db:"-"`
+	HasInstallScripts bool       `json:"has_install_scripts" db:"has_install_scripts"`
+}
+
+// Package event types.
+const (
+	EventMaintainerAdded   = "maintainer_added"
+	EventMaintainerRemoved = "maintainer_removed"
+	EventNewPublisher      = "new_publisher"
+)
+
+// PackageEvent is an ownership change noticed when a package is stored: a
+// maintainer added or removed, or a version published by an account that had
+// not published the package before. Version and HasInstallScripts are only
+// set for new publishers.
+type PackageEvent struct {
+	ID                int64     `json:"id" db:"id"`
+	PackageID         uuid.UUID `json:"package_id" db:"package_id"`
+	PackageName       string    `json:"package_name" db:"-"`
+	Type              string    `json:"type" db:"event_type"`
+	Account           string    `json:"account" db:"account"`
+	Version           string    `json:"version,omitempty" db:"version"`
+	HasInstallScripts bool      `json:"has_install_scripts" db:"has_install_scripts"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// PackageResult is everything extracted for one package, stored together.
+// Script PackageIDs are filled in by the store once the package row exists,
+// and Events with the ownership changes storing the result revealed.
+type PackageResult struct {
+	Package     Package
+	Scripts     []PackageScript
+	Maintainers []Maintainer
+	Versions    []PackageVersion
+	Events      []PackageEvent
 }
 
 type Job struct {
@@ -43,6 +111,35 @@ type Job struct {
 	Attempts         int                    `json:"attempts" db:"attempts"`
 	MaxAttempts      int                    `json:"max_attempts" db:"max_attempts"`
 	ErrorMessage     string                 `json:"error_message,omitempty" db:"error_message"`
+	ErrorClass       string                 `json:"error_class,omitempty" db:"error_class"`
 	WorkerID         string                 `json:"worker_id,omitempty" db:"worker_id"`
 	NextAttemptAfter time.Time              `json:"next_attempt_after" db:"next_attempt_after"`
+	LeaseExpiresAt   *time.Time             `json:"lease_expires_at,omitempty" db:"lease_expires_at"`
+}
+
+type JobAttempt struct {
+	ID           int64         `json:"id" db:"id"`
+	JobID        uuid.UUID     `json:"job_id" db:"job_id"`
+	Attempt      int           `json:"attempt" db:"attempt"`
+	WorkerID     string        `json:"worker_id" db:"worker_id"`
+	StartedAt    time.Time     `json:"started_at" db:"started_at"`
+	FinishedAt   time.Time     `json:"finished_at" db:"finished_at"`
+	Duration     time.Duration `json:"duration" db:"duration_ms"`
+	Outcome      string        `json:"outcome" db:"outcome"`
+	ErrorClass   string        `json:"error_class,omitempty" db:"error_class"`
+	HTTPStatus   int           `json:"http_status,omitempty" db:"http_status"`
+	ErrorMessage string        `json:"error_message,omitempty" db:"error_message"`
+}
+
+// JobSchedule enqueues a job of JobType with Payload whenever its cron Spec
+// comes due. NextRunAt is nil until the scheduler first sees the schedule.
+type JobSchedule struct {
+	Name      string                 `json:"name" db:"name"`
+	Spec      string                 `json:"spec" db:"cron_spec"`
+	JobType   string                 `json:"job_type" db:"job_type"`
+	Payload   map[string]interface{} `json:"payload" db:"payload"`
+	Priority  int                    `json:"priority" db:"priority"`
+	Enabled   bool                   `json:"enabled" db:"enabled"`
+	LastRunAt *time.Time             `json:"last_run_at,omitempty" db:"last_run_at"`
+	NextRunAt *time.Time             `json:"next_run_at,omitempty" db:"next_run_at"`
 }
@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+)
+
+// analyzePageSize is how many scripts are analyzed and written at a time.
+const analyzePageSize = 500
+
+// AnalyzeScriptsHandler re-runs script analysis over every stored script, so
+// that rule changes apply to packages that have not been republished.
+type AnalyzeScriptsHandler struct {
+	store    ScriptStore
+	analyzer *analysis.Analyzer
+}
+
+func NewAnalyzeScriptsHandler(store ScriptStore) *AnalyzeScriptsHandler {
+	return &AnalyzeScriptsHandler{
+		store:    store,
+		analyzer: analysis.NewAnalyzer(),
+	}
+}
+
+func (h *AnalyzeScriptsHandler) Spec() jobs.Spec {
+	return jobs.Spec{
+		Type:        jobs.TypeAnalyzeScripts,
+		NewPayload:  func() jobs.Payload { return &jobs.AnalyzeScriptsPayload{} },
+		Concurrency: 1,
+		Timeout:     2 * time.Hour,
+		Retry:       jobs.DefaultRetryPolicy(),
+	}
+}
+
+func (h *AnalyzeScriptsHandler) Handle(ctx context.Context, job *models.Job, payload jobs.Payload) (*jobs.Result, error) {
+	var after uuid.UUID
+	var analyzed, findings int
+
+	for {
+		scripts, err := h.store.ListScripts(ctx, after, analyzePageSize)
+		if err != nil {
+			return nil, err
+		}
+		if len(scripts) == 0 {
+			break
+		}
+
+		for i := range scripts {
+			scripts[i].Findings = h.analyzer.Analyze(scripts[i])
+			findings += len(scripts[i].Findings)
+		}
+
+		if err := h.store.ReplaceFindings(ctx, scripts); err != nil {
+			return nil, err
+		}
+
+		analyzed += len(scripts)
+		after = scripts[len(scripts)-1].ID
+	}
+
+	logging.FromContext(ctx).Info("Re-analyzed scripts", "scripts", analyzed, "findings", findings)
+	return &jobs.Result{}, nil
+}
+
+var _ jobs.Handler = (*AnalyzeScriptsHandler)(nil)
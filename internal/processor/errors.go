@@ -0,0 +1,97 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+
+	"github.com/jackc/pgconn"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/jobs"
+)
+
+// Error classes recorded against failed attempts and used to group dead
+// letters.
+const (
+	ErrorClassRateLimited    = "rate_limited"
+	ErrorClassNotFound       = "not_found"
+	ErrorClassUpstream4xx    = "upstream_4xx"
+	ErrorClassUpstream5xx    = "upstream_5xx"
+	ErrorClassTimeout        = "timeout"
+	ErrorClassCancelled      = "cancelled"
+	ErrorClassNetwork        = "network"
+	ErrorClassDecode         = "decode"
+	ErrorClassInvalidPayload = "invalid_payload"
+	ErrorClassUnknownType    = "unknown_job_type"
+	ErrorClassDatabase       = "database"
+	ErrorClassLeaseExpired   = "lease_expired"
+	ErrorClassUnknown        = "unknown"
+)
+
+// ClassifyError maps a processing error to an error class and, where the
+// failure came from an upstream response, its HTTP status.
+func ClassifyError(err error) (string, int) {
+	var httpErr *discovery.HTTPError
+	if errors.As(err, &httpErr) {
+		switch {
+		case httpErr.StatusCode == 429:
+			return ErrorClassRateLimited, httpErr.StatusCode
+		case httpErr.StatusCode == 404:
+			return ErrorClassNotFound, httpErr.StatusCode
+		case httpErr.StatusCode >= 500:
+			return ErrorClassUpstream5xx, httpErr.StatusCode
+		default:
+			return ErrorClassUpstream4xx, httpErr.StatusCode
+		}
+	}
+
+	if errors.Is(err, jobs.ErrInvalidPayload) {
+		return ErrorClassInvalidPayload, 0
+	}
+
+	if errors.Is(err, jobs.ErrUnknownJobType) {
+		return ErrorClassUnknownType, 0
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorClassTimeout, 0
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return ErrorClassCancelled, 0
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return ErrorClassTimeout, 0
+		}
+		return ErrorClassNetwork, 0
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+		return ErrorClassDecode, 0
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return ErrorClassDatabase, 0
+	}
+
+	return ErrorClassUnknown, 0
+}
+
+// Retryable reports whether a failure of the given class can succeed on a
+// later attempt. Packages the registry does not have, like invalid payloads,
+// fail the same way every time.
+func Retryable(class string) bool {
+	switch class {
+	case ErrorClassNotFound, ErrorClassInvalidPayload, ErrorClassUnknownType:
+		return false
+	}
+	return true
+}
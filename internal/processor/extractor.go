@@ -3,6 +3,8 @@ package processor
 import (
 	"fmt"
 	"math"
+	"sort"
+	"strings"
 	"time"
 
 	"scrapeNPM/internal/models"
@@ -10,6 +12,9 @@ import (
 	"github.com/google/uuid"
 )
 
+// installScripts are the scripts npm runs when a package is installed.
+var installScripts = []string{"install", "preinstall", "postinstall"}
+
 type Extractor struct{}
 
 func NewExtractor() *Extractor {
@@ -104,8 +109,7 @@ func (e *Extractor) ExtractScripts(rawData map[string]interface{}, packageID uui
 		return scripts, nil
 	}
 
-	scriptTypes := []string{"install", "preinstall", "postinstall"}
-	for _, scriptType := range scriptTypes {
+	for _, scriptType := range installScripts {
 		if content, ok := scriptsData[scriptType].(string); ok && content != "" {
 			script := models.PackageScript{
 				PackageID:  packageID,
@@ -119,6 +123,68 @@ func (e *Extractor) ExtractScripts(rawData map[string]interface{}, packageID uui
 	return scripts, nil
 }
 
+// ExtractMaintainers returns the accounts listed as the package's
+// maintainers, each once.
+func (e *Extractor) ExtractMaintainers(rawData map[string]interface{}) []models.Maintainer {
+	list, _ := rawData["maintainers"].([]interface{})
+
+	seen := make(map[string]bool, len(list))
+	var maintainers []models.Maintainer
+	for _, entry := range list {
+		if m, ok := ParseMaintainer(entry); ok && !seen[m.Name] {
+			seen[m.Name] = true
+			maintainers = append(maintainers, m)
+		}
+	}
+	return maintainers
+}
+
+// ExtractVersions returns every published version with its publish time,
+// publisher and whether it has install scripts.
+func (e *Extractor) ExtractVersions(rawData map[string]interface{}) []models.PackageVersion {
+	versionsData, _ := rawData["versions"].(map[string]interface{})
+	times, _ := rawData["time"].(map[string]interface{})
+
+	versions := make([]models.PackageVersion, 0, len(versionsData))
+	for version, data := range versionsData {
+		versionData, _ := data.(map[string]interface{})
+		v := models.PackageVersion{Version: version}
+		if published, ok := times[version].(string); ok {
+			if t, err := time.Parse(time.RFC3339, published); err == nil {
+				v.PublishedAt = t
+			}
+		}
+		v.Publisher, _ = ParseMaintainer(versionData["_npmUser"])
+
+		scriptsData, _ := versionData["scripts"].(map[string]interface{})
+		for _, scriptType := range installScripts {
+			if content, ok := scriptsData[scriptType].(string); ok && content != "" {
+				v.HasInstallScripts = true
+			}
+		}
+		versions = append(versions, v)
+	}
+
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	return versions
+}
+
+// ParseMaintainer reads an npm account given either as an object or, in old
+// packuments, as a "name <email>" string.
+func ParseMaintainer(value interface{}) (models.Maintainer, bool) {
+	var m models.Maintainer
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m.Name, _ = v["name"].(string)
+		m.Email, _ = v["email"].(string)
+	case string:
+		name, email, _ := strings.Cut(v, "<")
+		m.Name = strings.TrimSpace(name)
+		m.Email = strings.TrimSuffix(strings.TrimSpace(email), ">")
+	}
+	return m, m.Name != ""
+}
+
 // CalculatePopularityScore calculates a popularity score based on downloads
 func (e *Extractor) CalculatePopularityScore(downloads int64) float64 {
 	return math.Min(1.0, float64(downloads)/1000000.0)
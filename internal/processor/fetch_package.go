@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"scrapeNPM/internal/analysis"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/tracing"
+)
+
+// FetchPackageHandler fetches a package's packument and download count and
+// extracts its metadata and install scripts.
+type FetchPackageHandler struct {
+	registry  RegistryFetcher
+	downloads DownloadStatsSource
+	extractor *Extractor
+	analyzer  *analysis.Analyzer
+}
+
+func NewFetchPackageHandler(registry RegistryFetcher, downloads DownloadStatsSource) *FetchPackageHandler {
+	return &FetchPackageHandler{
+		registry:  registry,
+		downloads: downloads,
+		extractor: NewExtractor(),
+		analyzer:  analysis.NewAnalyzer(),
+	}
+}
+
+func (h *FetchPackageHandler) Spec() jobs.Spec {
+	return jobs.Spec{
+		Type:       jobs.TypeFetchPackage,
+		NewPayload: func() jobs.Payload { return &jobs.FetchPackagePayload{} },
+		Timeout:    2 * time.Minute,
+		Retry:      jobs.DefaultRetryPolicy(),
+	}
+}
+
+func (h *FetchPackageHandler) Handle(ctx context.Context, job *models.Job, payload jobs.Payload) (*jobs.Result, error) {
+	pkgName := payload.(*jobs.FetchPackagePayload).PackageName
+	logger := logging.FromContext(ctx)
+
+	logger.Debug("Fetching package")
+	rawPackage, err := h.registry.GetPackage(ctx, pkgName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch package data: %w", err)
+	}
+
+	pkg, err := h.extractor.ExtractPackageData(pkgName, rawPackage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract package data: %w", err)
+	}
+
+	logger.Debug("Fetching download count")
+	downloads, docsErr := h.downloads.GetDownloadCount(ctx, pkgName)
+	if docsErr != nil {
+		logger.Warn("Failed to fetch download count", logging.Err(docsErr))
+		downloads = 0
+	}
+	pkg.Downloads = downloads
+	pkg.PopularityScore = h.extractor.CalculatePopularityScore(downloads)
+
+	result := models.PackageResult{
+		Package:     pkg,
+		Maintainers: h.extractor.ExtractMaintainers(rawPackage),
+		Versions:    h.extractor.ExtractVersions(rawPackage),
+	}
+
+	logger.Debug("Extracting scripts")
+	scripts, err := h.extractor.ExtractScripts(rawPackage, uuid.Nil, pkg.Version)
+	if err != nil {
+		logger.Warn("Failed to extract scripts", logging.Err(err))
+	} else {
+		_, span := tracing.Start(ctx, "analysis.analyze_scripts",
+			trace.WithAttributes(attribute.Int("scripts", len(scripts))))
+		for i := range scripts {
+			scripts[i].Findings = h.analyzer.Analyze(scripts[i])
+		}
+		span.End()
+		result.Scripts = scripts
+	}
+
+	return &jobs.Result{Packages: []models.PackageResult{result}}, nil
+}
+
+var _ jobs.Handler = (*FetchPackageHandler)(nil)
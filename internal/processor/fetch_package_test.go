@@ -0,0 +1,150 @@
+package processor_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/fakeregistry"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/memstore"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+// startRegistry serves the fake registry's basic fixtures and returns it with
+// a client pointed at it.
+func startRegistry(t *testing.T) (*fakeregistry.Server, *discovery.Client) {
+	t.Helper()
+
+	fixtures, err := fakeregistry.LoadFixtures("../fakeregistry/testdata/basic")
+	if err != nil {
+		t.Fatalf("LoadFixtures: %v", err)
+	}
+	server := fakeregistry.New(fixtures)
+	ts := server.Start()
+	t.Cleanup(ts.Close)
+
+	client, err := discovery.NewClientWithConfig(fakeregistry.ClientConfig(ts.URL))
+	if err != nil {
+		t.Fatalf("NewClientWithConfig: %v", err)
+	}
+	return server, client
+}
+
+func fetchJob(t *testing.T, name string) *models.Job {
+	t.Helper()
+	job, err := jobs.NewJob(jobs.TypeFetchPackage, &jobs.FetchPackagePayload{PackageName: name}, 5)
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+	return &job
+}
+
+func TestFetchPackageEndToEnd(t *testing.T) {
+	_, client := startRegistry(t)
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(client, client))
+	store := memstore.New(handlers)
+	ctx := context.Background()
+
+	result, err := handlers.Run(ctx, fetchJob(t, "postinstall-beacon"))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if len(result.Packages) != 1 {
+		t.Fatalf("got %d package results, want 1", len(result.Packages))
+	}
+	if err := store.StoreResults(ctx, result.Packages); err != nil {
+		t.Fatalf("StoreResults: %v", err)
+	}
+
+	pkg, ok := store.Package("postinstall-beacon")
+	if !ok {
+		t.Fatal("package was not stored")
+	}
+	if pkg.Version != "2.0.1" || pkg.Downloads != 12 || pkg.License != "MIT" {
+		t.Errorf("stored package = %+v", pkg)
+	}
+
+	scripts := map[string]models.PackageScript{}
+	for _, s := range store.Scripts(pkg.ID) {
+		scripts[s.ScriptType] = s
+	}
+	if len(scripts) != 2 {
+		t.Fatalf("stored scripts = %v, want preinstall and postinstall", scripts)
+	}
+	rules := map[string]bool{}
+	for _, f := range scripts["postinstall"].Findings {
+		rules[f.Rule] = true
+	}
+	if !rules["remote_shell"] || !rules["network_fetch"] {
+		t.Errorf("postinstall findings = %v, want remote_shell and network_fetch", scripts["postinstall"].Findings)
+	}
+	if len(scripts["preinstall"].Findings) != 0 {
+		t.Errorf("preinstall findings = %v, want none", scripts["preinstall"].Findings)
+	}
+
+	maintainers := result.Packages[0].Maintainers
+	if len(maintainers) != 1 || maintainers[0].Name != "mallory" {
+		t.Errorf("maintainers = %v", maintainers)
+	}
+	versions := result.Packages[0].Versions
+	if len(versions) != 2 || versions[1].Publisher.Name != "trudy" || !versions[1].HasInstallScripts {
+		t.Errorf("versions = %+v", versions)
+	}
+}
+
+func TestFetchPackageScopedAndMissing(t *testing.T) {
+	_, client := startRegistry(t)
+	handler := processor.NewFetchPackageHandler(client, client)
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(handler)
+	ctx := context.Background()
+
+	result, err := handlers.Run(ctx, fetchJob(t, "@fixture/util"))
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if pkg := result.Packages[0].Package; pkg.Name != "@fixture/util" || pkg.Downloads != 450 {
+		t.Errorf("scoped package = %+v", pkg)
+	}
+
+	_, err = handlers.Run(ctx, fetchJob(t, "no-such-package"))
+	if class, status := processor.ClassifyError(err); class != processor.ErrorClassNotFound || status != http.StatusNotFound {
+		t.Errorf("missing package classified as %s/%d, want %s/404", class, status, processor.ErrorClassNotFound)
+	}
+}
+
+func TestFetchPackageUpstreamFaults(t *testing.T) {
+	server, client := startRegistry(t)
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(processor.NewFetchPackageHandler(client, client))
+	ctx := context.Background()
+
+	server.InjectStatus("/left-pad", http.StatusTooManyRequests, 1)
+	_, err := handlers.Run(ctx, fetchJob(t, "left-pad"))
+	if class, _ := processor.ClassifyError(err); class != processor.ErrorClassRateLimited {
+		t.Errorf("429 classified as %s, want %s", class, processor.ErrorClassRateLimited)
+	}
+	if handlers.RetryDelay(fetchJob(t, "left-pad"), err) <= 0 {
+		t.Error("a rate-limited fetch is not retried")
+	}
+
+	server.InjectMalformedJSON("/left-pad", 1)
+	_, err = handlers.Run(ctx, fetchJob(t, "left-pad"))
+	if class, _ := processor.ClassifyError(err); class != processor.ErrorClassDecode {
+		t.Errorf("malformed packument classified as %s, want %s", class, processor.ErrorClassDecode)
+	}
+
+	// A failed download count is not fatal; the package is stored with none.
+	server.InjectStatus("/downloads/", http.StatusInternalServerError, 1)
+	result, err := handlers.Run(ctx, fetchJob(t, "left-pad"))
+	if err != nil {
+		t.Fatalf("Run with the downloads API failing: %v", err)
+	}
+	if pkg := result.Packages[0].Package; pkg.Downloads != 0 || pkg.Version != "1.3.0" {
+		t.Errorf("package with downloads failing = %+v", pkg)
+	}
+}
@@ -0,0 +1,90 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"scrapeNPM/internal/models"
+)
+
+// RegistryFetcher returns the full packument for a package.
+type RegistryFetcher interface {
+	GetPackage(ctx context.Context, packageName string) (map[string]interface{}, error)
+}
+
+// DownloadStatsSource returns the monthly download count for a package.
+type DownloadStatsSource interface {
+	GetDownloadCount(ctx context.Context, packageName string) (int64, error)
+}
+
+// JobStore is the consumer side of the job queue used by workers. Completing
+// or failing jobs also records the attempts in their history, but only for
+// jobs still held by the attempt's worker: CompleteJobs returns the IDs it
+// completed and FailJob whether it failed the job. FailJob schedules a retry
+// after retryAfter, or fails the job outright when retryAfter is zero or its
+// attempts are used up. ReleaseJobs puts held jobs back in the queue without
+// counting the attempt. ExtendLeases returns the subset of jobIDs the worker
+// still holds. ClaimJobs only claims jobs of the given types, or of any type
+// when jobTypes is empty.
+type JobStore interface {
+	ClaimJobs(ctx context.Context, workerID string, jobTypes []string, limit int) ([]*models.Job, error)
+	ExtendLeases(ctx context.Context, workerID string, jobIDs []uuid.UUID) ([]uuid.UUID, error)
+	LeaseDuration() time.Duration
+	CompleteJobs(ctx context.Context, attempts []models.JobAttempt) ([]uuid.UUID, error)
+	FailJob(ctx context.Context, attempt models.JobAttempt, retryAfter time.Duration) (bool, error)
+	ReleaseJobs(ctx context.Context, workerID string, jobIDs []uuid.UUID) (int, error)
+}
+
+// LeaseReaper recovers jobs whose worker stopped heartbeating, returning the
+// number requeued and the number failed.
+type LeaseReaper interface {
+	ReapExpiredJobs(ctx context.Context) (int, int, error)
+}
+
+// PackageStore persists extracted package data. StoreResults writes every
+// result atomically and sets each Package.ID.
+type PackageStore interface {
+	StoreResults(ctx context.Context, results []models.PackageResult) error
+}
+
+// DownloadStore is used to refresh download counts of already stored
+// packages.
+type DownloadStore interface {
+	TopPackages(ctx context.Context, limit int) ([]string, error)
+	UpdateDownloads(ctx context.Context, packages []models.Package) error
+}
+
+// ScriptStore pages through stored install scripts and replaces their
+// findings.
+type ScriptStore interface {
+	ListScripts(ctx context.Context, after uuid.UUID, limit int) ([]models.PackageScript, error)
+	ReplaceFindings(ctx context.Context, scripts []models.PackageScript) error
+}
+
+// RetentionStore moves finished jobs out of the live queue. PruneJobs moves
+// or deletes up to limit jobs with status that finished more than olderThan
+// ago and returns how many it removed. Archived jobs need the monthly
+// partitions returned by ArchiveMonths to exist first; ArchivePartitions
+// lists the ones that do.
+type RetentionStore interface {
+	ArchiveMonths(ctx context.Context, status string, olderThan time.Duration) ([]time.Time, error)
+	ArchivePartitions(ctx context.Context) ([]string, error)
+	EnsureArchivePartition(ctx context.Context, month time.Time) error
+	PruneJobs(ctx context.Context, status string, olderThan time.Duration, limit int, archive bool) (int, error)
+	DropArchivePartitions(ctx context.Context, olderThan time.Duration) ([]string, error)
+}
+
+// Wakeups signals idle workers that new jobs may be claimable. Wait returns
+// a channel closed on the next signal for one of jobTypes, or for any type if
+// jobTypes is empty.
+type Wakeups interface {
+	Wait(jobTypes []string) <-chan struct{}
+}
+
+// PoolSource returns the desired worker pools. It is re-read periodically so
+// that pools can be resized at runtime.
+type PoolSource interface {
+	ListWorkerPools(ctx context.Context) ([]PoolConfig, error)
+}
@@ -0,0 +1,89 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"scrapeNPM/internal/logging"
+)
+
+// JobQueueChannel is the channel the job_queue trigger notifies on when a
+// job becomes claimable.
+const JobQueueChannel = "job_queue"
+
+// Notifier holds a dedicated connection LISTENing on the job queue channel
+// and wakes the workers waiting for the job type carried by each
+// notification.
+type Notifier struct {
+	pool  *pgxpool.Pool
+	wakes *WakeSet
+}
+
+func NewNotifier(pool *pgxpool.Pool) *Notifier {
+	return &Notifier{pool: pool, wakes: NewWakeSet()}
+}
+
+// Wait returns a channel that is closed on the next notification for one of
+// jobTypes. Callers should obtain it before checking for work so that a
+// notification arriving in between is not missed.
+func (n *Notifier) Wait(jobTypes []string) <-chan struct{} {
+	return n.wakes.Wait(jobTypes)
+}
+
+func (n *Notifier) Run(ctx context.Context) {
+	backoff := time.Second
+	for {
+		connected, err := n.listen(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			backoff = time.Second
+		}
+
+		slog.Warn("Job queue listener disconnected, reconnecting", "backoff", backoff, logging.Err(err))
+
+		// Wake workers so anything enqueued while we were deaf is picked up.
+		n.wakes.NotifyAll()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff < 30*time.Second {
+			backoff *= 2
+		}
+	}
+}
+
+func (n *Notifier) listen(ctx context.Context) (bool, error) {
+	conn, err := n.pool.Acquire(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+JobQueueChannel); err != nil {
+		return false, err
+	}
+
+	slog.Info("Listening for job queue notifications", "channel", JobQueueChannel)
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			// The connection may be mid-wait; don't hand it back to the pool.
+			conn.Conn().Close(context.Background())
+			return true, err
+		}
+		// The trigger sends the job type as the payload.
+		n.wakes.Notify(notification.Payload)
+	}
+}
+
+var _ Wakeups = (*Notifier)(nil)
@@ -0,0 +1,270 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"scrapeNPM/internal/logging"
+)
+
+// PoolConfig sizes the workers dedicated to a set of job types. A pool with
+// no job types takes every registered type not assigned to another pool.
+type PoolConfig struct {
+	Name      string
+	JobTypes  []string
+	Size      int
+	BatchSize int
+}
+
+// DefaultPools is used when no pools can be loaded: a single pool of ten
+// workers taking every job type.
+func DefaultPools() []PoolConfig {
+	return []PoolConfig{
+		{Name: "default", Size: 10, BatchSize: DefaultConfig().BatchSize},
+	}
+}
+
+type pool struct {
+	config  PoolConfig
+	workers []*poolWorker
+}
+
+type poolWorker struct {
+	worker *Worker
+	stop   chan struct{}
+}
+
+// Supervisor runs a pool of workers per PoolConfig and periodically re-reads
+// the pools from its source, starting or stopping workers to match. Workers
+// removed by a resize finish their current batch before exiting.
+type Supervisor struct {
+	deps       Dependencies
+	source     PoolSource
+	interval   time.Duration
+	shutdownCh <-chan struct{}
+
+	mu     sync.Mutex
+	pools  map[string]*pool
+	nextID int
+	wg     sync.WaitGroup
+
+	// connLimit is how many database connections workers can use at once,
+	// and warnedAt the worker count last warned about.
+	connLimit int
+	warnedAt  int
+}
+
+func NewSupervisor(deps Dependencies, source PoolSource, interval time.Duration, shutdownCh <-chan struct{}) *Supervisor {
+	return &Supervisor{
+		deps:       deps,
+		source:     source,
+		interval:   interval,
+		shutdownCh: shutdownCh,
+		pools:      make(map[string]*pool),
+	}
+}
+
+// Run keeps the pools in line with the source until ctx is cancelled or
+// shutdown is signalled, then stops every worker and waits for them.
+func (s *Supervisor) Run(ctx context.Context) {
+	slog.Info("Starting worker pool supervisor", "interval", s.interval)
+
+	configs, err := s.source.ListWorkerPools(ctx)
+	if err != nil || len(configs) == 0 {
+		if err != nil {
+			slog.Error("Error loading worker pools, using defaults", logging.Err(err))
+		}
+		configs = DefaultPools()
+	}
+	s.apply(ctx, configs)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.stopAll()
+			return
+		case <-s.shutdownCh:
+			s.stopAll()
+			return
+		case <-ticker.C:
+			configs, err := s.source.ListWorkerPools(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error reloading worker pools", logging.Err(err))
+				}
+				continue
+			}
+			s.apply(ctx, configs)
+		}
+	}
+}
+
+// apply resizes existing pools, starts new ones and stops removed ones.
+// Pools whose job types or batch size changed are restarted.
+func (s *Supervisor) apply(ctx context.Context, configs []PoolConfig) {
+	resolved := s.resolve(configs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, p := range s.pools {
+		if _, ok := resolved[name]; !ok {
+			slog.Info("Pool removed, stopping workers", logging.KeyPool, name, "workers", len(p.workers))
+			s.resize(ctx, p, 0)
+			delete(s.pools, name)
+		}
+	}
+
+	for name, cfg := range resolved {
+		p, ok := s.pools[name]
+		if !ok {
+			p = &pool{config: cfg}
+			s.pools[name] = p
+			slog.Info("Starting pool", logging.KeyPool, name, "workers", cfg.Size, "job_types", cfg.JobTypes)
+		} else if p.config.BatchSize != cfg.BatchSize || !sameTypes(p.config.JobTypes, cfg.JobTypes) {
+			slog.Info("Pool configuration changed, restarting workers", logging.KeyPool, name, "job_types", cfg.JobTypes)
+			s.resize(ctx, p, 0)
+		} else if len(p.workers) != cfg.Size {
+			slog.Info("Resizing pool", logging.KeyPool, name, "from", len(p.workers), "to", cfg.Size)
+		}
+
+		p.config = cfg
+		s.resize(ctx, p, cfg.Size)
+	}
+
+	s.checkConnections()
+}
+
+// SetConnectionLimit makes the supervisor warn whenever the pools add up to
+// more workers than there are database connections for them.
+func (s *Supervisor) SetConnectionLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.connLimit = n
+}
+
+func (s *Supervisor) checkConnections() {
+	total := 0
+	for _, p := range s.pools {
+		total += len(p.workers)
+	}
+
+	if s.connLimit <= 0 || total <= s.connLimit {
+		s.warnedAt = 0
+		return
+	}
+	if total != s.warnedAt {
+		slog.Warn("Worker pools exceed database connections, workers will wait for connections to claim jobs",
+			"workers", total, "connections", s.connLimit)
+		s.warnedAt = total
+	}
+}
+
+// resolve fills in the job types of catch-all pools from the handler
+// registry. A catch-all pool left with no types runs no workers.
+func (s *Supervisor) resolve(configs []PoolConfig) map[string]PoolConfig {
+	assigned := make(map[string]bool)
+	for _, cfg := range configs {
+		for _, t := range cfg.JobTypes {
+			assigned[t] = true
+		}
+	}
+
+	var remaining []string
+	if s.deps.Handlers != nil {
+		for _, t := range s.deps.Handlers.Types() {
+			if !assigned[t] {
+				remaining = append(remaining, t)
+			}
+		}
+	}
+
+	resolved := make(map[string]PoolConfig, len(configs))
+	for _, cfg := range configs {
+		if cfg.BatchSize < 1 {
+			cfg.BatchSize = DefaultConfig().BatchSize
+		}
+		if len(cfg.JobTypes) == 0 {
+			cfg.JobTypes = remaining
+			if len(remaining) == 0 {
+				cfg.Size = 0
+			}
+		}
+		types := append([]string(nil), cfg.JobTypes...)
+		sort.Strings(types)
+		cfg.JobTypes = types
+		resolved[cfg.Name] = cfg
+	}
+	return resolved
+}
+
+func (s *Supervisor) resize(ctx context.Context, p *pool, size int) {
+	for len(p.workers) > size {
+		last := len(p.workers) - 1
+		close(p.workers[last].stop)
+		p.workers = p.workers[:last]
+	}
+
+	for len(p.workers) < size {
+		stop := make(chan struct{})
+		config := Config{BatchSize: p.config.BatchSize, JobTypes: p.config.JobTypes}
+		worker := NewWorker(s.nextID, config, s.deps, stop)
+		s.nextID++
+		p.workers = append(p.workers, &poolWorker{worker: worker, stop: stop})
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			worker.Start(ctx)
+		}()
+	}
+}
+
+// StalledWorkers returns the IDs of running workers that have neither
+// started a loop iteration nor had a heartbeat within timeout.
+func (s *Supervisor) StalledWorkers(timeout time.Duration) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stalled []int
+	for _, p := range s.pools {
+		for _, pw := range p.workers {
+			if time.Since(pw.worker.LastActive()) > timeout {
+				stalled = append(stalled, pw.worker.id)
+			}
+		}
+	}
+	return stalled
+}
+
+func (s *Supervisor) stopAll() {
+	s.mu.Lock()
+	for _, p := range s.pools {
+		for _, pw := range p.workers {
+			close(pw.stop)
+		}
+		p.workers = nil
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	slog.Info("Worker pool supervisor stopped")
+}
+
+func sameTypes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+)
+
+// pruneBatchSize is how many jobs are moved per statement, keeping each
+// transaction short while workers keep claiming.
+const pruneBatchSize = 5000
+
+const day = 24 * time.Hour
+
+// PruneJobsHandler applies the job queue retention policy in its payload.
+// Unless ddl is set it never creates or drops archive partitions, leaving
+// them to `migrate partitions`, and fails if one it needs is missing.
+type PruneJobsHandler struct {
+	store RetentionStore
+	ddl   bool
+}
+
+func NewPruneJobsHandler(store RetentionStore, ddl bool) *PruneJobsHandler {
+	return &PruneJobsHandler{store: store, ddl: ddl}
+}
+
+func (h *PruneJobsHandler) Spec() jobs.Spec {
+	return jobs.Spec{
+		Type:        jobs.TypePruneJobs,
+		NewPayload:  func() jobs.Payload { return &jobs.PruneJobsPayload{} },
+		Concurrency: 1,
+		Timeout:     time.Hour,
+		Retry:       jobs.DefaultRetryPolicy(),
+	}
+}
+
+func (h *PruneJobsHandler) Handle(ctx context.Context, job *models.Job, payload jobs.Payload) (*jobs.Result, error) {
+	policy := payload.(*jobs.PruneJobsPayload)
+
+	retention := map[string]int{
+		"completed": policy.CompletedDays,
+		"failed":    policy.FailedDays,
+		"cancelled": policy.FailedDays,
+	}
+	for _, status := range []string{"completed", "failed", "cancelled"} {
+		days := retention[status]
+		if days == 0 {
+			continue
+		}
+
+		pruned, err := h.prune(ctx, status, time.Duration(days)*day, policy.Archive)
+		if pruned > 0 {
+			msg := "Deleted old jobs"
+			if policy.Archive {
+				msg = "Archived old jobs"
+			}
+			logging.FromContext(ctx).Info(msg, "jobs", pruned, "status", status, "older_than_days", days)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if policy.Archive && policy.ArchiveDays > 0 && h.ddl {
+		dropped, err := h.store.DropArchivePartitions(ctx, time.Duration(policy.ArchiveDays)*day)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range dropped {
+			logging.FromContext(ctx).Info("Dropped job archive partition", "partition", name)
+		}
+	}
+
+	return &jobs.Result{}, nil
+}
+
+func (h *PruneJobsHandler) prune(ctx context.Context, status string, olderThan time.Duration, archive bool) (int, error) {
+	if archive {
+		months, err := h.store.ArchiveMonths(ctx, status, olderThan)
+		if err != nil {
+			return 0, err
+		}
+		if err := h.ensurePartitions(ctx, months); err != nil {
+			return 0, err
+		}
+	}
+
+	var total int
+	for {
+		n, err := h.store.PruneJobs(ctx, status, olderThan, pruneBatchSize, archive)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < pruneBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// ensurePartitions creates the archive partitions for months, or without
+// ddl checks that they already exist.
+func (h *PruneJobsHandler) ensurePartitions(ctx context.Context, months []time.Time) error {
+	if h.ddl {
+		for _, month := range months {
+			if err := h.store.EnsureArchivePartition(ctx, month); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	names, err := h.store.ArchivePartitions(ctx)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, month := range months {
+		if name, _, _ := archivePartition(month); !existing[name] {
+			return fmt.Errorf("archive partition %s does not exist; create it with `scraper migrate partitions`", name)
+		}
+	}
+	return nil
+}
+
+var _ jobs.Handler = (*PruneJobsHandler)(nil)
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/models"
+)
+
+// fakeRetention reports one month of jobs to archive and records the
+// partition DDL it is asked to run.
+type fakeRetention struct {
+	partitions []string
+	ensured    []time.Time
+	dropped    bool
+	pruned     int
+}
+
+func (s *fakeRetention) ArchiveMonths(ctx context.Context, status string, olderThan time.Duration) ([]time.Time, error) {
+	return []time.Time{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}, nil
+}
+
+func (s *fakeRetention) ArchivePartitions(ctx context.Context) ([]string, error) {
+	return s.partitions, nil
+}
+
+func (s *fakeRetention) EnsureArchivePartition(ctx context.Context, month time.Time) error {
+	s.ensured = append(s.ensured, month)
+	return nil
+}
+
+func (s *fakeRetention) PruneJobs(ctx context.Context, status string, olderThan time.Duration, limit int, archive bool) (int, error) {
+	s.pruned++
+	return 0, nil
+}
+
+func (s *fakeRetention) DropArchivePartitions(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	s.dropped = true
+	return nil, nil
+}
+
+func TestPruneJobsPartitionDDL(t *testing.T) {
+	policy := &jobs.PruneJobsPayload{CompletedDays: 7, Archive: true, ArchiveDays: 180}
+
+	tests := []struct {
+		name       string
+		ddl        bool
+		partitions []string
+		wantErr    string
+	}{
+		{name: "auto creates and drops", ddl: true},
+		{name: "external with partition", partitions: []string{"job_queue_archive_y2024m03"}},
+		{name: "external without partition", wantErr: "archive partition job_queue_archive_y2024m03 does not exist"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeRetention{partitions: tt.partitions}
+			_, err := NewPruneJobsHandler(store, tt.ddl).Handle(context.Background(), &models.Job{}, policy)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Handle() error = %v, want %q", err, tt.wantErr)
+				}
+				if store.pruned > 0 {
+					t.Error("jobs were pruned without an archive partition")
+				}
+			} else if err != nil {
+				t.Fatalf("Handle() error = %v", err)
+			}
+
+			if tt.ddl != (len(store.ensured) > 0) || tt.ddl != store.dropped {
+				t.Errorf("with ddl %v: ensured %v, dropped %v", tt.ddl, store.ensured, store.dropped)
+			}
+		})
+	}
+}
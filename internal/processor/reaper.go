@@ -0,0 +1,52 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"scrapeNPM/internal/logging"
+)
+
+// Reaper periodically returns jobs with expired leases to the queue so that
+// work claimed by a crashed or timed-out process is not lost.
+type Reaper struct {
+	store    LeaseReaper
+	interval time.Duration
+}
+
+func NewReaper(store LeaseReaper, interval time.Duration) *Reaper {
+	return &Reaper{store: store, interval: interval}
+}
+
+func (r *Reaper) Run(ctx context.Context) {
+	slog.Info("Starting job lease reaper", "interval", r.interval)
+
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		r.reap(ctx)
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Job lease reaper stopping")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	requeued, failed, err := r.store.ReapExpiredJobs(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error reaping expired jobs", logging.Err(err))
+		}
+		return
+	}
+
+	if requeued > 0 || failed > 0 {
+		slog.Warn("Recovered abandoned jobs", "requeued", requeued, "failed", failed)
+	}
+}
@@ -0,0 +1,92 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+)
+
+// refreshFlushSize is how many refreshed counts are written at a time, so
+// that a long refresh cut short keeps most of its progress.
+const refreshFlushSize = 100
+
+// RefreshDownloadsHandler re-fetches download counts for the most downloaded
+// stored packages, which otherwise only change when a package is republished.
+type RefreshDownloadsHandler struct {
+	store     DownloadStore
+	downloads DownloadStatsSource
+	extractor *Extractor
+}
+
+func NewRefreshDownloadsHandler(store DownloadStore, downloads DownloadStatsSource) *RefreshDownloadsHandler {
+	return &RefreshDownloadsHandler{
+		store:     store,
+		downloads: downloads,
+		extractor: NewExtractor(),
+	}
+}
+
+func (h *RefreshDownloadsHandler) Spec() jobs.Spec {
+	return jobs.Spec{
+		Type:        jobs.TypeRefreshDownloads,
+		NewPayload:  func() jobs.Payload { return &jobs.RefreshDownloadsPayload{} },
+		Concurrency: 1,
+		Timeout:     2 * time.Hour,
+		Retry:       jobs.DefaultRetryPolicy(),
+	}
+}
+
+func (h *RefreshDownloadsHandler) Handle(ctx context.Context, job *models.Job, payload jobs.Payload) (*jobs.Result, error) {
+	top := payload.(*jobs.RefreshDownloadsPayload).Top
+
+	names, err := h.store.TopPackages(ctx, top)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx)
+	logger.Info("Refreshing download counts", "packages", len(names))
+
+	var pending []models.Package
+	var refreshed, skipped int
+	for _, name := range names {
+		downloads, err := h.downloads.GetDownloadCount(ctx, name)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Warn("Failed to refresh download count", logging.KeyPackage, name, logging.Err(err))
+			skipped++
+			continue
+		}
+
+		pending = append(pending, models.Package{
+			Name:            name,
+			Downloads:       downloads,
+			PopularityScore: h.extractor.CalculatePopularityScore(downloads),
+		})
+
+		if len(pending) >= refreshFlushSize {
+			if err := h.store.UpdateDownloads(ctx, pending); err != nil {
+				return nil, err
+			}
+			refreshed += len(pending)
+			pending = pending[:0]
+		}
+	}
+
+	if len(pending) > 0 {
+		if err := h.store.UpdateDownloads(ctx, pending); err != nil {
+			return nil, err
+		}
+		refreshed += len(pending)
+	}
+
+	logger.Info("Refreshed download counts", "updated", refreshed, "skipped", skipped)
+	return &jobs.Result{}, nil
+}
+
+var _ jobs.Handler = (*RefreshDownloadsHandler)(nil)
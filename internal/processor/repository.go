@@ -5,199 +5,1120 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 
+	"scrapeNPM/internal/metrics"
 	"scrapeNPM/internal/models"
 )
 
+// DefaultLeaseDuration is how long a claimed job stays owned by its worker
+// without a heartbeat before the reaper hands it back to the queue.
+const DefaultLeaseDuration = 2 * time.Minute
+
 type Repository struct {
-	db *pgxpool.Pool
+	db            *pgxpool.Pool
+	leaseDuration time.Duration
 }
 
 func NewRepository(db *pgxpool.Pool) *Repository {
-	return &Repository{db: db}
+	return &Repository{db: db, leaseDuration: DefaultLeaseDuration}
 }
 
-func (r *Repository) StorePackage(ctx context.Context, pkg models.Package) (uuid.UUID, error) {
+// StoreResults upserts every package and its scripts, maintainers and
+// versions in a single round trip, recording and returning in each result's
+// Events the ownership changes this reveals. Maintainer changes are only
+// recorded for packages stored before, and new publishers for packages with
+// versions stored before, so the first fetch of a package is quiet. Packages
+// are written in name order so concurrent batches touching the same packages
+// can't deadlock.
+func (r *Repository) StoreResults(ctx context.Context, results []models.PackageResult) error {
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	var packageID uuid.UUID
-
-	err = tx.QueryRow(ctx, `
-        SELECT id FROM packages WHERE name = $1
-    `, pkg.Name).Scan(&packageID)
-
+	stored, err := storedPackages(ctx, tx, results)
 	if err != nil {
-		if err != pgx.ErrNoRows {
-			return uuid.Nil, fmt.Errorf("failed to check if package exists: %w", err)
-		}
+		return err
+	}
 
-		err = tx.QueryRow(ctx, `
+	order := make([]int, len(results))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool {
+		return results[order[a]].Package.Name < results[order[b]].Package.Name
+	})
+
+	batch := &pgx.Batch{}
+	for _, i := range order {
+		pkg := results[i].Package
+		batch.Queue(`
             INSERT INTO packages (
                 name, version, description, author, homepage, repository,
                 license, created_at, updated_at, downloads, popularity_score, last_updated
             ) VALUES (
                 $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW()
-            ) RETURNING id
-        `, pkg.Name, pkg.Version, pkg.Description, pkg.Author, pkg.Homepage, pkg.Repository,
-			pkg.License, pkg.CreatedAt, pkg.UpdatedAt, pkg.Downloads, pkg.PopularityScore).Scan(&packageID)
-
-		if err != nil {
-			return uuid.Nil, fmt.Errorf("failed to insert package: %w", err)
-		}
-	} else {
-		_, err = tx.Exec(ctx, `
-            UPDATE packages SET
-                version = $2,
-                description = $3,
-                author = $4,
-                homepage = $5,
-                repository = $6,
-                license = $7,
-                created_at = $8,
-                updated_at = $9,
-                downloads = $10,
-                popularity_score = $11,
+            ) ON CONFLICT (name) DO UPDATE SET
+                version = EXCLUDED.version,
+                description = EXCLUDED.description,
+                author = EXCLUDED.author,
+                homepage = EXCLUDED.homepage,
+                repository = EXCLUDED.repository,
+                license = EXCLUDED.license,
+                created_at = EXCLUDED.created_at,
+                updated_at = EXCLUDED.updated_at,
+                downloads = EXCLUDED.downloads,
+                popularity_score = EXCLUDED.popularity_score,
                 last_updated = NOW()
-            WHERE id = $1
-        `, packageID, pkg.Version, pkg.Description, pkg.Author, pkg.Homepage, pkg.Repository,
+            RETURNING id
+        `, pkg.Name, pkg.Version, pkg.Description, pkg.Author, pkg.Homepage, pkg.Repository,
 			pkg.License, pkg.CreatedAt, pkg.UpdatedAt, pkg.Downloads, pkg.PopularityScore)
 
-		if err != nil {
-			return uuid.Nil, fmt.Errorf("failed to update package: %w", err)
+		for _, script := range results[i].Scripts {
+			batch.Queue(`
+                INSERT INTO package_scripts (
+                    package_id, script_type, content, created_at, updated_at
+                )
+                SELECT id, $2, $3, NOW(), NOW() FROM packages WHERE name = $1
+                ON CONFLICT (package_id, script_type) DO UPDATE SET
+                    content = EXCLUDED.content,
+                    updated_at = NOW()
+                RETURNING id
+            `, pkg.Name, script.ScriptType, script.Content)
+
+			rules, severities, matches := findingColumns(script.Findings)
+			batch.Queue(`
+                WITH script AS (
+                    SELECT ps.id
+                    FROM package_scripts ps
+                    JOIN packages p ON p.id = ps.package_id
+                    WHERE p.name = $1 AND ps.script_type = $2
+                ), cleared AS (
+                    DELETE FROM script_findings WHERE script_id IN (SELECT id FROM script)
+                    RETURNING rule
+                ), inserted AS (
+                    INSERT INTO script_findings (script_id, rule, severity, match)
+                    SELECT script.id, f.rule, f.severity, f.match
+                    FROM script, unnest($3::text[], $4::text[], $5::text[]) AS f(rule, severity, match)
+                    RETURNING rule, severity
+                )
+                SELECT rule, severity FROM inserted
+                WHERE rule NOT IN (SELECT rule FROM cleared)
+            `, pkg.Name, script.ScriptType, rules, severities, matches)
+		}
+
+		// Maintainers are diffed even when there are none left, so that
+		// removing the last one is recorded.
+		names, emails := maintainerColumns(results[i].Maintainers)
+		batch.Queue(`
+            WITH pkg AS (
+                SELECT id FROM packages WHERE name = $1
+            ), removed AS (
+                DELETE FROM package_maintainers
+                WHERE package_id = (SELECT id FROM pkg) AND name <> ALL($2::text[])
+                RETURNING name
+            ), added AS (
+                INSERT INTO package_maintainers (package_id, name, email)
+                SELECT pkg.id, m.name, NULLIF(m.email, '')
+                FROM pkg, unnest($2::text[], $3::text[]) AS m(name, email)
+                ON CONFLICT (package_id, name) DO UPDATE SET email = EXCLUDED.email
+                RETURNING name, (xmax = 0) AS inserted
+            )
+            INSERT INTO package_events (package_id, event_type, account)
+            SELECT pkg.id, 'maintainer_added', added.name
+            FROM pkg, added
+            WHERE added.inserted AND $4::bool
+            UNION ALL
+            SELECT pkg.id, 'maintainer_removed', removed.name
+            FROM pkg, removed
+            RETURNING id, package_id, event_type, account, COALESCE(version, ''),
+                      has_install_scripts, created_at
+        `, pkg.Name, names, emails, stored[pkg.Name])
+
+		if versions := results[i].Versions; len(versions) > 0 {
+			cols := versionColumns(versions)
+			batch.Queue(`
+                WITH pkg AS (
+                    SELECT id FROM packages WHERE name = $1
+                ), seen AS (
+                    SELECT EXISTS (
+                        SELECT 1 FROM package_versions WHERE package_id = (SELECT id FROM pkg)
+                    ) AS seen
+                ), inserted AS (
+                    INSERT INTO package_versions (
+                        package_id, version, published_at, publisher_name, publisher_email, has_install_scripts
+                    )
+                    SELECT pkg.id, v.version, NULLIF(v.published_at, '0001-01-01 00:00:00+00'::timestamptz),
+                           NULLIF(v.publisher, ''), NULLIF(v.email, ''), v.scripts
+                    FROM pkg, unnest($2::text[], $3::timestamptz[], $4::text[], $5::text[], $6::bool[])
+                        AS v(version, published_at, publisher, email, scripts)
+                    ON CONFLICT (package_id, version) DO NOTHING
+                    RETURNING version, published_at, publisher_name, has_install_scripts
+                ), first_publish AS (
+                    SELECT DISTINCT ON (i.publisher_name) i.publisher_name, i.version, i.has_install_scripts
+                    FROM inserted i
+                    WHERE i.publisher_name IS NOT NULL
+                      AND NOT EXISTS (
+                          SELECT 1 FROM package_versions pv
+                          WHERE pv.package_id = (SELECT id FROM pkg) AND pv.publisher_name = i.publisher_name
+                      )
+                    ORDER BY i.publisher_name, i.published_at NULLS LAST, i.version
+                )
+                INSERT INTO package_events (package_id, event_type, account, version, has_install_scripts)
+                SELECT pkg.id, 'new_publisher', f.publisher_name, f.version, f.has_install_scripts
+                FROM pkg, first_publish f, seen
+                WHERE seen.seen
+                RETURNING id, package_id, event_type, account, COALESCE(version, ''),
+                          has_install_scripts, created_at
+            `, pkg.Name, cols.versions, cols.published, cols.publishers, cols.emails, cols.scripts)
+		}
+	}
+
+	var newFindings []models.ScriptFinding
+	br := tx.SendBatch(ctx, batch)
+	for _, i := range order {
+		if err := br.QueryRow().Scan(&results[i].Package.ID); err != nil {
+			br.Close()
+			return fmt.Errorf("failed to upsert package %s: %w", results[i].Package.Name, err)
 		}
+
+		for j := range results[i].Scripts {
+			script := &results[i].Scripts[j]
+			if err := br.QueryRow().Scan(&script.ID); err != nil {
+				br.Close()
+				return fmt.Errorf("failed to store %s script for %s: %w",
+					script.ScriptType, results[i].Package.Name, err)
+			}
+			script.PackageID = results[i].Package.ID
+
+			added, err := scanFindings(br)
+			if err != nil {
+				br.Close()
+				return fmt.Errorf("failed to store findings for %s script of %s: %w",
+					script.ScriptType, results[i].Package.Name, err)
+			}
+			newFindings = append(newFindings, added...)
+			for k := range script.Findings {
+				script.Findings[k].ScriptID = script.ID
+			}
+		}
+
+		statements := 1
+		if len(results[i].Versions) > 0 {
+			statements++
+		}
+		results[i].Events = nil
+		for ; statements > 0; statements-- {
+			events, err := scanEvents(br, results[i].Package.Name)
+			if err != nil {
+				br.Close()
+				return fmt.Errorf("failed to store owners of %s: %w", results[i].Package.Name, err)
+			}
+			results[i].Events = append(results[i].Events, events...)
+		}
+	}
+
+	if err := br.Close(); err != nil {
+		return fmt.Errorf("failed to close batch: %w", err)
 	}
 
 	if err := tx.Commit(ctx); err != nil {
-		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return packageID, nil
+	countFindings(newFindings)
+	return nil
 }
 
-func (r *Repository) StoreScript(ctx context.Context, script models.PackageScript) error {
-	_, err := r.db.Exec(ctx, `
-        INSERT INTO package_scripts (
-            package_id, script_type, content, created_at, updated_at
-        ) VALUES (
-            $1, $2, $3, NOW(), NOW()
-        ) ON CONFLICT (package_id, script_type) DO UPDATE SET
-            content = $3,
-            updated_at = NOW()
-    `, script.PackageID, script.ScriptType, script.Content)
+// storedPackages returns which of the packages in results are already
+// stored. It must run before they are upserted.
+func storedPackages(ctx context.Context, tx pgx.Tx, results []models.PackageResult) (map[string]bool, error) {
+	names := make([]string, len(results))
+	for i := range results {
+		names[i] = results[i].Package.Name
+	}
+
+	rows, err := tx.Query(ctx, `SELECT name FROM packages WHERE name = ANY($1)`, names)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stored packages: %w", err)
+	}
+	defer rows.Close()
+
+	stored := make(map[string]bool, len(names))
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan stored package: %w", err)
+		}
+		stored[name] = true
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating stored packages: %w", rows.Err())
+	}
+
+	return stored, nil
+}
+
+// scanFindings reads the rule and severity of the findings a statement of a
+// batch added.
+func scanFindings(br pgx.BatchResults) ([]models.ScriptFinding, error) {
+	rows, err := br.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var findings []models.ScriptFinding
+	for rows.Next() {
+		var f models.ScriptFinding
+		if err := rows.Scan(&f.Rule, &f.Severity); err != nil {
+			return nil, err
+		}
+		findings = append(findings, f)
+	}
+	return findings, rows.Err()
+}
+
+// countFindings adds findings to the findings metric. Only findings a
+// script did not already have are counted, so re-analysing or re-fetching
+// unchanged scripts leaves it alone.
+func countFindings(findings []models.ScriptFinding) {
+	for _, f := range findings {
+		metrics.ScriptFindings.WithLabelValues(f.Rule, f.Severity).Inc()
+	}
+}
+
+// scanEvents reads the events recorded by one statement of a batch.
+func scanEvents(br pgx.BatchResults, packageName string) ([]models.PackageEvent, error) {
+	rows, err := br.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.PackageEvent
+	for rows.Next() {
+		event := models.PackageEvent{PackageName: packageName}
+		if err := rows.Scan(&event.ID, &event.PackageID, &event.Type, &event.Account, &event.Version,
+			&event.HasInstallScripts, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// maintainerColumns splits maintainers into parallel arrays for unnest.
+func maintainerColumns(maintainers []models.Maintainer) ([]string, []string) {
+	names := make([]string, len(maintainers))
+	emails := make([]string, len(maintainers))
+	for i, m := range maintainers {
+		names[i], emails[i] = m.Name, m.Email
+	}
+	return names, emails
+}
+
+type versionArrays struct {
+	versions   []string
+	published  []time.Time
+	publishers []string
+	emails     []string
+	scripts    []bool
+}
+
+// versionColumns splits versions into parallel arrays for unnest.
+func versionColumns(versions []models.PackageVersion) versionArrays {
+	cols := versionArrays{
+		versions:   make([]string, len(versions)),
+		published:  make([]time.Time, len(versions)),
+		publishers: make([]string, len(versions)),
+		emails:     make([]string, len(versions)),
+		scripts:    make([]bool, len(versions)),
+	}
+	for i, v := range versions {
+		cols.versions[i], cols.published[i] = v.Version, v.PublishedAt
+		cols.publishers[i], cols.emails[i] = v.Publisher.Name, v.Publisher.Email
+		cols.scripts[i] = v.HasInstallScripts
+	}
+	return cols
+}
 
+// findingColumns splits findings into parallel arrays for unnest.
+func findingColumns(findings []models.ScriptFinding) ([]string, []string, []string) {
+	rules := make([]string, len(findings))
+	severities := make([]string, len(findings))
+	matches := make([]string, len(findings))
+	for i, f := range findings {
+		rules[i], severities[i], matches[i] = f.Rule, f.Severity, f.Match
+	}
+	return rules, severities, matches
+}
+
+// TopPackages returns the names of the limit most downloaded packages.
+func (r *Repository) TopPackages(ctx context.Context, limit int) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT name
+        FROM packages
+        WHERE downloads IS NOT NULL
+        ORDER BY downloads DESC
+        LIMIT $1
+    `, limit)
 	if err != nil {
-		return fmt.Errorf("failed to store script: %w", err)
+		return nil, fmt.Errorf("failed to query top packages: %w", err)
 	}
+	defer rows.Close()
 
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan package name: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating top packages: %w", rows.Err())
+	}
+
+	return names, nil
+}
+
+// UpdateDownloads sets the download count and popularity score of each
+// package by name.
+func (r *Repository) UpdateDownloads(ctx context.Context, packages []models.Package) error {
+	names := make([]string, len(packages))
+	downloads := make([]int64, len(packages))
+	scores := make([]float64, len(packages))
+	for i, pkg := range packages {
+		names[i], downloads[i], scores[i] = pkg.Name, pkg.Downloads, pkg.PopularityScore
+	}
+
+	_, err := r.db.Exec(ctx, `
+        UPDATE packages p
+        SET downloads = u.downloads, popularity_score = u.score, last_updated = NOW()
+        FROM unnest($1::text[], $2::bigint[], $3::float8[]) AS u(name, downloads, score)
+        WHERE p.name = u.name
+    `, names, downloads, scores)
+	if err != nil {
+		return fmt.Errorf("failed to update downloads: %w", err)
+	}
 	return nil
 }
 
-func (r *Repository) ClaimJob(ctx context.Context, workerID string) (*models.Job, error) {
+// ListScripts returns up to limit scripts with IDs greater than after, in ID
+// order, for paging through every stored script.
+func (r *Repository) ListScripts(ctx context.Context, after uuid.UUID, limit int) ([]models.PackageScript, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT id, package_id, script_type, content, created_at, updated_at
+        FROM package_scripts
+        WHERE id > $1
+        ORDER BY id
+        LIMIT $2
+    `, after, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scripts: %w", err)
+	}
+	defer rows.Close()
+
+	var scripts []models.PackageScript
+	for rows.Next() {
+		var script models.PackageScript
+		var content sql.NullString
+		if err := rows.Scan(&script.ID, &script.PackageID, &script.ScriptType, &content,
+			&script.CreatedAt, &script.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan script: %w", err)
+		}
+		script.Content = content.String
+		scripts = append(scripts, script)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating scripts: %w", rows.Err())
+	}
+
+	return scripts, nil
+}
+
+// ReplaceFindings swaps the stored findings of each script for its current
+// Findings in one transaction.
+func (r *Repository) ReplaceFindings(ctx context.Context, scripts []models.PackageScript) error {
+	ids := make([]string, len(scripts))
+	for i, script := range scripts {
+		ids[i] = script.ID.String()
+	}
+
 	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback(ctx)
 
-	var job models.Job
-	var payloadJSON []byte
-	var errorMessage sql.NullString
-	var startedAt sql.NullTime
+	cleared, err := tx.Query(ctx, `
+        DELETE FROM script_findings WHERE script_id = ANY($1::uuid[])
+        RETURNING script_id, rule
+    `, ids)
+	if err != nil {
+		return fmt.Errorf("failed to clear findings: %w", err)
+	}
+
+	type finding struct {
+		scriptID uuid.UUID
+		rule     string
+	}
+	existing := make(map[finding]bool)
+	for cleared.Next() {
+		var f finding
+		if err := cleared.Scan(&f.scriptID, &f.rule); err != nil {
+			cleared.Close()
+			return fmt.Errorf("failed to scan cleared finding: %w", err)
+		}
+		existing[f] = true
+	}
+	cleared.Close()
+	if cleared.Err() != nil {
+		return fmt.Errorf("failed to clear findings: %w", cleared.Err())
+	}
 
-	err = tx.QueryRow(ctx, `
+	var rows [][]interface{}
+	var newFindings []models.ScriptFinding
+	for _, script := range scripts {
+		for _, f := range script.Findings {
+			rows = append(rows, []interface{}{script.ID, f.Rule, f.Severity, f.Match})
+			if !existing[finding{script.ID, f.Rule}] {
+				newFindings = append(newFindings, f)
+			}
+		}
+	}
+
+	if len(rows) > 0 {
+		if _, err := tx.CopyFrom(ctx,
+			pgx.Identifier{"script_findings"},
+			[]string{"script_id", "rule", "severity", "match"},
+			pgx.CopyFromRows(rows),
+		); err != nil {
+			return fmt.Errorf("failed to insert findings: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	countFindings(newFindings)
+	return nil
+}
+
+// ClaimJobs claims up to limit claimable jobs of the given types in one
+// statement, skipping rows already locked by other workers and job types
+// that are paused.
+func (r *Repository) ClaimJobs(ctx context.Context, workerID string, jobTypes []string, limit int) ([]*models.Job, error) {
+	rows, err := r.db.Query(ctx, `
         UPDATE job_queue 
         SET 
             status = 'processing', 
             started_at = NOW(), 
             worker_id = $1,
-            attempts = attempts + 1
-        WHERE id = (
+            attempts = attempts + 1,
+            lease_expires_at = NOW() + $2::interval
+        WHERE id IN (
             SELECT id 
             FROM job_queue 
             WHERE 
                 status = 'pending' 
                 AND next_attempt_after <= NOW() 
+                AND (COALESCE(cardinality($4::text[]), 0) = 0 OR job_type = ANY($4::text[]))
+                AND job_type NOT IN (SELECT job_type FROM paused_job_types)
             ORDER BY priority, created_at 
-            LIMIT 1
+            LIMIT $3
             FOR UPDATE SKIP LOCKED
         )
         RETURNING id, job_type, status, priority, payload, created_at, 
-                  started_at, attempts, max_attempts, error_message, next_attempt_after
-    `, workerID).Scan(
-		&job.ID, &job.Type, &job.Status, &job.Priority, &payloadJSON, &job.CreatedAt,
-		&startedAt, &job.Attempts, &job.MaxAttempts, &errorMessage, &job.NextAttemptAfter,
-	)
-
+                  started_at, attempts, max_attempts, error_message, next_attempt_after,
+                  lease_expires_at
+    `, workerID, r.leaseDuration, limit, jobTypes)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return nil, nil
+		return nil, fmt.Errorf("failed to claim jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []*models.Job
+	for rows.Next() {
+		var job models.Job
+		var payloadJSON []byte
+		var errorMessage sql.NullString
+		var startedAt sql.NullTime
+
+		if err := rows.Scan(
+			&job.ID, &job.Type, &job.Status, &job.Priority, &payloadJSON, &job.CreatedAt,
+			&startedAt, &job.Attempts, &job.MaxAttempts, &errorMessage, &job.NextAttemptAfter,
+			&job.LeaseExpiresAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan claimed job: %w", err)
 		}
-		return nil, fmt.Errorf("failed to claim job: %w", err)
+
+		if startedAt.Valid {
+			job.StartedAt = &startedAt.Time
+		}
+
+		if errorMessage.Valid {
+			job.ErrorMessage = errorMessage.String
+		}
+
+		if err := json.Unmarshal(payloadJSON, &job.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal job payload: %w", err)
+		}
+
+		jobs = append(jobs, &job)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating claimed jobs: %w", rows.Err())
+	}
+
+	return jobs, nil
+}
+
+// CompleteJobs marks completed the jobs still held by the worker that ran
+// them and copies those attempts into the history in one transaction. It
+// returns the IDs it completed; jobs cancelled or reclaimed after their
+// lease expired while they ran are left alone.
+func (r *Repository) CompleteJobs(ctx context.Context, attempts []models.JobAttempt) ([]uuid.UUID, error) {
+	ids := make([]string, len(attempts))
+	workerIDs := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		ids[i] = attempt.JobID.String()
+		workerIDs[i] = attempt.WorkerID
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+        UPDATE job_queue 
+        SET 
+            status = 'completed', 
+            completed_at = NOW(),
+            lease_expires_at = NULL
+        FROM unnest($1::uuid[], $2::text[]) AS a(id, worker_id)
+        WHERE job_queue.id = a.id 
+            AND job_queue.worker_id = a.worker_id 
+            AND job_queue.status = 'processing'
+        RETURNING job_queue.id
+    `, ids, workerIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete jobs: %w", err)
 	}
 
-	if startedAt.Valid {
-		job.StartedAt = &startedAt.Time
+	var completed []uuid.UUID
+	held := make(map[uuid.UUID]bool, len(attempts))
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan completed job: %w", err)
+		}
+		completed = append(completed, id)
+		held[id] = true
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating completed jobs: %w", rows.Err())
 	}
 
-	if errorMessage.Valid {
-		job.ErrorMessage = errorMessage.String
-	} else {
-		job.ErrorMessage = ""
+	var attemptRows [][]interface{}
+	for _, a := range attempts {
+		if !held[a.JobID] {
+			continue
+		}
+		attemptRows = append(attemptRows, []interface{}{
+			a.JobID, a.Attempt, a.WorkerID, a.StartedAt, a.FinishedAt, a.Duration.Milliseconds(), a.Outcome,
+		})
 	}
 
-	if err := json.Unmarshal(payloadJSON, &job.Payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal job payload: %w", err)
+	if len(attemptRows) > 0 {
+		_, err = tx.CopyFrom(ctx, pgx.Identifier{"job_attempts"},
+			[]string{"job_id", "attempt", "worker_id", "started_at", "finished_at", "duration_ms", "outcome"},
+			pgx.CopyFromRows(attemptRows))
+		if err != nil {
+			return nil, fmt.Errorf("failed to record job attempts: %w", err)
+		}
 	}
 
 	if err := tx.Commit(ctx); err != nil {
 		return nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return &job, nil
+	return completed, nil
 }
 
-func (r *Repository) CompleteJob(ctx context.Context, jobID uuid.UUID) error {
-	now := time.Now()
-	_, err := r.db.Exec(ctx, `
+// FailJob records a failed attempt and schedules the job's retry, if the
+// worker that made the attempt still holds the job. It reports whether it
+// did.
+func (r *Repository) FailJob(ctx context.Context, attempt models.JobAttempt, retryAfter time.Duration) (bool, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
         UPDATE job_queue 
         SET 
-            status = 'completed', 
-            completed_at = $2
-        WHERE id = $1
-    `, jobID, now)
+            status = CASE WHEN $4 OR attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+            error_message = $2,
+            error_class = $3,
+            lease_expires_at = NULL,
+            next_attempt_after = CASE WHEN $4 OR attempts >= max_attempts 
+                                THEN NULL 
+                                ELSE NOW() + $5::interval 
+                                END,
+            completed_at = CASE WHEN $4 OR attempts >= max_attempts THEN NOW() ELSE NULL END
+        WHERE id = $1 AND worker_id = $6 AND status = 'processing'
+    `, attempt.JobID, attempt.ErrorMessage, attempt.ErrorClass, retryAfter <= 0, retryAfter, attempt.WorkerID)
+
+	if err != nil {
+		return false, fmt.Errorf("failed to update failed job: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil
+	}
+
+	if err := recordAttempt(ctx, tx, attempt); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// ReleaseJobs returns jobs the worker holds to the queue without counting
+// the interrupted attempt or recording it in the history.
+func (r *Repository) ReleaseJobs(ctx context.Context, workerID string, jobIDs []uuid.UUID) (int, error) {
+	ids := make([]string, len(jobIDs))
+	for i, id := range jobIDs {
+		ids[i] = id.String()
+	}
+
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_queue
+        SET 
+            status = 'pending',
+            attempts = GREATEST(attempts - 1, 0),
+            worker_id = NULL,
+            started_at = NULL,
+            lease_expires_at = NULL,
+            next_attempt_after = NOW()
+        WHERE id = ANY($1::uuid[]) AND worker_id = $2 AND status = 'processing'
+    `, ids, workerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to release jobs: %w", err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func recordAttempt(ctx context.Context, tx pgx.Tx, attempt models.JobAttempt) error {
+	_, err := tx.Exec(ctx, `
+        INSERT INTO job_attempts (
+            job_id, attempt, worker_id, started_at, finished_at, duration_ms,
+            outcome, error_class, http_status, error_message
+        ) VALUES (
+            $1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NULLIF($9, 0), NULLIF($10, '')
+        )
+    `, attempt.JobID, attempt.Attempt, attempt.WorkerID, attempt.StartedAt, attempt.FinishedAt,
+		attempt.Duration.Milliseconds(), attempt.Outcome, attempt.ErrorClass, attempt.HTTPStatus,
+		attempt.ErrorMessage)
 
 	if err != nil {
-		return fmt.Errorf("failed to complete job: %w", err)
+		return fmt.Errorf("failed to record job attempt: %w", err)
 	}
 
 	return nil
 }
 
-func (r *Repository) FailJob(ctx context.Context, jobID uuid.UUID, errorMsg string) error {
+// SetLeaseDuration changes the lease given to claimed jobs, and so how often
+// workers heartbeat.
+func (r *Repository) SetLeaseDuration(d time.Duration) {
+	r.leaseDuration = d
+}
+
+func (r *Repository) LeaseDuration() time.Duration {
+	return r.leaseDuration
+}
+
+func (r *Repository) ExtendLeases(ctx context.Context, workerID string, jobIDs []uuid.UUID) ([]uuid.UUID, error) {
+	ids := make([]string, len(jobIDs))
+	for i, id := range jobIDs {
+		ids[i] = id.String()
+	}
+
+	rows, err := r.db.Query(ctx, `
+        UPDATE job_queue
+        SET lease_expires_at = NOW() + $3::interval
+        WHERE id = ANY($1::uuid[]) AND worker_id = $2 AND status = 'processing'
+        RETURNING id
+    `, ids, workerID, r.leaseDuration)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extend job leases: %w", err)
+	}
+	defer rows.Close()
+
+	var held []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan job lease: %w", err)
+		}
+		held = append(held, id)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating job leases: %w", rows.Err())
+	}
+
+	return held, nil
+}
+
+// ReapExpiredJobs returns jobs whose lease has run out to the queue, or
+// fails them if the abandoned attempt was their last one. The attempt was
+// already counted when the job was claimed; it is recorded in the history
+// as lease_expired.
+func (r *Repository) ReapExpiredJobs(ctx context.Context) (int, int, error) {
+	rows, err := r.db.Query(ctx, `
+        WITH reaped AS (
+            UPDATE job_queue
+            SET
+                status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
+                error_message = 'lease expired while processing on ' || COALESCE(worker_id, 'unknown worker'),
+                error_class = $1,
+                next_attempt_after = CASE WHEN attempts >= max_attempts THEN NULL ELSE NOW() END,
+                completed_at = CASE WHEN attempts >= max_attempts THEN NOW() ELSE NULL END,
+                lease_expires_at = NULL
+            WHERE status = 'processing' AND lease_expires_at < NOW()
+            RETURNING id, attempts, worker_id, started_at, status, error_message
+        ), recorded AS (
+            INSERT INTO job_attempts (
+                job_id, attempt, worker_id, started_at, finished_at, duration_ms,
+                outcome, error_class, error_message
+            )
+            SELECT
+                id, attempts, worker_id, COALESCE(started_at, NOW()), NOW(),
+                (EXTRACT(EPOCH FROM NOW() - COALESCE(started_at, NOW())) * 1000)::BIGINT,
+                'lease_expired', $1, error_message
+            FROM reaped
+        )
+        SELECT status FROM reaped
+    `, ErrorClassLeaseExpired)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to reap expired jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var requeued, failed int
+	for rows.Next() {
+		var status string
+		if err := rows.Scan(&status); err != nil {
+			return 0, 0, fmt.Errorf("failed to scan reaped job: %w", err)
+		}
+		if status == "failed" {
+			failed++
+		} else {
+			requeued++
+		}
+	}
+
+	if rows.Err() != nil {
+		return 0, 0, fmt.Errorf("error iterating reaped jobs: %w", rows.Err())
+	}
+
+	return requeued, failed, nil
+}
+
+func (r *Repository) ListWorkerPools(ctx context.Context) ([]PoolConfig, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT name, job_types, size, batch_size
+        FROM worker_pools
+        ORDER BY name
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query worker pools: %w", err)
+	}
+	defer rows.Close()
+
+	var pools []PoolConfig
+	for rows.Next() {
+		var p PoolConfig
+		if err := rows.Scan(&p.Name, &p.JobTypes, &p.Size, &p.BatchSize); err != nil {
+			return nil, fmt.Errorf("failed to scan worker pool: %w", err)
+		}
+		pools = append(pools, p)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating worker pools: %w", rows.Err())
+	}
+
+	return pools, nil
+}
+
+// PoolUpdate changes a worker pool. Nil fields keep their current value, or
+// the column default when the pool is new.
+type PoolUpdate struct {
+	JobTypes  []string
+	Size      *int
+	BatchSize *int
+}
+
+// SetWorkerPool creates or updates a pool. Running processes pick the change
+// up on their next reload.
+func (r *Repository) SetWorkerPool(ctx context.Context, name string, update PoolUpdate) error {
 	_, err := r.db.Exec(ctx, `
-        UPDATE job_queue 
-        SET 
-            status = CASE WHEN attempts >= max_attempts THEN 'failed' ELSE 'pending' END,
-            error_message = $2,
-            next_attempt_after = CASE WHEN attempts >= max_attempts 
-                                THEN NULL 
-                                ELSE NOW() + (POWER(2, attempts) * INTERVAL '1 minute') 
-                                END
-        WHERE id = $1
-    `, jobID, errorMsg)
+        INSERT INTO worker_pools (name, job_types, size, batch_size)
+        VALUES ($1, COALESCE($2::text[], '{}'), COALESCE($3::int, 1), COALESCE($4::int, 10))
+        ON CONFLICT (name) DO UPDATE SET
+            job_types = COALESCE($2::text[], worker_pools.job_types),
+            size = COALESCE($3::int, worker_pools.size),
+            batch_size = COALESCE($4::int, worker_pools.batch_size),
+            updated_at = NOW()
+    `, name, update.JobTypes, update.Size, update.BatchSize)
+	if err != nil {
+		return fmt.Errorf("failed to set worker pool: %w", err)
+	}
+	return nil
+}
+
+func (r *Repository) DeleteWorkerPool(ctx context.Context, name string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM worker_pools WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete worker pool: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
 
+// archivePartitionPrefix names the monthly partitions of job_queue_archive,
+// followed by yYYYYmMM.
+const archivePartitionPrefix = "job_queue_archive_"
+
+// archivePartition returns the name and UTC bounds of the archive partition
+// holding month, which is read as a UTC wall-clock time like the
+// TIMESTAMP columns it comes from.
+func archivePartition(month time.Time) (string, time.Time, time.Time) {
+	start := time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, time.UTC)
+	name := fmt.Sprintf("%sy%04dm%02d", archivePartitionPrefix, start.Year(), int(start.Month()))
+	return name, start, start.AddDate(0, 1, 0)
+}
+
+// archivePartitionEnd returns the exclusive upper bound of the archive
+// partition called name, or false if name is not an archive partition.
+func archivePartitionEnd(name string) (time.Time, bool) {
+	var year, month int
+	if _, err := fmt.Sscanf(name, archivePartitionPrefix+"y%04dm%02d", &year, &month); err != nil || month < 1 || month > 12 {
+		return time.Time{}, false
+	}
+	_, _, end := archivePartition(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC))
+	return end, true
+}
+
+// ArchiveMonths returns the months with jobs about to be archived, plus the
+// month of the cutoff itself for jobs that age past it mid-run. Months are
+// truncated in UTC whatever the session time zone.
+func (r *Repository) ArchiveMonths(ctx context.Context, status string, olderThan time.Duration) ([]time.Time, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT DISTINCT date_trunc('month', completed_at)
+        FROM job_queue
+        WHERE status = $1 AND completed_at < NOW() - $2::interval
+        UNION
+        SELECT date_trunc('month', (NOW() - $2::interval) AT TIME ZONE 'UTC')
+    `, status, olderThan)
 	if err != nil {
-		return fmt.Errorf("failed to update failed job: %w", err)
+		return nil, fmt.Errorf("failed to query archive months: %w", err)
+	}
+	defer rows.Close()
+
+	var months []time.Time
+	for rows.Next() {
+		var month time.Time
+		if err := rows.Scan(&month); err != nil {
+			return nil, fmt.Errorf("failed to scan archive month: %w", err)
+		}
+		months = append(months, month)
 	}
 
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating archive months: %w", rows.Err())
+	}
+
+	return months, nil
+}
+
+// EnsureArchivePartition creates the archive partition for month if it does
+// not exist yet.
+func (r *Repository) EnsureArchivePartition(ctx context.Context, month time.Time) error {
+	name, start, end := archivePartition(month)
+
+	_, err := r.db.Exec(ctx, fmt.Sprintf(`
+        CREATE TABLE IF NOT EXISTS %s PARTITION OF job_queue_archive
+        FOR VALUES FROM ('%s') TO ('%s')
+    `, pgx.Identifier{name}.Sanitize(), start.Format("2006-01-02"), end.Format("2006-01-02")))
+	if err != nil {
+		return fmt.Errorf("failed to create archive partition %s: %w", name, err)
+	}
 	return nil
 }
+
+// PruneJobs removes one batch of finished jobs. Archived jobs carry their
+// attempt history with them; either way the job_attempts rows are deleted
+// by the cascade.
+func (r *Repository) PruneJobs(ctx context.Context, status string, olderThan time.Duration, limit int, archive bool) (int, error) {
+	var pruned int
+	err := r.db.QueryRow(ctx, `
+        WITH moved AS (
+            DELETE FROM job_queue
+            WHERE id IN (
+                SELECT id FROM job_queue
+                WHERE status = $1 AND completed_at < NOW() - $2::interval
+                ORDER BY completed_at
+                LIMIT $3
+                FOR UPDATE SKIP LOCKED
+            )
+            RETURNING *
+        ), archived AS (
+            INSERT INTO job_queue_archive (
+                id, job_type, status, priority, payload, created_at, started_at,
+                finished_at, attempts, max_attempts, error_message, error_class,
+                worker_id, attempt_history
+            )
+            SELECT
+                m.id, m.job_type, m.status, m.priority, m.payload, m.created_at, m.started_at,
+                m.completed_at, m.attempts, m.max_attempts, m.error_message, m.error_class,
+                m.worker_id,
+                (
+                    SELECT COALESCE(jsonb_agg(to_jsonb(a) - 'job_id' ORDER BY a.attempt, a.id), '[]')
+                    FROM job_attempts a
+                    WHERE a.job_id = m.id
+                )
+            FROM moved m
+            WHERE $4
+        )
+        SELECT COUNT(*) FROM moved
+    `, status, olderThan, limit, archive).Scan(&pruned)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune %s jobs: %w", status, err)
+	}
+	return pruned, nil
+}
+
+// PrepareArchivePartitions creates the archive partitions for every month
+// from the oldest finished job in the queue, or the current month, through
+// ahead months from now, so that pruning never has to create one. It returns
+// the partitions it ensured.
+func (r *Repository) PrepareArchivePartitions(ctx context.Context, ahead int) ([]string, error) {
+	var from, now time.Time
+	err := r.db.QueryRow(ctx, `
+        SELECT
+            COALESCE(
+                (SELECT date_trunc('month', MIN(completed_at)) FROM job_queue WHERE completed_at IS NOT NULL),
+                date_trunc('month', NOW() AT TIME ZONE 'UTC')
+            ),
+            NOW() AT TIME ZONE 'UTC'
+    `).Scan(&from, &now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query archive range: %w", err)
+	}
+
+	_, _, last := archivePartition(now.AddDate(0, ahead, 0))
+	var names []string
+	for month := from; month.Before(last); month = month.AddDate(0, 1, 0) {
+		if err := r.EnsureArchivePartition(ctx, month); err != nil {
+			return names, err
+		}
+		name, _, _ := archivePartition(month)
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// ArchivePartitions returns the names of the existing archive partitions.
+func (r *Repository) ArchivePartitions(ctx context.Context) ([]string, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT c.relname
+        FROM pg_inherits i
+        JOIN pg_class c ON c.oid = i.inhrelid
+        WHERE i.inhparent = 'job_queue_archive'::regclass
+        ORDER BY c.relname
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list archive partitions: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan archive partition: %w", err)
+		}
+		names = append(names, name)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating archive partitions: %w", rows.Err())
+	}
+
+	return names, nil
+}
+
+// DropArchivePartitions drops every monthly archive partition whose whole
+// month finished more than olderThan ago and returns their names.
+func (r *Repository) DropArchivePartitions(ctx context.Context, olderThan time.Duration) ([]string, error) {
+	var cutoff time.Time
+	if err := r.db.QueryRow(ctx, `SELECT (NOW() - $1::interval) AT TIME ZONE 'UTC'`, olderThan).Scan(&cutoff); err != nil {
+		return nil, fmt.Errorf("failed to compute archive cutoff: %w", err)
+	}
+
+	names, err := r.ArchivePartitions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []string
+	for _, name := range names {
+		end, ok := archivePartitionEnd(name)
+		if ok && !end.After(cutoff) {
+			expired = append(expired, name)
+		}
+	}
+
+	for i, name := range expired {
+		if _, err := r.db.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s`, pgx.Identifier{name}.Sanitize())); err != nil {
+			return expired[:i], fmt.Errorf("failed to drop archive partition %s: %w", name, err)
+		}
+	}
+
+	return expired, nil
+}
+
+var (
+	_ JobStore       = (*Repository)(nil)
+	_ PackageStore   = (*Repository)(nil)
+	_ LeaseReaper    = (*Repository)(nil)
+	_ PoolSource     = (*Repository)(nil)
+	_ DownloadStore  = (*Repository)(nil)
+	_ ScriptStore    = (*Repository)(nil)
+	_ RetentionStore = (*Repository)(nil)
+)
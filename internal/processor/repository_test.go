@@ -0,0 +1,40 @@
+package processor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArchivePartition(t *testing.T) {
+	tests := []struct {
+		month      time.Time
+		name       string
+		start, end string
+	}{
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "job_queue_archive_y2024m03", "2024-03-01", "2024-04-01"},
+		{time.Date(2024, 12, 31, 23, 30, 0, 0, time.UTC), "job_queue_archive_y2024m12", "2024-12-01", "2025-01-01"},
+		// A month scanned from a TIMESTAMP column keeps its wall clock
+		// whatever location it carries.
+		{time.Date(2024, 3, 1, 0, 0, 0, 0, time.FixedZone("CET", 3600)), "job_queue_archive_y2024m03", "2024-03-01", "2024-04-01"},
+	}
+	for _, tt := range tests {
+		name, start, end := archivePartition(tt.month)
+		if name != tt.name || start.Format("2006-01-02") != tt.start || end.Format("2006-01-02") != tt.end {
+			t.Errorf("archivePartition(%v) = %s [%v, %v), want %s [%s, %s)", tt.month, name, start, end, tt.name, tt.start, tt.end)
+		}
+		if start.Location() != time.UTC {
+			t.Errorf("archivePartition(%v) start is in %v, want UTC", tt.month, start.Location())
+		}
+
+		got, ok := archivePartitionEnd(name)
+		if !ok || !got.Equal(end) {
+			t.Errorf("archivePartitionEnd(%s) = %v, %v, want %v", name, got, ok, end)
+		}
+	}
+
+	for _, name := range []string{"job_queue_archive_y2024m13", "job_queue_archive_default", "other_y2024m03"} {
+		if _, ok := archivePartitionEnd(name); ok {
+			t.Errorf("archivePartitionEnd accepted %s", name)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"scrapeNPM/internal/models"
+)
+
+// ScriptQuery selects stored install scripts. Pattern is a case-insensitive
+// POSIX regular expression matched against script content. Rule and
+// Severities keep scripts with a matching finding. Empty fields match
+// everything and a zero Limit returns every match.
+type ScriptQuery struct {
+	Pattern    string
+	ScriptType string
+	Rule       string
+	Severities []string
+	Limit      int
+}
+
+// ScriptMatch is a stored script, with its findings, and its package.
+type ScriptMatch struct {
+	Package models.Package
+	Script  models.PackageScript
+}
+
+// SearchScripts returns the scripts selected by q, most downloaded packages
+// first.
+func (r *Repository) SearchScripts(ctx context.Context, q ScriptQuery) ([]ScriptMatch, error) {
+	severities := append([]string{}, q.Severities...)
+
+	rows, err := r.db.Query(ctx, `
+        SELECT p.id, p.name, COALESCE(p.version, ''), COALESCE(p.downloads, 0),
+               ps.id, ps.script_type, COALESCE(ps.content, ''),
+               COALESCE(f.findings, '[]'::json)
+        FROM package_scripts ps
+        JOIN packages p ON p.id = ps.package_id
+        LEFT JOIN LATERAL (
+            SELECT json_agg(json_build_object(
+                       'rule', sf.rule, 'severity', sf.severity, 'match', sf.match
+                   ) ORDER BY sf.id) AS findings
+            FROM script_findings sf
+            WHERE sf.script_id = ps.id
+        ) f ON TRUE
+        WHERE ($1 = '' OR ps.content ~* $1)
+          AND ($2 = '' OR ps.script_type = $2)
+          AND (($3 = '' AND cardinality($4::text[]) = 0) OR EXISTS (
+                SELECT 1 FROM script_findings sf
+                WHERE sf.script_id = ps.id
+                  AND ($3 = '' OR sf.rule = $3)
+                  AND (cardinality($4::text[]) = 0 OR sf.severity = ANY($4::text[]))
+              ))
+        ORDER BY p.downloads DESC NULLS LAST, p.name, ps.script_type
+        LIMIT NULLIF($5, 0)
+    `, q.Pattern, q.ScriptType, q.Rule, severities, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search scripts: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []ScriptMatch
+	for rows.Next() {
+		var m ScriptMatch
+		var findings []byte
+		if err := rows.Scan(&m.Package.ID, &m.Package.Name, &m.Package.Version, &m.Package.Downloads,
+			&m.Script.ID, &m.Script.ScriptType, &m.Script.Content, &findings); err != nil {
+			return nil, fmt.Errorf("failed to scan script: %w", err)
+		}
+		if err := json.Unmarshal(findings, &m.Script.Findings); err != nil {
+			return nil, fmt.Errorf("failed to decode findings: %w", err)
+		}
+		m.Script.PackageID = m.Package.ID
+		matches = append(matches, m)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating scripts: %w", rows.Err())
+	}
+
+	return matches, nil
+}
+
+// ExportQuery selects packages to export. WithScripts keeps packages with
+// install scripts, Severities packages with a finding of one of those
+// severities, and UpdatedSince packages stored since then. Zero fields match
+// everything.
+type ExportQuery struct {
+	WithScripts  bool
+	Severities   []string
+	UpdatedSince time.Time
+}
+
+// ExportPackages calls fn with each package selected by q, in name order, as
+// a JSON object holding its metadata and its scripts with their findings. It
+// returns how many packages were exported.
+func (r *Repository) ExportPackages(ctx context.Context, q ExportQuery, fn func(json.RawMessage) error) (int, error) {
+	severities := append([]string{}, q.Severities...)
+	var since *time.Time
+	if !q.UpdatedSince.IsZero() {
+		since = &q.UpdatedSince
+	}
+
+	rows, err := r.db.Query(ctx, `
+        SELECT json_build_object(
+            'name', p.name,
+            'version', p.version,
+            'description', p.description,
+            'author', p.author,
+            'homepage', p.homepage,
+            'repository', p.repository,
+            'license', p.license,
+            'downloads', p.downloads,
+            'popularity_score', p.popularity_score,
+            'created_at', p.created_at,
+            'updated_at', p.updated_at,
+            'last_updated', p.last_updated,
+            'scripts', COALESCE((
+                SELECT json_agg(json_build_object(
+                    'script_type', ps.script_type,
+                    'content', ps.content,
+                    'findings', COALESCE((
+                        SELECT json_agg(json_build_object(
+                            'rule', sf.rule, 'severity', sf.severity, 'match', sf.match
+                        ) ORDER BY sf.id)
+                        FROM script_findings sf
+                        WHERE sf.script_id = ps.id
+                    ), '[]'::json)
+                ) ORDER BY ps.script_type)
+                FROM package_scripts ps
+                WHERE ps.package_id = p.id
+            ), '[]'::json)
+        )::text
+        FROM packages p
+        WHERE (NOT $1 OR EXISTS (SELECT 1 FROM package_scripts ps WHERE ps.package_id = p.id))
+          AND (cardinality($2::text[]) = 0 OR EXISTS (
+                SELECT 1
+                FROM package_scripts ps
+                JOIN script_findings sf ON sf.script_id = ps.id
+                WHERE ps.package_id = p.id AND sf.severity = ANY($2::text[])
+              ))
+          AND ($3::timestamp IS NULL OR p.last_updated >= $3)
+        ORDER BY p.name
+    `, q.WithScripts, severities, since)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query packages: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var doc string
+		if err := rows.Scan(&doc); err != nil {
+			return n, fmt.Errorf("failed to scan package: %w", err)
+		}
+		if err := fn(json.RawMessage(doc)); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if rows.Err() != nil {
+		return n, fmt.Errorf("error iterating packages: %w", rows.Err())
+	}
+
+	return n, nil
+}
+
+// StoredPackage returns a stored package with its scripts and their
+// findings and its maintainers, or nil if no package of that name is stored.
+func (r *Repository) StoredPackage(ctx context.Context, name string) (*models.PackageResult, error) {
+	var result models.PackageResult
+	var createdAt, updatedAt, lastUpdated *time.Time
+	pkg := &result.Package
+	err := r.db.QueryRow(ctx, `
+        SELECT id, name, COALESCE(version, ''), COALESCE(description, ''), COALESCE(author, ''),
+               COALESCE(homepage, ''), COALESCE(repository, ''), COALESCE(license, ''),
+               created_at, updated_at, COALESCE(downloads, 0), COALESCE(popularity_score, 0),
+               last_updated
+        FROM packages
+        WHERE name = $1
+    `, name).Scan(&pkg.ID, &pkg.Name, &pkg.Version, &pkg.Description, &pkg.Author,
+		&pkg.Homepage, &pkg.Repository, &pkg.License,
+		&createdAt, &updatedAt, &pkg.Downloads, &pkg.PopularityScore, &lastUpdated)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package: %w", err)
+	}
+	if createdAt != nil {
+		pkg.CreatedAt = *createdAt
+	}
+	if updatedAt != nil {
+		pkg.UpdatedAt = *updatedAt
+	}
+	if lastUpdated != nil {
+		pkg.LastUpdated = *lastUpdated
+	}
+
+	rows, err := r.db.Query(ctx, `
+        SELECT ps.id, ps.script_type, COALESCE(ps.content, ''), COALESCE(f.findings, '[]'::json)
+        FROM package_scripts ps
+        LEFT JOIN LATERAL (
+            SELECT json_agg(json_build_object(
+                       'rule', sf.rule, 'severity', sf.severity, 'match', sf.match
+                   ) ORDER BY sf.id) AS findings
+            FROM script_findings sf
+            WHERE sf.script_id = ps.id
+        ) f ON TRUE
+        WHERE ps.package_id = $1
+        ORDER BY ps.script_type
+    `, pkg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query scripts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		script := models.PackageScript{PackageID: pkg.ID}
+		var findings []byte
+		if err := rows.Scan(&script.ID, &script.ScriptType, &script.Content, &findings); err != nil {
+			return nil, fmt.Errorf("failed to scan script: %w", err)
+		}
+		if err := json.Unmarshal(findings, &script.Findings); err != nil {
+			return nil, fmt.Errorf("failed to decode findings: %w", err)
+		}
+		result.Scripts = append(result.Scripts, script)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating scripts: %w", rows.Err())
+	}
+
+	rows, err = r.db.Query(ctx, `
+        SELECT name, COALESCE(email, '')
+        FROM package_maintainers
+        WHERE package_id = $1
+        ORDER BY name
+    `, pkg.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query maintainers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m models.Maintainer
+		if err := rows.Scan(&m.Name, &m.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan maintainer: %w", err)
+		}
+		result.Maintainers = append(result.Maintainers, m)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating maintainers: %w", rows.Err())
+	}
+
+	return &result, nil
+}
+
+// EventQuery selects recorded ownership changes. Type and Package keep
+// events of that type or package, and Since events recorded since then.
+// Zero fields match everything and a zero Limit returns every event.
+type EventQuery struct {
+	Type    string
+	Package string
+	Since   time.Time
+	Limit   int
+}
+
+// ListEvents returns the events selected by q, newest first.
+func (r *Repository) ListEvents(ctx context.Context, q EventQuery) ([]models.PackageEvent, error) {
+	var since *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+
+	rows, err := r.db.Query(ctx, `
+        SELECT e.id, e.package_id, p.name, e.event_type, e.account, COALESCE(e.version, ''),
+               e.has_install_scripts, e.created_at
+        FROM package_events e
+        JOIN packages p ON p.id = e.package_id
+        WHERE ($1 = '' OR e.event_type = $1)
+          AND ($2 = '' OR p.name = $2)
+          AND ($3::timestamp IS NULL OR e.created_at >= $3)
+        ORDER BY e.created_at DESC, e.id DESC
+        LIMIT NULLIF($4, 0)
+    `, q.Type, q.Package, since, q.Limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []models.PackageEvent
+	for rows.Next() {
+		var e models.PackageEvent
+		if err := rows.Scan(&e.ID, &e.PackageID, &e.PackageName, &e.Type, &e.Account, &e.Version,
+			&e.HasInstallScripts, &e.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		events = append(events, e)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating events: %w", rows.Err())
+	}
+
+	return events, nil
+}
@@ -0,0 +1,71 @@
+package processor
+
+import (
+	"strings"
+	"sync"
+)
+
+// WakeSet hands out wakeup channels per set of job types, so a job becoming
+// claimable wakes only the workers that can claim it. Workers with the same
+// job types share a channel.
+type WakeSet struct {
+	mu      sync.Mutex
+	waiters map[string]*waiter
+}
+
+type waiter struct {
+	jobTypes []string
+	ch       chan struct{}
+}
+
+func NewWakeSet() *WakeSet {
+	return &WakeSet{waiters: make(map[string]*waiter)}
+}
+
+// Wait returns a channel closed the next time a job of one of jobTypes, or
+// of any type if jobTypes is empty, is notified.
+func (s *WakeSet) Wait(jobTypes []string) <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.Join(jobTypes, ",")
+	w, ok := s.waiters[key]
+	if !ok {
+		w = &waiter{jobTypes: jobTypes, ch: make(chan struct{})}
+		s.waiters[key] = w
+	}
+	return w.ch
+}
+
+// Notify wakes the workers waiting for jobType.
+func (s *WakeSet) Notify(jobType string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, w := range s.waiters {
+		if len(w.jobTypes) == 0 || containsType(w.jobTypes, jobType) {
+			close(w.ch)
+			delete(s.waiters, key)
+		}
+	}
+}
+
+// NotifyAll wakes every waiting worker.
+func (s *WakeSet) NotifyAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, w := range s.waiters {
+		close(w.ch)
+		delete(s.waiters, key)
+	}
+}
+
+func containsType(jobTypes []string, jobType string) bool {
+	for _, t := range jobTypes {
+		if t == jobType {
+			return true
+		}
+	}
+	return false
+}
@@ -2,166 +2,531 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
-	"net/http"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"scrapeNPM/internal/discovery"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/metrics"
 	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/tracing"
 )
 
+// fallbackPollInterval bounds how long an idle worker waits for a wakeup
+// before checking the queue anyway, which is how retries scheduled via
+// next_attempt_after get picked up.
+const fallbackPollInterval = 30 * time.Second
+
+// slotPollInterval is how often a worker whose job types are all running at
+// their concurrency limit checks for a free slot.
+const slotPollInterval = 250 * time.Millisecond
+
+// flushTimeout bounds the writes recording a batch's outcome. They run on a
+// context detached from the worker's, so that a batch interrupted by
+// shutdown is still recorded.
+const flushTimeout = 10 * time.Second
+
+type Config struct {
+	// BatchSize is how many jobs a worker claims at once. The jobs in a
+	// batch are processed concurrently and each job's result is flushed as
+	// soon as it finishes, together with any others finished by then.
+	BatchSize int
+
+	// JobTypes restricts the worker to these job types. Empty means any.
+	JobTypes []string
+}
+
+func DefaultConfig() Config {
+	return Config{
+		BatchSize: 10,
+	}
+}
+
+// Dependencies are the stores and job handlers a Worker runs against.
+// Wakeups is optional; without it workers poll the queue every second.
+type Dependencies struct {
+	Jobs     JobStore
+	Packages PackageStore
+	Handlers *jobs.Registry
+	Wakeups  Wakeups
+}
+
 type Worker struct {
 	id           int
-	repo         *Repository
-	npmClient    *discovery.Client
-	extractor    *Extractor
+	config       Config
+	jobs         JobStore
+	packages     PackageStore
+	handlers     *jobs.Registry
+	wakeups      Wakeups
 	shutdownCh   <-chan struct{}
 	workerID     string
 	pollingDelay time.Duration
+	logger       *slog.Logger
+
+	// lastActive is when the worker last went round its loop or, while it
+	// runs a batch, when its heartbeat last beat, in Unix nanoseconds.
+	lastActive int64
 }
 
-func NewWorker(id int, repo *Repository, npmClient *discovery.Client, shutdownCh <-chan struct{}) *Worker {
+func NewWorker(id int, config Config, deps Dependencies, shutdownCh <-chan struct{}) *Worker {
+	pollingDelay := 1 * time.Second
+	if deps.Wakeups != nil {
+		pollingDelay = fallbackPollInterval
+	}
+
+	if config.BatchSize < 1 {
+		config.BatchSize = 1
+	}
+
+	workerID := fmt.Sprintf("%s-worker-%d", InstanceID(), id)
+
 	return &Worker{
 		id:           id,
-		repo:         repo,
-		npmClient:    npmClient,
-		extractor:    NewExtractor(),
+		config:       config,
+		jobs:         deps.Jobs,
+		packages:     deps.Packages,
+		handlers:     deps.Handlers,
+		wakeups:      deps.Wakeups,
 		shutdownCh:   shutdownCh,
-		workerID:     fmt.Sprintf("worker-%d", id),
-		pollingDelay: 1 * time.Second,
+		workerID:     workerID,
+		pollingDelay: pollingDelay,
+		logger:       slog.Default().With(logging.KeyWorkerID, workerID),
+		lastActive:   time.Now().UnixNano(),
 	}
 }
 
+// LastActive reports when the worker last started a loop iteration or, while
+// it runs a batch, when its heartbeat last beat. The heartbeat stops counting
+// once a handler has overrun its timeout, so a hung batch shows up as stalled.
+func (w *Worker) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&w.lastActive))
+}
+
+// jobRun tracks one job of a claimed batch from processing through to its
+// final status update.
+type jobRun struct {
+	job       *models.Job
+	logger    *slog.Logger
+	span      trace.Span
+	ctx       context.Context
+	cancel    context.CancelFunc
+	result    *jobs.Result
+	err       error
+	startedAt time.Time
+	duration  time.Duration
+	leaseLost bool
+
+	// deadline is when the handler will have timed out, or zero if its job
+	// type has no timeout.
+	deadline time.Time
+
+	// release is set for runs that did not get to an outcome, because the
+	// worker was stopping or no concurrency slot was free. Their jobs go back
+	// to the queue without using up an attempt.
+	release bool
+
+	// flushed is set under the batch mutex once the run is being flushed,
+	// after which the heartbeat no longer touches it.
+	flushed bool
+}
+
 func (w *Worker) Start(ctx context.Context) {
-	log.Printf("[Worker %d] Starting package processor worker", w.id)
+	w.logger.Info("Starting package processor worker")
 
 	for {
+		atomic.StoreInt64(&w.lastActive, time.Now().UnixNano())
+
 		select {
 		case <-ctx.Done():
-			log.Printf("[Worker %d] Shutting down: context cancelled", w.id)
+			w.logger.Info("Worker shutting down", "reason", "context cancelled")
 			return
 		case <-w.shutdownCh:
-			log.Printf("[Worker %d] Shutting down: shutdown signal received", w.id)
+			w.logger.Info("Worker shutting down", "reason", "shutdown signal received")
 			return
 		default:
-			job, err := w.repo.ClaimJob(ctx, w.workerID)
-			if err != nil {
-				log.Printf("[Worker %d] Error claiming job: %v", w.id, err)
-				time.Sleep(w.pollingDelay)
+			var wake <-chan struct{}
+			if w.wakeups != nil {
+				wake = w.wakeups.Wait(w.config.JobTypes)
+			}
+
+			types, limit := w.handlers.Claimable(w.config.JobTypes, w.config.BatchSize)
+			if limit == 0 {
+				w.idle(ctx, nil, slotPollInterval)
 				continue
 			}
 
-			if job == nil {
-				time.Sleep(w.pollingDelay)
+			claimed, err := w.jobs.ClaimJobs(ctx, w.workerID, types, limit)
+			if err != nil {
+				w.logger.Error("Error claiming jobs", logging.Err(err))
+				w.idle(ctx, nil, time.Second)
 				continue
 			}
 
-			log.Printf("[Worker %d] Processing job %s of type %s", w.id, job.ID, job.Type)
-			err = w.processJob(ctx, job)
+			if len(claimed) == 0 {
+				w.idle(ctx, wake, w.pollingDelay)
+				continue
+			}
 
-			if err != nil {
-				log.Printf("[Worker %d] Failed to process job %s: %v", w.id, job.ID, err)
-				if err := w.repo.FailJob(ctx, job.ID, err.Error()); err != nil {
-					log.Printf("[Worker %d] Error marking job as failed: %v", w.id, err)
-				}
-			} else {
-				log.Printf("[Worker %d] Completed job %s", w.id, job.ID)
-				if err := w.repo.CompleteJob(ctx, job.ID); err != nil {
-					log.Printf("[Worker %d] Error marking job as completed: %v", w.id, err)
-				}
+			for _, job := range claimed {
+				metrics.JobsClaimed.WithLabelValues(job.Type).Inc()
 			}
+
+			w.processBatch(ctx, claimed)
 		}
 	}
 }
 
-func (w *Worker) processJob(ctx context.Context, job *models.Job) error {
-	switch job.Type {
-	case "fetch_package":
-		return w.processFetchPackageJob(ctx, job)
-	default:
-		return fmt.Errorf("unknown job type: %s", job.Type)
+func (w *Worker) processBatch(ctx context.Context, claimed []*models.Job) {
+	w.logger.Debug("Processing batch", "jobs", len(claimed))
+
+	runs := make([]*jobRun, len(claimed))
+	for i, job := range claimed {
+		logger := w.jobLogger(job)
+		jobCtx, span := traceJob(logging.WithLogger(ctx, logger), job)
+		jobCtx, cancel := context.WithCancel(jobCtx)
+		runs[i] = &jobRun{job: job, logger: logger, span: span, ctx: jobCtx, cancel: cancel}
+		if spec, err := w.handlers.Spec(job.Type); err == nil && spec.Timeout > 0 {
+			runs[i].deadline = time.Now().Add(spec.Timeout)
+		}
+	}
+
+	var mu sync.Mutex
+	var heartbeats sync.WaitGroup
+	heartbeatDone := make(chan struct{})
+	heartbeats.Add(1)
+	go func() {
+		defer heartbeats.Done()
+		w.heartbeat(ctx, runs, &mu, heartbeatDone)
+	}()
+
+	finished := make(chan *jobRun, len(runs))
+	for _, run := range runs {
+		go func(run *jobRun) {
+			run.startedAt = time.Now()
+			run.logger.Debug("Processing job")
+			run.result, run.err = w.handlers.Run(run.ctx, run.job)
+			run.duration = time.Since(run.startedAt)
+			finished <- run
+		}(run)
 	}
-}
 
-func (w *Worker) processFetchPackageJob(ctx context.Context, job *models.Job) error {
-	pkgName, ok := job.Payload["package_name"].(string)
-	if !ok || pkgName == "" {
-		return fmt.Errorf("invalid package name in job payload")
+	// Flush each run as it finishes, along with any others that finished
+	// meanwhile, so that one slow job does not hold back the rest.
+	for remaining := len(runs); remaining > 0; {
+		group := []*jobRun{<-finished}
+	drain:
+		for {
+			select {
+			case run := <-finished:
+				group = append(group, run)
+			default:
+				break drain
+			}
+		}
+		remaining -= len(group)
+
+		for _, run := range group {
+			run.cancel()
+			run.release = errors.Is(run.err, jobs.ErrNoSlot) ||
+				(ctx.Err() != nil && errors.Is(run.err, context.Canceled))
+		}
+
+		flushCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), flushTimeout)
+		w.flush(flushCtx, group, &mu)
+		cancel()
+
+		for _, run := range group {
+			if run.leaseLost {
+				run.span.SetAttributes(attribute.Bool("job.lease_lost", true))
+			}
+			tracing.End(run.span, run.err)
+		}
 	}
 
-	log.Printf("[Worker %d] Fetching package: %s", w.id, pkgName)
-	rawPackage, err := w.npmClient.GetPackage(ctx, pkgName)
-	if err != nil {
-		return fmt.Errorf("failed to fetch package data: %w", err)
+	close(heartbeatDone)
+	heartbeats.Wait()
+}
+
+// traceJob continues the trace the job was enqueued under, recording how
+// long it waited to be claimed and starting the span that covers running
+// it and storing its result.
+func traceJob(ctx context.Context, job *models.Job) (context.Context, trace.Span) {
+	ctx = tracing.ExtractJob(ctx, job)
+	attrs := trace.WithAttributes(tracing.JobAttributes(job)...)
+
+	queuedAt := job.NextAttemptAfter
+	if queuedAt.IsZero() {
+		queuedAt = job.CreatedAt
 	}
+	_, wait := tracing.Start(ctx, "job.queue_wait", attrs, trace.WithTimestamp(queuedAt))
+	wait.End()
 
-	pkg, err := w.extractor.ExtractPackageData(pkgName, rawPackage)
-	if err != nil {
-		return fmt.Errorf("failed to extract package data: %w", err)
+	return tracing.Start(ctx, "job."+job.Type, attrs)
+}
+
+// traceBatchStep records a step done once for a whole batch, such as a bulk
+// write, as a span in the trace of every job it covered.
+func traceBatchStep(runs []*jobRun, name string, start time.Time, err error) {
+	end := time.Now()
+	for _, run := range runs {
+		_, span := tracing.Start(run.ctx, name,
+			trace.WithTimestamp(start),
+			trace.WithAttributes(attribute.Int("batch.size", len(runs))))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End(trace.WithTimestamp(end))
 	}
+}
 
-	log.Printf("[Worker %d] Fetching download count for: %s", w.id, pkgName)
-	downloads, docsErr := w.fetchDownloadCount(ctx, pkgName)
-	if docsErr != nil {
-		log.Printf("[Worker %d] Warning: failed to fetch download count for %s: %v", w.id, pkgName, docsErr)
-		downloads = 0
+// flush stores the results of every successful run in one batch, then
+// completes those jobs in bulk and records failures individually.
+func (w *Worker) flush(ctx context.Context, runs []*jobRun, mu *sync.Mutex) {
+	// Once flushed is set the heartbeat leaves these runs alone, so their
+	// leaseLost can be read below without holding mu through the writes.
+	mu.Lock()
+	for _, run := range runs {
+		run.flushed = true
 	}
-	pkg.Downloads = downloads
-	pkg.PopularityScore = w.extractor.CalculatePopularityScore(downloads)
+	mu.Unlock()
 
-	log.Printf("[Worker %d] Storing package: %s", w.id, pkgName)
-	packageID, err := w.repo.StorePackage(ctx, pkg)
-	if err != nil {
-		return fmt.Errorf("failed to store package: %w", err)
+	var results []models.PackageResult
+	var stored []*jobRun
+	for _, run := range runs {
+		if run.leaseLost {
+			run.logger.Warn("Job was cancelled or its lease was lost, discarding result")
+			continue
+		}
+		if run.err == nil && run.result != nil && len(run.result.Packages) > 0 {
+			results = append(results, run.result.Packages...)
+			stored = append(stored, run)
+		}
 	}
 
-	log.Printf("[Worker %d] Extracting scripts for package: %s", w.id, pkgName)
-	scripts, err := w.extractor.ExtractScripts(rawPackage, packageID, pkg.Version)
-	if err != nil {
-		log.Printf("[Worker %d] Warning: failed to extract scripts for %s: %v", w.id, pkgName, err)
-	} else {
-		for _, script := range scripts {
-			log.Printf("[Worker %d] Storing %s script for package: %s", w.id, script.ScriptType, pkgName)
-			if err := w.repo.StoreScript(ctx, script); err != nil {
-				log.Printf("[Worker %d] Warning: failed to store %s script for %s: %v",
-					w.id, script.ScriptType, pkgName, err)
+	if len(results) > 0 {
+		start := time.Now()
+		err := w.packages.StoreResults(ctx, results)
+		traceBatchStep(stored, "db.store_results", start, err)
+		if err != nil {
+			for _, run := range stored {
+				run.err = fmt.Errorf("failed to store package: %w", err)
 			}
+		} else {
+			w.reportEvents(results)
 		}
 	}
 
-	return nil
-}
+	var completed []models.JobAttempt
+	var completedRuns []*jobRun
+	var released []uuid.UUID
+	for _, run := range runs {
+		if run.leaseLost {
+			metrics.JobDuration.WithLabelValues(run.job.Type, "abandoned").Observe(run.duration.Seconds())
+			continue
+		}
+		if run.release {
+			released = append(released, run.job.ID)
+			metrics.JobDuration.WithLabelValues(run.job.Type, "abandoned").Observe(run.duration.Seconds())
+			continue
+		}
+
+		attempt := models.JobAttempt{
+			JobID:      run.job.ID,
+			Attempt:    run.job.Attempts,
+			WorkerID:   w.workerID,
+			StartedAt:  run.startedAt,
+			FinishedAt: run.startedAt.Add(run.duration),
+			Duration:   run.duration,
+			Outcome:    "completed",
+		}
+
+		if run.err == nil {
+			completed = append(completed, attempt)
+			completedRuns = append(completedRuns, run)
+			continue
+		}
+
+		attempt.Outcome = "failed"
+		attempt.ErrorClass, attempt.HTTPStatus = ClassifyError(run.err)
+		args := []any{logging.KeyDuration, run.duration, logging.KeyErrorClass, attempt.ErrorClass}
+		if attempt.HTTPStatus != 0 {
+			args = append(args, logging.KeyUpstreamStatus, attempt.HTTPStatus)
+		}
+		run.logger.Warn("Failed to process job", append(args, logging.Err(run.err))...)
+		metrics.JobsFailed.WithLabelValues(run.job.Type, attempt.ErrorClass).Inc()
+		metrics.JobDuration.WithLabelValues(run.job.Type, "failed").Observe(run.duration.Seconds())
+		attempt.ErrorMessage = run.err.Error()
+		var retryAfter time.Duration
+		if Retryable(attempt.ErrorClass) {
+			retryAfter = w.handlers.RetryDelay(run.job, run.err)
+		}
+		_, span := tracing.Start(run.ctx, "db.fail_job")
+		held, err := w.jobs.FailJob(ctx, attempt, retryAfter)
+		tracing.End(span, err)
+		if err != nil {
+			run.logger.Error("Error marking job as failed", logging.Err(err))
+		} else if !held {
+			run.logger.Warn("Job lease was lost before its failure was recorded")
+		}
+	}
 
-func (w *Worker) fetchDownloadCount(ctx context.Context, pkgName string) (int64, error) {
-	url := fmt.Sprintf("https://api.npmjs.org/downloads/point/last-month/%s", pkgName)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %w", err)
+	if len(released) > 0 {
+		n, err := w.jobs.ReleaseJobs(ctx, w.workerID, released)
+		if err != nil {
+			w.logger.Error("Error releasing jobs", "jobs", len(released), logging.Err(err))
+		} else {
+			w.logger.Info("Released unfinished jobs back to the queue", "jobs", n)
+		}
 	}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return 0, fmt.Errorf("failed to fetch download stats: %w", err)
+	if len(completed) > 0 {
+		start := time.Now()
+		ids, err := w.jobs.CompleteJobs(ctx, completed)
+		traceBatchStep(completedRuns, "db.complete_jobs", start, err)
+		if err != nil {
+			w.logger.Error("Error marking jobs as completed", "jobs", len(completed), logging.Err(err))
+			return
+		}
+
+		done := make(map[uuid.UUID]bool, len(ids))
+		for _, id := range ids {
+			done[id] = true
+		}
+		for _, run := range completedRuns {
+			if !done[run.job.ID] {
+				run.logger.Warn("Job lease was lost before it was marked completed")
+				metrics.JobDuration.WithLabelValues(run.job.Type, "abandoned").Observe(run.duration.Seconds())
+				continue
+			}
+			run.logger.Info("Job completed", logging.KeyDuration, run.duration)
+			metrics.JobsCompleted.WithLabelValues(run.job.Type).Inc()
+			metrics.JobDuration.WithLabelValues(run.job.Type, "completed").Observe(run.duration.Seconds())
+		}
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode == http.StatusNotFound {
-		return 0, nil
+// reportEvents logs the ownership changes recorded while storing results. A
+// new publisher pushing install scripts is how account takeovers tend to
+// show up, so it is logged as a warning.
+func (w *Worker) reportEvents(results []models.PackageResult) {
+	for _, result := range results {
+		for _, event := range result.Events {
+			metrics.PackageEvents.WithLabelValues(event.Type).Inc()
+			args := []any{"package", event.PackageName, "event", event.Type, "account", event.Account}
+			if event.Version != "" {
+				args = append(args, "version", event.Version, "install_scripts", event.HasInstallScripts)
+			}
+			if event.Type == models.EventNewPublisher && event.HasInstallScripts {
+				w.logger.Warn("New publisher released a version with install scripts", args...)
+				continue
+			}
+			w.logger.Info("Package ownership changed", args...)
+		}
 	}
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("API returned status code %d", resp.StatusCode)
+// jobLogger returns the worker's logger with the fields identifying job.
+func (w *Worker) jobLogger(job *models.Job) *slog.Logger {
+	logger := w.logger.With(
+		logging.KeyJobID, job.ID,
+		logging.KeyJobType, job.Type,
+		logging.KeyAttempt, job.Attempts,
+	)
+	if name, ok := job.Payload["package_name"].(string); ok {
+		logger = logger.With(logging.KeyPackage, name)
 	}
+	return logger
+}
 
-	var downloadInfo struct {
-		Downloads int64 `json:"downloads"`
+// idle blocks until wake fires, delay passes or the worker is stopped.
+func (w *Worker) idle(ctx context.Context, wake <-chan struct{}, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-w.shutdownCh:
+	case <-wake:
+	case <-timer.C:
 	}
+}
+
+// heartbeat keeps the leases on a batch's unflushed runs alive until done is
+// closed. Jobs whose lease is lost, including jobs cancelled in the database,
+// have their context cancelled so the work is abandoned rather than finished
+// twice.
+func (w *Worker) heartbeat(ctx context.Context, runs []*jobRun, mu *sync.Mutex, done <-chan struct{}) {
+	ticker := time.NewTicker(w.jobs.LeaseDuration() / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			var ids []uuid.UUID
+			overrun := false
+			now := time.Now()
+			mu.Lock()
+			for _, run := range runs {
+				if !run.flushed {
+					ids = append(ids, run.job.ID)
+					overrun = overrun || (!run.deadline.IsZero() && now.After(run.deadline))
+				}
+			}
+			mu.Unlock()
+
+			// A handler still running past its timeout is ignoring its
+			// context, so stop reporting the worker as active.
+			if !overrun {
+				atomic.StoreInt64(&w.lastActive, now.UnixNano())
+			}
+			if len(ids) == 0 {
+				continue
+			}
+
+			held, err := w.jobs.ExtendLeases(ctx, w.workerID, ids)
+			if err != nil {
+				w.logger.Warn("Error extending job leases", logging.Err(err))
+				continue
+			}
 
-	if err := json.NewDecoder(resp.Body).Decode(&downloadInfo); err != nil {
-		return 0, fmt.Errorf("failed to decode download stats: %w", err)
+			heldSet := make(map[uuid.UUID]bool, len(held))
+			for _, id := range held {
+				heldSet[id] = true
+			}
+
+			mu.Lock()
+			for _, run := range runs {
+				if !run.flushed && !heldSet[run.job.ID] && !run.leaseLost {
+					run.leaseLost = true
+					run.cancel()
+				}
+			}
+			mu.Unlock()
+		}
 	}
+}
 
-	return downloadInfo.Downloads, nil
+// InstanceID identifies this process in worker IDs so that leases held by
+// workers on different hosts can't be confused with one another.
+func InstanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
@@ -0,0 +1,404 @@
+package processor_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/memstore"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/processor"
+)
+
+const testJobType = "test_job"
+
+type testPayload struct {
+	Name string `json:"name"`
+}
+
+func (p *testPayload) Validate() error {
+	if p.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// testHandler runs fn for each job, storing a package named after the
+// payload when fn returns no error.
+type testHandler struct {
+	spec jobs.Spec
+	fn   func(ctx context.Context, job *models.Job) error
+}
+
+func (h *testHandler) Spec() jobs.Spec {
+	spec := h.spec
+	spec.Type = testJobType
+	spec.NewPayload = func() jobs.Payload { return &testPayload{} }
+	return spec
+}
+
+func (h *testHandler) Handle(ctx context.Context, job *models.Job, payload jobs.Payload) (*jobs.Result, error) {
+	if err := h.fn(ctx, job); err != nil {
+		return nil, err
+	}
+	name := payload.(*testPayload).Name
+	return &jobs.Result{Packages: []models.PackageResult{{Package: models.Package{Name: name}}}}, nil
+}
+
+// startWorker runs a worker over a memstore holding handler's jobs until the
+// test ends. Nothing announces a retried job's backoff running out, so tests
+// of retries pass poll to have the worker poll the queue every second rather
+// than wait for wakeups.
+func startWorker(t *testing.T, handler *testHandler, batchSize int, poll bool) *memstore.Store {
+	t.Helper()
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(handler)
+	store := memstore.New(handlers)
+
+	deps := processor.Dependencies{Jobs: store, Packages: store, Handlers: handlers}
+	if !poll {
+		deps.Wakeups = store
+	}
+	worker := processor.NewWorker(1, processor.Config{BatchSize: batchSize}, deps, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.Start(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return store
+}
+
+func enqueueTest(t *testing.T, store *memstore.Store, name string) models.Job {
+	t.Helper()
+	job, err := jobs.NewJob(testJobType, &testPayload{Name: name}, 5)
+	if err != nil {
+		t.Fatalf("NewJob: %v", err)
+	}
+	job.ID, err = store.EnqueueJob(context.Background(), job)
+	if err != nil {
+		t.Fatalf("EnqueueJob: %v", err)
+	}
+	return job
+}
+
+func jobStatus(store *memstore.Store, job models.Job) models.Job {
+	for _, j := range store.Jobs() {
+		if j.ID == job.ID {
+			return j
+		}
+	}
+	return models.Job{}
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWorkerCompletesAndStores(t *testing.T) {
+	store := startWorker(t, &testHandler{fn: func(context.Context, *models.Job) error { return nil }}, 3, false)
+
+	var queued []models.Job
+	for _, name := range []string{"a", "b", "c", "d"} {
+		queued = append(queued, enqueueTest(t, store, name))
+	}
+
+	waitFor(t, func() bool {
+		for _, job := range queued {
+			if jobStatus(store, job).Status != "completed" {
+				return false
+			}
+		}
+		return true
+	})
+	for _, job := range queued {
+		name := job.Payload["name"].(string)
+		if _, ok := store.Package(name); !ok {
+			t.Errorf("package %s was not stored", name)
+		}
+		if attempts := store.Attempts(job.ID); len(attempts) != 1 || attempts[0].Outcome != "completed" {
+			t.Errorf("attempts of %s = %+v", name, attempts)
+		}
+	}
+}
+
+func TestWorkerRetriesFailedJobs(t *testing.T) {
+	failures := 2
+	handler := &testHandler{
+		spec: jobs.Spec{Retry: jobs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}},
+		fn: func(_ context.Context, job *models.Job) error {
+			if job.Attempts <= failures {
+				return errors.New("transient failure")
+			}
+			return nil
+		},
+	}
+	store := startWorker(t, handler, 1, true)
+	job := enqueueTest(t, store, "flaky")
+
+	waitFor(t, func() bool { return jobStatus(store, job).Status == "completed" })
+
+	attempts := store.Attempts(job.ID)
+	if len(attempts) != 3 {
+		t.Fatalf("recorded %d attempts, want 3", len(attempts))
+	}
+	for i, outcome := range []string{"failed", "failed", "completed"} {
+		if attempts[i].Outcome != outcome || attempts[i].Attempt != i+1 {
+			t.Errorf("attempt %d = %s (#%d), want %s", i+1, attempts[i].Outcome, attempts[i].Attempt, outcome)
+		}
+	}
+}
+
+func TestWorkerFailsExhaustedJobs(t *testing.T) {
+	handler := &testHandler{
+		spec: jobs.Spec{Retry: jobs.RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}},
+		fn:   func(context.Context, *models.Job) error { return errors.New("permanent failure") },
+	}
+	store := startWorker(t, handler, 1, true)
+	job := enqueueTest(t, store, "broken")
+
+	waitFor(t, func() bool { return jobStatus(store, job).Status == "failed" })
+
+	if got := jobStatus(store, job); got.Attempts != 2 || got.ErrorMessage != "permanent failure" {
+		t.Errorf("failed job = %+v", got)
+	}
+	if _, ok := store.Package("broken"); ok {
+		t.Error("a failed job stored its package")
+	}
+}
+
+func TestWorkerDoesNotRetryMissingPackages(t *testing.T) {
+	handler := &testHandler{
+		spec: jobs.Spec{Retry: jobs.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond}},
+		fn: func(context.Context, *models.Job) error {
+			return &discovery.HTTPError{StatusCode: http.StatusNotFound}
+		},
+	}
+	store := startWorker(t, handler, 1, true)
+	job := enqueueTest(t, store, "unpublished")
+
+	waitFor(t, func() bool { return jobStatus(store, job).Status == "failed" })
+
+	attempts := store.Attempts(job.ID)
+	if len(attempts) != 1 || attempts[0].ErrorClass != processor.ErrorClassNotFound {
+		t.Errorf("attempts = %+v, want a single not_found failure", attempts)
+	}
+}
+
+func TestWorkerAbandonsCancelledJobs(t *testing.T) {
+	started, finished := make(chan struct{}), make(chan struct{})
+	handler := &testHandler{fn: func(ctx context.Context, _ *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		close(finished)
+		return nil
+	}}
+	store := startWorker(t, handler, 1, false)
+	store.SetLeaseDuration(30 * time.Millisecond)
+
+	job := enqueueTest(t, store, "cancelled")
+	<-started
+	store.CancelJob(job.ID)
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the handler of a cancelled job was not cancelled")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if got := jobStatus(store, job); got.Status != "cancelled" {
+		t.Errorf("cancelled job ended as %s", got.Status)
+	}
+	if _, ok := store.Package("cancelled"); ok {
+		t.Error("the result of a cancelled job was stored")
+	}
+	if attempts := store.Attempts(job.ID); len(attempts) != 0 {
+		t.Errorf("an abandoned job recorded attempts %+v", attempts)
+	}
+}
+
+func TestWorkerReleasesJobsOnShutdown(t *testing.T) {
+	started := make(chan struct{})
+	handler := &testHandler{fn: func(ctx context.Context, _ *models.Job) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(handler)
+	store := memstore.New(handlers)
+	worker := processor.NewWorker(1, processor.Config{BatchSize: 1}, processor.Dependencies{
+		Jobs: store, Packages: store, Handlers: handlers, Wakeups: store,
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.Start(ctx)
+		close(done)
+	}()
+
+	job := enqueueTest(t, store, "interrupted")
+	<-started
+	cancel()
+	<-done
+
+	got := jobStatus(store, job)
+	if got.Status != "pending" || got.Attempts != 0 || got.WorkerID != "" {
+		t.Errorf("job interrupted by shutdown = %+v, want pending with no attempts", got)
+	}
+	if attempts := store.Attempts(job.ID); len(attempts) != 0 {
+		t.Errorf("shutdown recorded attempts %+v", attempts)
+	}
+}
+
+func TestWorkerHeartbeatReportsActivity(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := &testHandler{
+		spec: jobs.Spec{Timeout: 200 * time.Millisecond},
+		fn: func(context.Context, *models.Job) error {
+			close(started)
+			<-release // ignores its context, as a hung handler would
+			return nil
+		},
+	}
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(handler)
+	store := memstore.New(handlers)
+	store.SetLeaseDuration(30 * time.Millisecond)
+	worker := processor.NewWorker(1, processor.Config{BatchSize: 1}, processor.Dependencies{
+		Jobs: store, Packages: store, Handlers: handlers, Wakeups: store,
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		close(release)
+		<-done
+	}()
+
+	enqueueTest(t, store, "hung")
+	<-started
+
+	// Within its timeout the handler's heartbeat keeps the worker active.
+	time.Sleep(100 * time.Millisecond)
+	if idle := time.Since(worker.LastActive()); idle > 50*time.Millisecond {
+		t.Errorf("worker inactive for %s while its handler was within its timeout", idle)
+	}
+
+	// Past it, the worker is no longer reported active.
+	time.Sleep(300 * time.Millisecond)
+	if idle := time.Since(worker.LastActive()); idle < 100*time.Millisecond {
+		t.Errorf("worker active %s ago with a handler past its timeout", idle)
+	}
+}
+
+func TestWorkerFlushesJobsAsTheyFinish(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	handler := &testHandler{fn: func(ctx context.Context, job *models.Job) error {
+		if job.Payload["name"] == "slow" {
+			<-release
+		}
+		return nil
+	}}
+	store := startWorker(t, handler, 2, false)
+	store.SetLeaseDuration(30 * time.Millisecond)
+
+	slow := enqueueTest(t, store, "slow")
+	fast := enqueueTest(t, store, "fast")
+
+	waitFor(t, func() bool { return jobStatus(store, fast).Status == "completed" })
+	if _, ok := store.Package("fast"); !ok {
+		t.Error("the fast job's package was not stored while the slow job ran")
+	}
+
+	// The slow job's lease is still renewed after its batch mate was flushed.
+	time.Sleep(100 * time.Millisecond)
+	got := jobStatus(store, slow)
+	if got.Status != "processing" || got.LeaseExpiresAt == nil || !got.LeaseExpiresAt.After(time.Now()) {
+		t.Errorf("slow job = %s with lease until %v while still running", got.Status, got.LeaseExpiresAt)
+	}
+}
+
+func TestWorkersRespectConcurrencyBeforeClaiming(t *testing.T) {
+	started := make(chan string, 2)
+	release := make(chan struct{})
+	handler := &testHandler{
+		spec: jobs.Spec{Concurrency: 1},
+		fn: func(_ context.Context, job *models.Job) error {
+			started <- job.Payload["name"].(string)
+			<-release
+			return nil
+		},
+	}
+	handlers := jobs.NewRegistry()
+	handlers.MustRegister(handler)
+	store := memstore.New(handlers)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{}, 2)
+	for id := 1; id <= 2; id++ {
+		worker := processor.NewWorker(id, processor.Config{BatchSize: 2}, processor.Dependencies{
+			Jobs: store, Packages: store, Handlers: handlers, Wakeups: store,
+		}, nil)
+		go func() {
+			worker.Start(ctx)
+			done <- struct{}{}
+		}()
+	}
+	defer func() {
+		cancel()
+		<-done
+		<-done
+	}()
+
+	first := enqueueTest(t, store, "first")
+	second := enqueueTest(t, store, "second")
+	<-started
+
+	// With the only slot taken, neither worker claims the second job.
+	time.Sleep(100 * time.Millisecond)
+	var waiting models.Job
+	for _, job := range []models.Job{first, second} {
+		if got := jobStatus(store, job); got.Status == "pending" {
+			waiting = got
+		}
+	}
+	if waiting.Status != "pending" || waiting.Attempts != 0 || len(store.Attempts(waiting.ID)) != 0 {
+		t.Errorf("job waiting for the slot = %+v, want pending and never attempted", waiting)
+	}
+
+	close(release)
+	waitFor(t, func() bool {
+		return jobStatus(store, first).Status == "completed" && jobStatus(store, second).Status == "completed"
+	})
+	for _, job := range []models.Job{first, second} {
+		if attempts := store.Attempts(job.ID); len(attempts) != 1 || attempts[0].Attempt != 1 {
+			t.Errorf("attempts of %s = %+v, want one", job.Payload["name"], attempts)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/models"
+)
+
+type Repository struct {
+	db    *pgxpool.Pool
+	queue *discovery.JobQueueRepository
+}
+
+// NewRepository returns a repository over the job_schedules table that
+// enqueues scheduled jobs through queue.
+func NewRepository(db *pgxpool.Pool, queue *discovery.JobQueueRepository) *Repository {
+	return &Repository{db: db, queue: queue}
+}
+
+func (r *Repository) ListSchedules(ctx context.Context) ([]models.JobSchedule, error) {
+	rows, err := r.db.Query(ctx, `
+        SELECT name, cron_spec, job_type, payload, priority, enabled, last_run_at, next_run_at
+        FROM job_schedules
+        ORDER BY name
+    `)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []models.JobSchedule
+	for rows.Next() {
+		var s models.JobSchedule
+		var payloadJSON []byte
+		if err := rows.Scan(&s.Name, &s.Spec, &s.JobType, &payloadJSON, &s.Priority,
+			&s.Enabled, &s.LastRunAt, &s.NextRunAt); err != nil {
+			return nil, fmt.Errorf("failed to scan job schedule: %w", err)
+		}
+
+		if err := json.Unmarshal(payloadJSON, &s.Payload); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal payload of schedule %s: %w", s.Name, err)
+		}
+
+		schedules = append(schedules, s)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating job schedules: %w", rows.Err())
+	}
+
+	return schedules, nil
+}
+
+func (r *Repository) SetNextRun(ctx context.Context, name string, next time.Time) error {
+	_, err := r.db.Exec(ctx, `
+        UPDATE job_schedules SET next_run_at = $2 WHERE name = $1
+    `, name, next)
+	if err != nil {
+		return fmt.Errorf("failed to set next run: %w", err)
+	}
+	return nil
+}
+
+// RunSchedule enqueues job through the job queue, which validates it, and
+// moves the schedule on to next in the same transaction.
+func (r *Repository) RunSchedule(ctx context.Context, name string, job models.Job, next time.Time) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := r.queue.EnqueueJobTx(ctx, tx, job); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+        UPDATE job_schedules
+        SET last_run_at = $2, next_run_at = $3
+        WHERE name = $1
+    `, name, time.Now().UTC(), next)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ScheduleUpdate changes a schedule. Nil fields keep their current value, or
+// the column default when the schedule is new; a new schedule needs Spec and
+// JobType.
+type ScheduleUpdate struct {
+	Spec     *string
+	JobType  *string
+	Payload  map[string]interface{}
+	Priority *int
+	Enabled  *bool
+}
+
+// SetSchedule creates or updates a schedule. Changing the spec reschedules
+// the next run from now.
+func (r *Repository) SetSchedule(ctx context.Context, name string, update ScheduleUpdate) error {
+	var payloadBytes []byte
+	if update.Payload != nil {
+		var err error
+		payloadBytes, err = json.Marshal(update.Payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal payload: %w", err)
+		}
+	}
+
+	_, err := r.db.Exec(ctx, `
+        INSERT INTO job_schedules (name, cron_spec, job_type, payload, priority, enabled)
+        VALUES ($1, $2, $3, COALESCE($4::jsonb, '{}'), COALESCE($5::int, 5), COALESCE($6::boolean, TRUE))
+        ON CONFLICT (name) DO UPDATE SET
+            cron_spec = COALESCE($2, job_schedules.cron_spec),
+            job_type = COALESCE($3, job_schedules.job_type),
+            payload = COALESCE($4::jsonb, job_schedules.payload),
+            priority = COALESCE($5::int, job_schedules.priority),
+            enabled = COALESCE($6::boolean, job_schedules.enabled),
+            next_run_at = CASE
+                WHEN $2 IS NOT NULL AND $2 <> job_schedules.cron_spec THEN NULL
+                ELSE job_schedules.next_run_at
+            END,
+            updated_at = NOW()
+    `, name, update.Spec, update.JobType, payloadBytes, update.Priority, update.Enabled)
+	if err != nil {
+		return fmt.Errorf("failed to set job schedule: %w", err)
+	}
+	return nil
+}
+
+// TriggerSchedule makes a schedule due on the leader's next check.
+func (r *Repository) TriggerSchedule(ctx context.Context, name string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `
+        UPDATE job_schedules SET next_run_at = $2 WHERE name = $1
+    `, name, time.Now().UTC())
+	if err != nil {
+		return false, fmt.Errorf("failed to trigger job schedule: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (r *Repository) DeleteSchedule(ctx context.Context, name string) (bool, error) {
+	tag, err := r.db.Exec(ctx, `DELETE FROM job_schedules WHERE name = $1`, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete job schedule: %w", err)
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+var _ Store = (*Repository)(nil)
@@ -0,0 +1,200 @@
+// Package scheduler enqueues recurring jobs from cron specs stored in the
+// database. Only the instance holding the scheduler lock enqueues anything.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/logging"
+	"scrapeNPM/internal/models"
+	"scrapeNPM/internal/tracing"
+)
+
+// LockKey is the Postgres advisory lock key held by the leading scheduler.
+const LockKey int64 = 0x73637270_73636864
+
+type Config struct {
+	// Interval is how often the leader checks for due schedules and other
+	// instances try to take over leadership.
+	Interval time.Duration `yaml:"interval"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Interval: 30 * time.Second,
+	}
+}
+
+// Store reads schedules and records their runs. RunSchedule enqueues job and
+// moves the schedule on to next in one transaction, failing with
+// jobs.ErrInvalidPayload or jobs.ErrUnknownJobType if the job is invalid.
+type Store interface {
+	ListSchedules(ctx context.Context) ([]models.JobSchedule, error)
+	SetNextRun(ctx context.Context, name string, next time.Time) error
+	RunSchedule(ctx context.Context, name string, job models.Job, next time.Time) error
+}
+
+// Leader is a lock that at most one instance holds at a time. TryAcquire
+// reports whether this instance holds it, taking it if it is free.
+type Leader interface {
+	TryAcquire(ctx context.Context) (bool, error)
+	Release(ctx context.Context)
+}
+
+type Scheduler struct {
+	config Config
+	store  Store
+	leader Leader
+	now    func() time.Time
+}
+
+// NewScheduler returns a scheduler over store. A nil leader means this
+// instance always schedules.
+func NewScheduler(config Config, store Store, leader Leader) *Scheduler {
+	return &Scheduler{
+		config: config,
+		store:  store,
+		leader: leader,
+		now:    time.Now,
+	}
+}
+
+// ParseSpec parses a standard five-field cron spec or a descriptor such as
+// @daily or @every 6h.
+func ParseSpec(spec string) (cron.Schedule, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron spec %q: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+func (s *Scheduler) Run(ctx context.Context) {
+	slog.Info("Starting job scheduler", "interval", s.config.Interval)
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	leading := false
+	for {
+		isLeader := true
+		if s.leader != nil {
+			var err error
+			isLeader, err = s.leader.TryAcquire(ctx)
+			if err != nil && ctx.Err() == nil {
+				slog.Error("Error checking scheduler leadership", logging.Err(err))
+			}
+		}
+
+		if isLeader != leading {
+			if isLeader {
+				slog.Info("Acquired scheduler leadership")
+			} else {
+				slog.Info("Lost scheduler leadership")
+			}
+			leading = isLeader
+		}
+
+		if leading {
+			s.tick(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			if s.leader != nil {
+				s.leader.Release(context.Background())
+			}
+			slog.Info("Job scheduler stopping")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick enqueues every enabled schedule that has come due. Specs are
+// evaluated in UTC. Runs missed while no instance was leading are collapsed
+// into one.
+func (s *Scheduler) tick(ctx context.Context) {
+	schedules, err := s.store.ListSchedules(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Error loading job schedules", logging.Err(err))
+		}
+		return
+	}
+
+	now := s.now().UTC()
+	for _, sched := range schedules {
+		if !sched.Enabled {
+			continue
+		}
+
+		logger := slog.With(logging.KeySchedule, sched.Name)
+
+		spec, err := ParseSpec(sched.Spec)
+		if err != nil {
+			logger.Error("Invalid schedule", logging.Err(err))
+			continue
+		}
+		next := spec.Next(now)
+
+		if sched.NextRunAt == nil {
+			if err := s.store.SetNextRun(ctx, sched.Name, next); err != nil {
+				logger.Error("Error setting next run", logging.Err(err))
+			}
+			continue
+		}
+
+		if sched.NextRunAt.After(now) {
+			continue
+		}
+
+		if err := s.run(ctx, sched, next); err != nil {
+			if errors.Is(err, jobs.ErrInvalidPayload) || errors.Is(err, jobs.ErrUnknownJobType) {
+				logger.Error("Skipping run, job is invalid", logging.Err(err))
+				if err := s.store.SetNextRun(ctx, sched.Name, next); err != nil {
+					logger.Error("Error setting next run", logging.Err(err))
+				}
+				continue
+			}
+			logger.Error("Error enqueueing scheduled job", logging.Err(err))
+			continue
+		}
+
+		logger.Info("Enqueued scheduled job", logging.KeyJobType, sched.JobType, "next_run_at", next)
+	}
+}
+
+// run enqueues one run of sched, starting the trace the job carries to the
+// worker that processes it.
+func (s *Scheduler) run(ctx context.Context, sched models.JobSchedule, next time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "scheduler.run",
+		trace.WithNewRoot(),
+		trace.WithAttributes(
+			attribute.String("schedule.name", sched.Name),
+			attribute.String("job.type", sched.JobType),
+		))
+	defer func() { tracing.End(span, err) }()
+
+	job := models.Job{
+		Type:     sched.JobType,
+		Status:   "pending",
+		Priority: sched.Priority,
+		Payload:  make(map[string]interface{}, len(sched.Payload)),
+	}
+	for k, v := range sched.Payload {
+		job.Payload[k] = v
+	}
+	tracing.InjectJob(ctx, &job)
+
+	return s.store.RunSchedule(ctx, sched.Name, job, next)
+}
@@ -0,0 +1,173 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"scrapeNPM/internal/jobs"
+	"scrapeNPM/internal/models"
+)
+
+// fakeStore keeps schedules in memory and records the jobs enqueued for
+// them. RunSchedule fails with err when it is set.
+type fakeStore struct {
+	mu        sync.Mutex
+	schedules []models.JobSchedule
+	enqueued  []models.Job
+	lists     int
+	err       error
+}
+
+func (s *fakeStore) ListSchedules(ctx context.Context) ([]models.JobSchedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lists++
+	return append([]models.JobSchedule(nil), s.schedules...), nil
+}
+
+func (s *fakeStore) SetNextRun(ctx context.Context, name string, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schedule(name).NextRunAt = &next
+	return nil
+}
+
+func (s *fakeStore) RunSchedule(ctx context.Context, name string, job models.Job, next time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.enqueued = append(s.enqueued, job)
+	sched := s.schedule(name)
+	sched.LastRunAt = &next
+	sched.NextRunAt = &next
+	return nil
+}
+
+func (s *fakeStore) schedule(name string) *models.JobSchedule {
+	for i := range s.schedules {
+		if s.schedules[i].Name == name {
+			return &s.schedules[i]
+		}
+	}
+	panic("unknown schedule " + name)
+}
+
+type fakeLeader struct {
+	leading  bool
+	released bool
+}
+
+func (l *fakeLeader) TryAcquire(ctx context.Context) (bool, error) { return l.leading, nil }
+func (l *fakeLeader) Release(ctx context.Context)                  { l.released = true }
+
+func at(hour, minute int) *time.Time {
+	t := time.Date(2024, 3, 4, hour, minute, 0, 0, time.UTC)
+	return &t
+}
+
+func TestTick(t *testing.T) {
+	payload := map[string]interface{}{"top": float64(100)}
+	tests := []struct {
+		name     string
+		schedule models.JobSchedule
+		err      error
+		enqueued int
+		next     *time.Time
+	}{
+		{
+			name:     "due",
+			schedule: models.JobSchedule{Spec: "0 * * * *", Enabled: true, NextRunAt: at(10, 0)},
+			enqueued: 1,
+			next:     at(11, 0),
+		},
+		{
+			name:     "not due yet",
+			schedule: models.JobSchedule{Spec: "0 * * * *", Enabled: true, NextRunAt: at(11, 0)},
+			next:     at(11, 0),
+		},
+		{
+			name:     "first seen",
+			schedule: models.JobSchedule{Spec: "0 * * * *", Enabled: true},
+			next:     at(11, 0),
+		},
+		{
+			name:     "disabled",
+			schedule: models.JobSchedule{Spec: "0 * * * *", NextRunAt: at(10, 0)},
+			next:     at(10, 0),
+		},
+		{
+			name:     "invalid job",
+			schedule: models.JobSchedule{Spec: "0 * * * *", Enabled: true, NextRunAt: at(10, 0)},
+			err:      fmt.Errorf("%w: top must be between 1 and 1000000", jobs.ErrInvalidPayload),
+			next:     at(11, 0),
+		},
+		{
+			name:     "enqueue failure",
+			schedule: models.JobSchedule{Spec: "0 * * * *", Enabled: true, NextRunAt: at(10, 0)},
+			err:      fmt.Errorf("failed to insert job: connection reset"),
+			next:     at(10, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.schedule.Name = "refresh"
+			tt.schedule.JobType = jobs.TypeRefreshDownloads
+			tt.schedule.Payload = payload
+			tt.schedule.Priority = 7
+			store := &fakeStore{schedules: []models.JobSchedule{tt.schedule}, err: tt.err}
+
+			s := NewScheduler(DefaultConfig(), store, nil)
+			s.now = func() time.Time { return *at(10, 30) }
+			s.tick(context.Background())
+			// A second check in the same hour must not enqueue the run again.
+			s.tick(context.Background())
+
+			if len(store.enqueued) != tt.enqueued {
+				t.Fatalf("enqueued %d jobs, want %d", len(store.enqueued), tt.enqueued)
+			}
+			if tt.enqueued > 0 {
+				job := store.enqueued[0]
+				if job.Type != jobs.TypeRefreshDownloads || job.Priority != 7 || job.Payload["top"] != float64(100) {
+					t.Errorf("enqueued job = %+v", job)
+				}
+			}
+			if got := store.schedules[0].NextRunAt; (got == nil) != (tt.next == nil) || (got != nil && !got.Equal(*tt.next)) {
+				t.Errorf("next run = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
+
+func TestRunOnlySchedulesWhenLeading(t *testing.T) {
+	for _, leading := range []bool{false, true} {
+		store := &fakeStore{schedules: []models.JobSchedule{
+			{Name: "refresh", Spec: "@every 1m", JobType: jobs.TypeRefreshDownloads, Enabled: true, NextRunAt: at(0, 0)},
+		}}
+		leader := &fakeLeader{leading: leading}
+		s := NewScheduler(Config{Interval: 5 * time.Millisecond}, store, leader)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		s.Run(ctx)
+		cancel()
+
+		store.mu.Lock()
+		lists, enqueued := store.lists, len(store.enqueued)
+		store.mu.Unlock()
+
+		if !leading && (lists != 0 || enqueued != 0) {
+			t.Errorf("non-leader listed schedules %d times and enqueued %d jobs", lists, enqueued)
+		}
+		if leading && enqueued != 1 {
+			t.Errorf("leader enqueued %d jobs, want 1", enqueued)
+		}
+		if !leader.released {
+			t.Error("scheduler did not release the lock on shutdown")
+		}
+	}
+}
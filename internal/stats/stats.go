@@ -0,0 +1,153 @@
+// Package stats reports job queue depth, throughput and latency, both as
+// periodic log lines and as JSON over HTTP.
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"scrapeNPM/internal/discovery"
+	"scrapeNPM/internal/logging"
+)
+
+// backlogAge is how old the oldest pending job of a type must be before a
+// type that enqueues faster than it completes is reported as falling behind.
+const backlogAge = 10 * time.Minute
+
+type Config struct {
+	// Window is the trailing period rates, durations and failures cover.
+	Window time.Duration `yaml:"window"`
+
+	// LogInterval is how often stats are logged. Zero disables logging.
+	LogInterval time.Duration `yaml:"log_interval"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Window:      15 * time.Minute,
+		LogInterval: 5 * time.Minute,
+	}
+}
+
+type Store interface {
+	GetQueueStats(ctx context.Context, window time.Duration) (*discovery.QueueStats, error)
+}
+
+var _ Store = (*discovery.JobQueueRepository)(nil)
+
+type Reporter struct {
+	config Config
+	store  Store
+}
+
+func NewReporter(config Config, store Store) *Reporter {
+	return &Reporter{
+		config: config,
+		store:  store,
+	}
+}
+
+// Run logs queue stats every LogInterval until ctx is cancelled.
+func (r *Reporter) Run(ctx context.Context) {
+	if r.config.LogInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.config.LogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stats, err := r.store.GetQueueStats(ctx, r.config.Window)
+			if err != nil {
+				if ctx.Err() == nil {
+					slog.Error("Error getting queue stats", logging.Err(err))
+				}
+				continue
+			}
+			logStats(stats)
+		}
+	}
+}
+
+// ServeHTTP writes the current stats as JSON. The window can be overridden
+// with a duration query parameter, e.g. /stats?window=1h.
+func (r *Reporter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	window := r.config.Window
+	if raw := req.URL.Query().Get("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			http.Error(w, fmt.Sprintf("invalid window %q", raw), http.StatusBadRequest)
+			return
+		}
+		window = parsed
+	}
+
+	stats, err := r.store.GetQueueStats(req.Context(), window)
+	if err != nil {
+		slog.Error("Error getting queue stats", logging.Err(err))
+		http.Error(w, "failed to get queue stats", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("Error writing queue stats", logging.Err(err))
+	}
+}
+
+func logStats(stats *discovery.QueueStats) {
+	window := time.Duration(stats.WindowSeconds * float64(time.Second))
+
+	for _, t := range stats.Types {
+		slog.Info("Queue stats",
+			logging.KeyJobType, t.JobType,
+			"window", window,
+			"pending", t.Pending,
+			"processing", t.Processing,
+			"failed", t.Failed,
+			"oldest_pending", seconds(t.OldestPendingSeconds),
+			"enqueued_per_minute", t.EnqueuedPerMinute,
+			"completed_per_minute", t.CompletedPerMinute,
+			"failed_per_minute", t.FailedPerMinute,
+			"p50", seconds(t.P50Seconds),
+			"p95", seconds(t.P95Seconds),
+			"retry_rate", t.RetryRate)
+
+		if FallingBehind(t) {
+			slog.Warn("Job type is falling behind",
+				logging.KeyJobType, t.JobType,
+				"enqueued_per_minute", t.EnqueuedPerMinute,
+				"completed_per_minute", t.CompletedPerMinute,
+				"oldest_pending", seconds(t.OldestPendingSeconds))
+		}
+	}
+
+	for _, c := range stats.FailureClasses {
+		slog.Info("Queue failures", logging.KeyJobType, c.JobType, logging.KeyErrorClass, c.ErrorClass, "count", c.Count, "window", window)
+	}
+
+	d := stats.Discovery
+	slog.Info("Discovery progress",
+		"sequence", d.LastSequence,
+		"total_processed", d.TotalProcessed,
+		"checkpoint_age", seconds(d.CheckpointSeconds))
+}
+
+// FallingBehind reports whether jobs of a type arrive faster than they are
+// completed while the oldest pending job keeps ageing.
+func FallingBehind(t discovery.JobTypeStats) bool {
+	return t.EnqueuedPerMinute > t.CompletedPerMinute &&
+		seconds(t.OldestPendingSeconds) >= backlogAge
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+}
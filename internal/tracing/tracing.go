@@ -0,0 +1,183 @@
+// Package tracing sets up OpenTelemetry tracing and carries trace context
+// from the scraper to the workers through job payloads.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"scrapeNPM/internal/models"
+)
+
+// PayloadKey is the job payload field holding the W3C trace context of the
+// span that enqueued the job. Handlers ignore it.
+const PayloadKey = "trace_context"
+
+const instrumentationName = "scrapeNPM"
+
+type Config struct {
+	// Exporter is none, otlp or stdout. The OTLP exporter is configured by
+	// the standard OTEL_EXPORTER_OTLP_* variables and sends over HTTP.
+	Exporter string `yaml:"exporter"`
+
+	// ServiceName is reported as service.name unless OTEL_SERVICE_NAME is
+	// set.
+	ServiceName string `yaml:"service_name"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Exporter:    "none",
+		ServiceName: "scrapeNPM",
+	}
+}
+
+var propagator = propagation.TraceContext{}
+
+// Setup installs the global tracer provider. The returned function flushes
+// and stops it. With the none exporter spans are not recorded and Setup
+// only installs the propagator.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.Exporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "stdout", "console":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		return nil, fmt.Errorf("invalid trace exporter %q: want none, otlp or stdout", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(
+		resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)),
+		resource.Environment(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Start starts a span as a child of any span in ctx.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+}
+
+// End records err on span, if any, and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectJob stores the trace context of ctx in the job's payload so that
+// processing the job continues the trace.
+func InjectJob(ctx context.Context, job *models.Job) {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	fields := make(map[string]interface{}, len(carrier))
+	for k, v := range carrier {
+		fields[k] = v
+	}
+	if job.Payload == nil {
+		job.Payload = make(map[string]interface{})
+	}
+	job.Payload[PayloadKey] = fields
+}
+
+// ExtractJob returns ctx carrying the remote span stored in the job's
+// payload by InjectJob, if any.
+func ExtractJob(ctx context.Context, job *models.Job) context.Context {
+	fields, ok := job.Payload[PayloadKey].(map[string]interface{})
+	if !ok {
+		return ctx
+	}
+
+	carrier := propagation.MapCarrier{}
+	for k, v := range fields {
+		if s, ok := v.(string); ok {
+			carrier[k] = s
+		}
+	}
+	return propagator.Extract(ctx, carrier)
+}
+
+// JobAttributes describe a job on the spans that process it.
+func JobAttributes(job *models.Job) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("job.id", job.ID.String()),
+		attribute.String("job.type", job.Type),
+		attribute.Int("job.attempt", job.Attempts),
+	}
+	if name, ok := job.Payload["package_name"].(string); ok {
+		attrs = append(attrs, attribute.String("npm.package", name))
+	}
+	return attrs
+}
+
+// Transport wraps each request made through it in a client span.
+type Transport struct {
+	next http.RoundTripper
+}
+
+func NewTransport(next http.RoundTripper) *Transport {
+	return &Transport{next: next}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := Start(req.Context(), "HTTP "+req.Method+" "+req.URL.Host,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(req.URL.String()),
+			semconv.ServerAddress(req.URL.Hostname()),
+		))
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		End(span, err)
+		return resp, err
+	}
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	span.End()
+	return resp, nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS package_scripts;
+DROP TABLE IF EXISTS packages;
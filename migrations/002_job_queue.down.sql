@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS scrape_progress;
+DROP TABLE IF EXISTS job_queue;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS job_queue_lease_idx;
+ALTER TABLE job_queue DROP COLUMN IF EXISTS lease_expires_at;
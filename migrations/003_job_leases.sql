@@ -0,0 +1,10 @@
+-- Track how long a worker may hold a claimed job before it is considered abandoned
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS lease_expires_at TIMESTAMP;
+
+-- Jobs stuck in processing from before leases existed expire immediately
+UPDATE job_queue
+SET lease_expires_at = NOW()
+WHERE status = 'processing' AND lease_expires_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS job_queue_lease_idx ON job_queue(lease_expires_at)
+    WHERE status = 'processing';
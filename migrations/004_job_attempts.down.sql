@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS job_attempts;
+DROP INDEX IF EXISTS job_queue_failed_class_idx;
+ALTER TABLE job_queue DROP COLUMN IF EXISTS error_class;
@@ -0,0 +1,23 @@
+-- Classify the most recent failure on each job so dead letters can be grouped
+ALTER TABLE job_queue ADD COLUMN IF NOT EXISTS error_class VARCHAR(50);
+
+CREATE INDEX IF NOT EXISTS job_queue_failed_class_idx ON job_queue(error_class, job_type)
+    WHERE status = 'failed';
+
+-- Full history of every processing attempt
+CREATE TABLE IF NOT EXISTS job_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    job_id UUID NOT NULL REFERENCES job_queue(id) ON DELETE CASCADE,
+    attempt INT NOT NULL,
+    worker_id VARCHAR(100),
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    duration_ms BIGINT,
+    outcome VARCHAR(20) NOT NULL,  -- completed, failed or lease_expired
+    error_class VARCHAR(50),
+    http_status INT,
+    error_message TEXT
+);
+
+CREATE INDEX IF NOT EXISTS job_attempts_job_id_idx ON job_attempts(job_id, attempt);
+CREATE INDEX IF NOT EXISTS job_attempts_finished_idx ON job_attempts(finished_at);
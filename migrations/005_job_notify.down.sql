@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS job_queue_notify ON job_queue;
+DROP FUNCTION IF EXISTS notify_job_queue();
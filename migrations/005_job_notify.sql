@@ -0,0 +1,14 @@
+-- Wake idle workers whenever a job becomes claimable
+CREATE OR REPLACE FUNCTION notify_job_queue() RETURNS trigger AS $$
+BEGIN
+    IF NEW.status = 'pending' AND NEW.next_attempt_after <= NOW() THEN
+        PERFORM pg_notify('job_queue', NEW.job_type);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS job_queue_notify ON job_queue;
+CREATE TRIGGER job_queue_notify
+    AFTER INSERT OR UPDATE OF status ON job_queue
+    FOR EACH ROW EXECUTE FUNCTION notify_job_queue();
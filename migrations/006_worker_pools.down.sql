@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS job_queue_type_claim_idx;
+DROP TABLE IF EXISTS worker_pools;
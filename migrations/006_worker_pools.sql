@@ -0,0 +1,17 @@
+-- Worker pools sized per job type. Running processes re-read this table, so
+-- pools can be resized without a restart. A pool with no job types takes
+-- every type not assigned to another pool.
+CREATE TABLE IF NOT EXISTS worker_pools (
+    name VARCHAR(100) PRIMARY KEY,
+    job_types TEXT[] NOT NULL DEFAULT '{}',
+    size INT NOT NULL DEFAULT 1 CHECK (size >= 0),
+    batch_size INT NOT NULL DEFAULT 10 CHECK (batch_size > 0),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO worker_pools (name, job_types, size)
+VALUES ('default', '{}', 10)
+ON CONFLICT (name) DO NOTHING;
+
+CREATE INDEX IF NOT EXISTS job_queue_type_claim_idx ON job_queue(job_type, priority, created_at)
+    WHERE status = 'pending';
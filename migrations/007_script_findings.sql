@@ -0,0 +1,13 @@
+-- Rules from internal/analysis that matched an install script. Findings are
+-- replaced whenever a script is stored or re-analyzed.
+CREATE TABLE IF NOT EXISTS script_findings (
+    id BIGSERIAL PRIMARY KEY,
+    script_id UUID NOT NULL REFERENCES package_scripts(id) ON DELETE CASCADE,
+    rule VARCHAR(100) NOT NULL,
+    severity VARCHAR(20) NOT NULL,
+    match TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS script_findings_script_idx ON script_findings(script_id);
+CREATE INDEX IF NOT EXISTS script_findings_rule_idx ON script_findings(rule, severity);
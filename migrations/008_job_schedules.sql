@@ -0,0 +1,19 @@
+-- Recurring jobs, enqueued by whichever instance holds the scheduler lock
+CREATE TABLE IF NOT EXISTS job_schedules (
+    name VARCHAR(100) PRIMARY KEY,
+    cron_spec VARCHAR(100) NOT NULL,
+    job_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    priority INT NOT NULL DEFAULT 5,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    last_run_at TIMESTAMP,
+    next_run_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+INSERT INTO job_schedules (name, cron_spec, job_type, payload, priority)
+VALUES
+    ('refresh-top-downloads', '0 3 * * 0', 'refresh_downloads', '{"top": 10000}', 8),
+    ('reanalyze-scripts', '0 2 * * *', 'analyze_scripts', '{}', 8)
+ON CONFLICT (name) DO NOTHING;
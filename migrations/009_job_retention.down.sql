@@ -0,0 +1,8 @@
+-- Failed jobs keep their completed_at; it is harmless once nothing prunes them
+DELETE FROM job_schedules WHERE name = 'prune-jobs';
+
+DROP TABLE IF EXISTS job_queue_archive;
+
+ALTER TABLE job_queue RESET (autovacuum_vacuum_scale_factor, autovacuum_analyze_scale_factor);
+
+DROP INDEX IF EXISTS job_queue_finished_idx;
@@ -0,0 +1,51 @@
+-- Retention for job_queue. Only the archive is partitioned. The live queue is
+-- not: partitioning it by status would move a row between partitions on every
+-- claim, retry and completion, and partitioning it by time would not shrink
+-- what claims read, since a pending job can be of any age and one retried for
+-- days would keep its partition from being dropped. Instead finished jobs are
+-- moved out in batches by prune_jobs, and claims only read the partial indexes
+-- on status = 'pending' (job_queue_next_attempt_idx, job_queue_type_claim_idx),
+-- which grow with the backlog rather than with the table.
+
+-- completed_at now records when a job finished either way, so failed jobs
+-- age out under the retention policy too
+UPDATE job_queue
+SET completed_at = COALESCE(started_at, created_at)
+WHERE status = 'failed' AND completed_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS job_queue_finished_idx ON job_queue(status, completed_at)
+    WHERE status IN ('completed', 'failed');
+
+-- Keep the live queue small: vacuum after far fewer dead rows than the
+-- default so bulk pruning doesn't leave claims scanning dead tuples
+ALTER TABLE job_queue SET (autovacuum_vacuum_scale_factor = 0.02, autovacuum_analyze_scale_factor = 0.02);
+
+-- Finished jobs moved out of job_queue, with their attempt history inlined.
+-- Partitioned by month so expiring the archive is a partition drop.
+CREATE TABLE IF NOT EXISTS job_queue_archive (
+    id UUID NOT NULL,
+    job_type VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    priority INT,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP,
+    started_at TIMESTAMP,
+    finished_at TIMESTAMP NOT NULL,
+    attempts INT,
+    max_attempts INT,
+    error_message TEXT,
+    error_class VARCHAR(50),
+    worker_id VARCHAR(100),
+    attempt_history JSONB NOT NULL DEFAULT '[]',
+    archived_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (id, finished_at)
+) PARTITION BY RANGE (finished_at);
+
+CREATE INDEX IF NOT EXISTS job_queue_archive_type_idx ON job_queue_archive(job_type, finished_at);
+
+INSERT INTO job_schedules (name, cron_spec, job_type, payload, priority)
+VALUES (
+    'prune-jobs', '15 * * * *', 'prune_jobs',
+    '{"completed_days": 7, "failed_days": 30, "archive": true, "archive_days": 180}', 9
+)
+ON CONFLICT (name) DO NOTHING;
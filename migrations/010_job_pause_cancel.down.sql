@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS job_queue_finished_idx;
+CREATE INDEX IF NOT EXISTS job_queue_finished_idx ON job_queue(status, completed_at)
+    WHERE status IN ('completed', 'failed');
+
+DROP TABLE IF EXISTS paused_job_types;
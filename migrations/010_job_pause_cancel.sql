@@ -0,0 +1,11 @@
+-- Job types paused globally; workers on every instance stop claiming them
+CREATE TABLE IF NOT EXISTS paused_job_types (
+    job_type VARCHAR(50) PRIMARY KEY,
+    reason TEXT,
+    paused_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+-- Cancelled jobs finish like completed and failed ones and age out with them
+DROP INDEX IF EXISTS job_queue_finished_idx;
+CREATE INDEX IF NOT EXISTS job_queue_finished_idx ON job_queue(status, completed_at)
+    WHERE status IN ('completed', 'failed', 'cancelled');
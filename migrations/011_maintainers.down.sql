@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS package_events;
+DROP TABLE IF EXISTS package_versions;
+DROP TABLE IF EXISTS package_maintainers;
@@ -0,0 +1,37 @@
+-- Current maintainers of each package
+CREATE TABLE IF NOT EXISTS package_maintainers (
+    package_id UUID NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    name VARCHAR(214) NOT NULL,
+    email TEXT,
+    added_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (package_id, name)
+);
+
+-- Every published version and the account that published it
+CREATE TABLE IF NOT EXISTS package_versions (
+    package_id UUID NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    version VARCHAR(100) NOT NULL,
+    published_at TIMESTAMP,
+    publisher_name VARCHAR(214),
+    publisher_email TEXT,
+    has_install_scripts BOOLEAN NOT NULL DEFAULT FALSE,
+    first_seen_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (package_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS package_versions_publisher_idx ON package_versions(publisher_name);
+
+-- Ownership changes noticed when packages are stored: maintainers added or
+-- removed, and versions published by an account new to the package
+CREATE TABLE IF NOT EXISTS package_events (
+    id BIGSERIAL PRIMARY KEY,
+    package_id UUID NOT NULL REFERENCES packages(id) ON DELETE CASCADE,
+    event_type VARCHAR(50) NOT NULL,
+    account VARCHAR(214) NOT NULL,
+    version VARCHAR(100),
+    has_install_scripts BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS package_events_created_idx ON package_events(created_at);
+CREATE INDEX IF NOT EXISTS package_events_package_idx ON package_events(package_id, created_at);
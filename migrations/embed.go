@@ -0,0 +1,9 @@
+// Package migrations holds the database schema migrations, embedded in the
+// binary. NNN_name.sql applies a migration and NNN_name.down.sql, where
+// present, reverts it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS